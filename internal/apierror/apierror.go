@@ -0,0 +1,136 @@
+// Package apierror defines the JSON error envelope returned by the proxy and
+// the machine-readable codes clients can branch on.
+package apierror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+)
+
+// Code is a stable, machine-readable error identifier.
+type Code string
+
+const (
+	CodeBadRequest       Code = "BAD_REQUEST"
+	CodeInvalidUserID    Code = "INVALID_USER_ID"
+	CodeInvalidParameter Code = "INVALID_PARAMETER"
+	CodeUnauthorized     Code = "UNAUTHORIZED"
+	CodeNotFound         Code = "NOT_FOUND"
+	CodeMethodNotAllowed Code = "METHOD_NOT_ALLOWED"
+	CodePayloadTooLarge  Code = "PAYLOAD_TOO_LARGE"
+	CodeRateLimited      Code = "RATE_LIMITED"
+	CodeUpstreamTimeout  Code = "UPSTREAM_TIMEOUT"
+	CodeUpstreamError    Code = "UPSTREAM_ERROR"
+	CodeUnavailable      Code = "SERVICE_UNAVAILABLE"
+	CodeInternal         Code = "INTERNAL_ERROR"
+)
+
+// Response is the body of every error response.
+type Response struct {
+	Code      Code   `json:"code"`
+	Error     string `json:"error"`
+	RequestID string `json:"requestId,omitempty"`
+	// Upstream is the error Roblox itself reported, when it sent one.
+	Upstream *UpstreamError `json:"upstream,omitempty"`
+}
+
+// UpstreamError is the first entry of a Roblox {"errors":[...]} body.
+type UpstreamError struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// upstreamDetailer is implemented by errors that carry Roblox's own error.
+type upstreamDetailer interface {
+	UpstreamError() *UpstreamError
+}
+
+// Classify picks the code for err being reported with status. Errors with a
+// specific meaning win; otherwise the code follows the status.
+func Classify(status int, err error) Code {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, proxy.ErrRequestBodyTooLarge):
+		return CodePayloadTooLarge
+	case errors.Is(err, proxy.ErrDraining), errors.Is(err, proxy.ErrUpstreamSaturated):
+		return CodeUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return CodeUpstreamTimeout
+	}
+	return FromStatus(status)
+}
+
+// FromStatus returns the generic code for an HTTP status.
+func FromStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return CodeUnauthorized
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusBadGateway:
+		return CodeUpstreamError
+	case http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// Write sends an error envelope with the given status, code and message.
+func Write(w http.ResponseWriter, status int, code Code, message string) {
+	write(w, status, Response{Code: code, Error: message})
+}
+
+// WriteError classifies err and sends it with status. Errors from a
+// saturated upstream limiter also tell the client when to retry.
+func WriteError(w http.ResponseWriter, status int, err error) {
+	SetRetryAfter(w, err)
+	WriteCode(w, status, Classify(status, err), err)
+}
+
+// WriteCode sends err with the given status and code, including the error
+// Roblox reported when err carries one.
+func WriteCode(w http.ResponseWriter, status int, code Code, err error) {
+	resp := Response{Code: code, Error: err.Error()}
+	var detailer upstreamDetailer
+	if errors.As(err, &detailer) {
+		resp.Upstream = detailer.UpstreamError()
+	}
+	write(w, status, resp)
+}
+
+func write(w http.ResponseWriter, status int, resp Response) {
+	// The access log middleware sets the request ID on the response before
+	// any handler runs, so it can be echoed without threading the context.
+	resp.RequestID = w.Header().Get("X-Request-Id")
+	body, err := json.Marshal(resp)
+	if err != nil {
+		body = []byte(`{"code":"INTERNAL_ERROR","error":"failed to encode error"}`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+// SetRetryAfter sets Retry-After for errors that clear up on their own shortly.
+func SetRetryAfter(w http.ResponseWriter, err error) {
+	if errors.Is(err, proxy.ErrUpstreamSaturated) {
+		w.Header().Set("Retry-After", "1")
+	}
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/tracing"
+)
+
+// Access log field names accepted by PROXY_ACCESS_LOG_FIELDS.
+const (
+	fieldMethod   = "method"
+	fieldPath     = "path"
+	fieldStatus   = "status"
+	fieldBytes    = "bytes"
+	fieldDuration = "duration"
+	fieldUpstream = "upstream"
+	fieldCache    = "cache"
+	fieldRemote   = "remote"
+	fieldRole     = "role"
+)
+
+var defaultAccessLogFields = []string{fieldMethod, fieldPath, fieldStatus, fieldBytes, fieldDuration, fieldUpstream, fieldCache, fieldRemote, fieldRole}
+
+// responseRecorder captures the status code and body size written through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Flush forwards to the underlying writer so streaming responses still flush.
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func instrumentHandler(next http.Handler, logger *slog.Logger, cfg config.Config) http.Handler {
+	fields := cfg.AccessLogFields
+	if len(fields) == 0 {
+		fields = defaultAccessLogFields
+	}
+	latency := metrics.NewHistogram("request_duration_seconds", metrics.LatencyBuckets)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		w.Header().Set("X-Proxy-Role", string(cfg.Role))
+
+		ctx, info := reqmeta.NewContext(r.Context())
+		requestID := r.Header.Get(reqmeta.HeaderRequestID)
+		if !reqmeta.ValidRequestID(requestID) {
+			requestID = reqmeta.NewRequestID()
+		}
+		info.SetRequestID(requestID)
+		w.Header().Set(reqmeta.HeaderRequestID, requestID)
+		ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, r.Header), "proxy.request", trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		// The handler relays the upstream response before returning, so this
+		// covers the whole upstream exchange.
+		elapsed := time.Since(start)
+		if cfg.MetricsExemplars {
+			latency.ObserveExemplar(elapsed.Seconds(), tracing.TraceID(ctx))
+		} else {
+			latency.Observe(elapsed.Seconds())
+		}
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if cfg.SlowRequestThreshold > 0 && elapsed >= cfg.SlowRequestThreshold && !streamingPath(cfg, r.URL.Path) {
+			logger.LogAttrs(ctx, slog.LevelWarn, "slow request",
+				slog.String(fieldMethod, r.Method),
+				slog.String(fieldPath, r.URL.Path),
+				slog.Int(fieldStatus, status),
+				slog.Duration(fieldDuration, elapsed),
+				slog.String(fieldUpstream, info.UpstreamHost()),
+				slog.String(fieldCache, info.CacheResult()),
+			)
+		}
+
+		if span.IsRecording() {
+			span.SetAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.Int("http.response.status_code", status),
+				attribute.String("proxy.upstream", info.UpstreamHost()),
+				attribute.String("proxy.cache", info.CacheResult()),
+			)
+		}
+
+		if !logger.Enabled(ctx, cfg.AccessLogLevel) {
+			return
+		}
+
+		attrs := make([]slog.Attr, 0, len(fields))
+		for _, field := range fields {
+			switch field {
+			case fieldMethod:
+				attrs = append(attrs, slog.String(fieldMethod, r.Method))
+			case fieldPath:
+				attrs = append(attrs, slog.String(fieldPath, r.URL.Path))
+			case fieldStatus:
+				attrs = append(attrs, slog.Int(fieldStatus, status))
+			case fieldBytes:
+				attrs = append(attrs, slog.Int64(fieldBytes, rec.bytes))
+			case fieldDuration:
+				attrs = append(attrs, slog.Duration(fieldDuration, elapsed))
+			case fieldUpstream:
+				attrs = append(attrs, slog.String(fieldUpstream, info.UpstreamHost()))
+			case fieldCache:
+				attrs = append(attrs, slog.String(fieldCache, info.CacheResult()))
+			case fieldRemote:
+				attrs = append(attrs, slog.String(fieldRemote, r.RemoteAddr))
+			case fieldRole:
+				attrs = append(attrs, slog.String(fieldRole, string(cfg.Role)))
+			}
+		}
+
+		logger.LogAttrs(ctx, cfg.AccessLogLevel, "handled request", attrs...)
+	})
+}
+
+// streamingPath reports whether path is one of cfg.StreamingPaths, whose
+// responses stay open by design and so are never reported as slow.
+func streamingPath(cfg config.Config, path string) bool {
+	for _, prefix := range cfg.StreamingPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+)
+
+// serveTraced serves one request through instrumentHandler with a sampling
+// tracer installed, returning the trace ID the handler saw and the request
+// latency histogram.
+func serveTraced(t *testing.T, cfg config.Config) (string, *metrics.Histogram) {
+	t.Helper()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		_ = provider.Shutdown(t.Context())
+	})
+
+	var traceID string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID = trace.SpanContextFromContext(r.Context()).TraceID().String()
+	})
+	handler := instrumentHandler(next, slog.New(slog.DiscardHandler), cfg)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	latency, ok := expvar.Get("proxy").(*expvar.Map).Get("request_duration_seconds").(*metrics.Histogram)
+	if !ok {
+		t.Fatal("request_duration_seconds is not registered")
+	}
+	return traceID, latency
+}
+
+func TestRequestLatencyCarriesTraceExemplar(t *testing.T) {
+	traceID, latency := serveTraced(t, config.Config{MetricsExemplars: true})
+
+	snap := latency.Snapshot()
+	if snap.Count != 1 {
+		t.Fatalf("observations = %d, want 1", snap.Count)
+	}
+	var exemplars []string
+	for _, b := range snap.Buckets {
+		if b.Exemplar != nil {
+			exemplars = append(exemplars, b.Exemplar.TraceID)
+		}
+	}
+	if len(exemplars) != 1 || exemplars[0] != traceID {
+		t.Fatalf("exemplar trace IDs = %q, want [%s]", exemplars, traceID)
+	}
+}
+
+func TestRequestLatencyOmitsExemplarsWhenDisabled(t *testing.T) {
+	_, latency := serveTraced(t, config.Config{})
+
+	snap := latency.Snapshot()
+	if snap.Count != 1 {
+		t.Fatalf("observations = %d, want 1", snap.Count)
+	}
+	for _, b := range snap.Buckets {
+		if b.Exemplar != nil {
+			t.Fatalf("bucket le=%v has exemplar %+v, want none", b.UpperBound, b.Exemplar)
+		}
+	}
+}
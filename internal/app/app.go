@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -9,10 +10,17 @@ import (
 	"os"
 	"time"
 
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache/memorystore"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache/redisstore"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/chaos"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/server"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/tracing"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/transport"
 )
 
@@ -23,56 +31,145 @@ type App struct {
 	cache     cache.Store
 	stopCache func() error
 	httpSrv   *http.Server
+	handler   *server.Handler
+	tracker   *proxy.Tracker
+	certs     *certReloader
+	// stopTracing flushes and stops the trace exporter.
+	stopTracing func(context.Context) error
 }
 
 // New creates a fully initialised application.
 func New(cfg config.Config) (*App, error) {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	logger := slog.New(reqmeta.NewLogHandler(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.LogLevel})))
 
-	redisStore, err := redisstore.New(cfg.RedisURL)
+	stopTracing, err := tracing.Setup(context.Background(), cfg)
 	if err != nil {
-		return nil, fmt.Errorf("setup redis: %w", err)
+		return nil, fmt.Errorf("setup tracing: %w", err)
+	}
+
+	cacheStore, stopCache, err := buildCacheLayer(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	// Injected cache faults sit beneath the breaker so they can trip it.
+	injector := chaos.New(cfg, logger)
+	if injector != nil {
+		cacheStore = injector.Store(cacheStore)
+	}
+	if cfg.TracingEndpoint != "" {
+		cacheStore = cache.Traced(cacheStore)
+	}
+	if cfg.RedisURL != "" && cfg.CacheBreakerThreshold > 0 {
+		breaker := cache.WithBreaker(cacheStore, cfg.CacheBreakerThreshold, cfg.CacheBreakerCooldown, logger)
+		metrics.Gauge("cache_breaker_open", func() int64 {
+			if breaker.Open() {
+				return 1
+			}
+			return 0
+		})
+		cacheStore = breaker
 	}
 
 	httpClient := transport.NewHTTPClient(cfg)
+	if injector != nil {
+		httpClient.Transport = injector.RoundTripper(httpClient.Transport)
+	}
+	tracker := &proxy.Tracker{}
 
-	handler, err := server.NewHandler(cfg, logger, redisStore, httpClient)
+	handler, err := server.NewHandler(cfg, logger, cacheStore, httpClient, tracker)
 	if err != nil {
 		return nil, fmt.Errorf("build handler: %w", err)
 	}
 
+	var root http.Handler = handler
+	if len(cfg.AuthTokens) > 0 {
+		root = server.Authenticate(root, cfg.AuthTokens, cfg.AuthExemptPaths)
+	}
+
 	httpSrv := &http.Server{
 		Addr:              cfg.ListenAddr,
-		Handler:           instrumentHandler(handler, logger, cfg.Role),
+		Handler:           instrumentHandler(drainGuard(root, tracker), logger, cfg),
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       cfg.RequestTimeout + cfg.TransportTimeout,
 		WriteTimeout:      cfg.TransportTimeout + cfg.RequestTimeout,
 		IdleTimeout:       cfg.IdleConnTimeout,
 	}
 
+	var certs *certReloader
+	if cfg.TLSCertFile != "" {
+		certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger)
+		if err != nil {
+			return nil, fmt.Errorf("setup tls: %w", err)
+		}
+		httpSrv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
 	return &App{
-		cfg:       cfg,
-		logger:    logger,
-		cache:     redisStore,
-		stopCache: redisStore.Close,
-		httpSrv:   httpSrv,
+		cfg:         cfg,
+		logger:      logger,
+		cache:       cacheStore,
+		stopCache:   stopCache,
+		httpSrv:     httpSrv,
+		handler:     handler,
+		tracker:     tracker,
+		certs:       certs,
+		stopTracing: stopTracing,
 	}, nil
 }
 
+// buildCacheLayer selects the cache backend: Redis when a URL is configured,
+// otherwise the in-memory LRU. It returns the store and a function closing it.
+func buildCacheLayer(cfg config.Config, logger *slog.Logger) (cache.Store, func() error, error) {
+	if cfg.RedisURL != "" {
+		store, err := redisstore.New(cfg, logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("setup redis: %w", err)
+		}
+		return store, store.Close, nil
+	}
+
+	store := memorystore.New(cfg)
+	return store, store.Close, nil
+}
+
 // Run blocks until the server shuts down or the context is cancelled.
 func (a *App) Run(ctx context.Context) error {
 	errCh := make(chan error, 1)
 	defer func() {
+		// Background refreshes write to the cache, so they are stopped
+		// before it is closed.
+		a.stopBackgroundWork()
 		if a.stopCache != nil {
 			if err := a.stopCache(); err != nil {
 				a.logger.Warn("cache close failed", slog.String("error", err.Error()))
 			}
 		}
+		flushCtx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+		defer cancel()
+		if err := a.stopTracing(flushCtx); err != nil {
+			a.logger.Warn("trace exporter shutdown failed", slog.String("error", err.Error()))
+		}
 	}()
 
+	bgCtx, stopBackground := context.WithCancel(ctx)
+	defer stopBackground()
+	go a.handler.Run(bgCtx)
+	go a.watchConfig(bgCtx)
+	if a.certs != nil {
+		go a.certs.watch(bgCtx, a.cfg.TLSReloadInterval)
+	}
+
 	go func() {
-		a.logger.Info("proxy server starting", slog.String("addr", a.cfg.ListenAddr), slog.String("role", string(a.cfg.Role)))
-		err := a.httpSrv.ListenAndServe()
+		a.logger.Info("proxy server starting", slog.String("addr", a.cfg.ListenAddr), slog.String("role", string(a.cfg.Role)), slog.Bool("tls", a.certs != nil))
+		var err error
+		if a.certs != nil {
+			err = a.httpSrv.ListenAndServeTLS("", "")
+		} else {
+			err = a.httpSrv.ListenAndServe()
+		}
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errCh <- err
 		} else {
@@ -82,7 +179,12 @@ func (a *App) Run(ctx context.Context) error {
 
 	select {
 	case <-ctx.Done():
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// Refreshes hold upstream requests open too; cancel them rather than
+		// let the drain wait for them.
+		a.stopBackgroundWork()
+		a.drain()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
 		defer cancel()
 		return a.httpSrv.Shutdown(shutdownCtx)
 	case err := <-errCh:
@@ -90,17 +192,39 @@ func (a *App) Run(ctx context.Context) error {
 	}
 }
 
-func instrumentHandler(next http.Handler, logger *slog.Logger, role config.Role) http.Handler {
+// stopBackgroundWork cancels the handler's background refreshes and waits,
+// bounded by the shutdown timeout, for them to return. Calling it again
+// returns once any refreshes started since have returned.
+func (a *App) stopBackgroundWork() {
+	ctx, cancel := context.WithTimeout(context.Background(), a.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := a.handler.Shutdown(ctx); err != nil {
+		a.logger.Warn("background work did not stop", slog.String("error", err.Error()))
+	}
+}
+
+// drain rejects new requests and waits, bounded by the drain timeout, for
+// in-flight upstream requests to finish before the server is shut down.
+func (a *App) drain() {
+	a.tracker.StartDrain()
+	a.httpSrv.SetKeepAlivesEnabled(false)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), a.cfg.DrainTimeout)
+	defer cancel()
+
+	a.logger.Info("draining in-flight requests", slog.Duration("timeout", a.cfg.DrainTimeout))
+	if err := a.tracker.Wait(drainCtx); err != nil {
+		a.logger.Warn("drain timed out", slog.String("error", err.Error()))
+	}
+}
+
+func drainGuard(next http.Handler, tracker *proxy.Tracker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		w.Header().Set("X-Proxy-Role", string(role))
+		if tracker.Draining() {
+			w.Header().Set("Connection", "close")
+			apierror.WriteError(w, http.StatusServiceUnavailable, proxy.ErrDraining)
+			return
+		}
 		next.ServeHTTP(w, r)
-		dur := time.Since(start)
-		logger.Debug("handled request",
-			slog.String("method", r.Method),
-			slog.String("path", r.URL.Path),
-			slog.String("remote", r.RemoteAddr),
-			slog.Duration("duration", dur),
-			slog.String("role", string(role)))
 	})
 }
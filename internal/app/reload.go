@@ -0,0 +1,55 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// watchConfig reloads the configuration whenever the process receives SIGHUP,
+// applying the settings listed in config.Reloadable without dropping
+// connections. It returns when ctx is done.
+func (a *App) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// a.cfg keeps the startup configuration; the reloaded one lives here.
+	current := a.cfg
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			current = a.reloadConfig(current)
+		}
+	}
+}
+
+// reloadConfig loads and validates the configuration and swaps its reloadable
+// settings into current, returning the configuration now in effect. An
+// invalid configuration leaves current in place.
+func (a *App) reloadConfig(current config.Config) config.Config {
+	next, err := config.Load()
+	if err != nil {
+		a.logger.Error("config reload failed, keeping current config", slog.String("error", err.Error()))
+		return current
+	}
+
+	merged, restartRequired := config.Reload(current, next)
+	if err := a.handler.Reload(merged); err != nil {
+		a.logger.Error("config reload failed, keeping current config", slog.String("error", err.Error()))
+		return current
+	}
+
+	if len(restartRequired) > 0 {
+		a.logger.Warn("config changes need a restart to take effect", slog.String("fields", strings.Join(restartRequired, ",")))
+	}
+	a.logger.Info("config reloaded", slog.String("reloadable", strings.Join(config.Reloadable, ",")))
+	return merged
+}
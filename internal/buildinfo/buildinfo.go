@@ -0,0 +1,49 @@
+// Package buildinfo exposes the metadata of the running build. Commit and
+// BuildTime are set at link time:
+//
+//	go build -ldflags "-X github.com/NoahCxrest/roblox-proxy-clustering/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/NoahCxrest/roblox-proxy-clustering/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// When they are not set, the VCS details the Go toolchain embeds are used.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	// Commit is the Git commit the binary was built from.
+	Commit string
+	// BuildTime is when the binary was built, in RFC 3339.
+	BuildTime string
+)
+
+// Info describes the running build.
+type Info struct {
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Get returns the build metadata, with "unknown" for anything unavailable.
+func Get() Info {
+	info := Info{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildTime == "" {
+		info.BuildTime = "unknown"
+	}
+	return info
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the store while the circuit
+// breaker is open.
+var ErrCircuitOpen = errors.New("cache store unavailable, circuit open")
+
+// WithBreaker wraps store so that after threshold consecutive failures calls
+// fail fast with ErrCircuitOpen for cooldown. Once the cooldown passes a single
+// call is let through to probe the store; success closes the circuit and
+// failure reopens it.
+func WithBreaker(store Store, threshold int, cooldown time.Duration, logger *slog.Logger) *Breaker {
+	return &Breaker{
+		next:      store,
+		threshold: threshold,
+		cooldown:  cooldown,
+		logger:    logger.With(slog.String("component", "cache-breaker")),
+	}
+}
+
+// Breaker is a Store guarded by a circuit breaker. See WithBreaker.
+type Breaker struct {
+	next      Store
+	threshold int
+	cooldown  time.Duration
+	logger    *slog.Logger
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// Open reports whether calls are currently failing fast.
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold
+}
+
+// allow reports whether a call may reach the store.
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record updates the breaker with the outcome of a call that reached the store.
+// Cancelled calls say nothing about the store and are ignored.
+func (b *Breaker) record(ctx context.Context, err error) {
+	if err != nil && ctx.Err() != nil {
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasOpen := b.failures >= b.threshold
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		if wasOpen {
+			b.logger.Info("cache store recovered, circuit closed")
+		}
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		if !wasOpen {
+			b.logger.Warn("cache store failing, circuit opened", slog.Int("failures", b.failures), slog.Duration("cooldown", b.cooldown), slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (b *Breaker) Get(ctx context.Context, key string) (Entry, bool, error) {
+	if !b.allow() {
+		return Entry{}, false, ErrCircuitOpen
+	}
+	entry, ok, err := b.next.Get(ctx, key)
+	b.record(ctx, err)
+	return entry, ok, err
+}
+
+func (b *Breaker) Set(ctx context.Context, key string, payload []byte, ttl time.Duration) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := b.next.Set(ctx, key, payload, ttl)
+	b.record(ctx, err)
+	return err
+}
+
+func (b *Breaker) SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := b.next.SetEntry(ctx, key, entry, ttl)
+	b.record(ctx, err)
+	return err
+}
+
+func (b *Breaker) Delete(ctx context.Context, key string) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := b.next.Delete(ctx, key)
+	b.record(ctx, err)
+	return err
+}
+
+func (b *Breaker) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	if !b.allow() {
+		return 0, ErrCircuitOpen
+	}
+	n, err := DeletePrefix(ctx, b.next, prefix)
+	if errors.Is(err, ErrUnsupported) {
+		b.record(ctx, nil)
+		return n, err
+	}
+	b.record(ctx, err)
+	return n, err
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"time"
 )
 
@@ -9,10 +10,51 @@ import (
 type Entry struct {
 	Payload  []byte
 	StoredAt time.Time
+	// ExpiresAt marks the end of the entry's freshness window. Stores may keep
+	// returning the entry past this point so callers can serve it if the upstream
+	// fails. A zero value means the entry never expires logically.
+	ExpiresAt time.Time
+	// ContentType describes Payload. An empty value means JSON.
+	ContentType string
+	// ETag and LastModified are the upstream validators of the response the
+	// payload was built from, used for conditional refreshes.
+	ETag         string
+	LastModified string
+}
+
+// Expired reports whether the entry is past its freshness window at now.
+func (e Entry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
 }
 
 // Store describes cache backends capable of storing opaque payloads with TTLs.
 type Store interface {
 	Get(ctx context.Context, key string) (Entry, bool, error)
 	Set(ctx context.Context, key string, payload []byte, ttl time.Duration) error
+	// SetEntry stores the payload and content type of entry. StoredAt and
+	// ExpiresAt are assigned by the store.
+	SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrUnsupported is returned when a store lacks an optional capability.
+var ErrUnsupported = errors.New("operation not supported by cache store")
+
+// PrefixDeleter is implemented by stores that can remove every key under a
+// prefix.
+type PrefixDeleter interface {
+	// DeletePrefix removes all keys starting with prefix and reports how many
+	// were deleted.
+	DeletePrefix(ctx context.Context, prefix string) (int64, error)
+}
+
+// DeletePrefix removes all keys under prefix from store, or returns
+// ErrUnsupported when the store cannot enumerate its keys.
+func DeletePrefix(ctx context.Context, store Store, prefix string) (int64, error) {
+	d, ok := store.(PrefixDeleter)
+	if !ok {
+		return 0, ErrUnsupported
+	}
+	return d.DeletePrefix(ctx, prefix)
 }
@@ -0,0 +1,181 @@
+package memorystore
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+const sweepInterval = time.Minute
+
+// Store implements cache.Store as a bounded in-process LRU. It is intended for
+// single-node deployments that do not run Redis.
+type Store struct {
+	mu         sync.Mutex
+	maxEntries int
+	staleGrace time.Duration
+	entries    map[string]*list.Element
+	order      *list.List
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type item struct {
+	key      string
+	entry    cache.Entry
+	deadline time.Time
+}
+
+// New constructs an in-memory store holding at most cfg.InMemoryCacheSize
+// entries and starts its expiry sweeper.
+func New(cfg config.Config) *Store {
+	s := &Store{
+		maxEntries: cfg.InMemoryCacheSize,
+		staleGrace: cfg.StaleIfErrorWindow,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		stop:       make(chan struct{}),
+	}
+	go s.sweep()
+	return s
+}
+
+// Close stops the expiry sweeper.
+func (s *Store) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	return nil
+}
+
+// Get retrieves a cached entry if present and not past its deadline.
+func (s *Store) Get(_ context.Context, key string) (cache.Entry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return cache.Entry{}, false, nil
+	}
+
+	it := el.Value.(*item)
+	if !it.deadline.IsZero() && time.Now().After(it.deadline) {
+		s.removeElement(el)
+		return cache.Entry{}, false, nil
+	}
+
+	s.order.MoveToFront(el)
+	entry := it.entry
+	entry.Payload = append([]byte(nil), it.entry.Payload...)
+	return entry, true, nil
+}
+
+// Set stores a cached JSON payload with the provided TTL.
+func (s *Store) Set(ctx context.Context, key string, payload []byte, ttl time.Duration) error {
+	return s.SetEntry(ctx, key, cache.Entry{Payload: payload}, ttl)
+}
+
+// SetEntry stores a cached entry with the provided TTL, evicting the least
+// recently used entry when the store is full.
+func (s *Store) SetEntry(_ context.Context, key string, entry cache.Entry, ttl time.Duration) error {
+	now := time.Now().UTC()
+	it := &item{
+		key: key,
+		entry: cache.Entry{
+			Payload:      append([]byte(nil), entry.Payload...),
+			StoredAt:     now,
+			ContentType:  entry.ContentType,
+			ETag:         entry.ETag,
+			LastModified: entry.LastModified,
+		},
+	}
+	if ttl > 0 {
+		it.entry.ExpiresAt = now.Add(ttl)
+		it.deadline = it.entry.ExpiresAt.Add(s.staleGrace)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		el.Value = it
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	for s.order.Len() >= s.maxEntries && s.order.Len() > 0 {
+		s.removeElement(s.order.Back())
+	}
+	s.entries[key] = s.order.PushFront(it)
+	return nil
+}
+
+// Delete removes the entry stored under key, if any.
+func (s *Store) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		s.removeElement(el)
+	}
+	return nil
+}
+
+// DeletePrefix removes every entry whose key starts with prefix.
+func (s *Store) DeletePrefix(_ context.Context, prefix string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for key, el := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			s.removeElement(el)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Len reports the number of entries currently held.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *Store) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.removeExpired(now)
+		}
+	}
+}
+
+func (s *Store) removeExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for el := s.order.Back(); el != nil; {
+		prev := el.Prev()
+		it := el.Value.(*item)
+		if !it.deadline.IsZero() && now.After(it.deadline) {
+			s.removeElement(el)
+		}
+		el = prev
+	}
+}
+
+func (s *Store) removeElement(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*item).key)
+}
@@ -0,0 +1,119 @@
+package redisstore
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis topologies selected by PROXY_REDIS_MODE or the URL scheme.
+const (
+	modeSingle   = "single"
+	modeCluster  = "cluster"
+	modeSentinel = "sentinel"
+)
+
+const defaultSentinelPort = "26379"
+
+// newClient builds a client for the topology of rawURL. The mode comes from
+// forcedMode when set, otherwise from a "+cluster" or "+sentinel" suffix on
+// the URL scheme, e.g. redis+cluster://host:6379?addr=host2:6379. A cluster
+// follows at most max_redirects MOVED or ASK redirects per command (default
+// 3, -1 for none), e.g. redis+cluster://host:6379?max_redirects=5.
+func newClient(rawURL, forcedMode string) (redis.UniversalClient, error) {
+	mode, rawURL := splitMode(rawURL)
+	if forcedMode != "" {
+		mode = forcedMode
+	}
+
+	switch mode {
+	case modeSingle:
+		opts, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse redis url: %w", err)
+		}
+		return redis.NewClient(opts), nil
+	case modeCluster:
+		opts, err := redis.ParseClusterURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse redis cluster url: %w", err)
+		}
+		return redis.NewClusterClient(opts), nil
+	case modeSentinel:
+		opts, err := parseSentinelURL(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse redis sentinel url: %w", err)
+		}
+		return redis.NewFailoverClient(opts), nil
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", mode)
+	}
+}
+
+// splitMode strips a topology suffix from the URL scheme and returns the mode
+// it names along with the plain redis URL.
+func splitMode(raw string) (string, string) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return modeSingle, raw
+	}
+	base, mode, ok := strings.Cut(scheme, "+")
+	if !ok {
+		return modeSingle, raw
+	}
+	return mode, base + "://" + rest
+}
+
+// parseSentinelURL parses redis[s]://[user:pass@]sentinel:port/master, with
+// extra sentinels given as repeated addr parameters. The db and
+// sentinel_password parameters are also honoured.
+func parseSentinelURL(raw string) (*redis.FailoverOptions, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &redis.FailoverOptions{}
+	switch u.Scheme {
+	case "rediss":
+		opts.TLSConfig = &tls.Config{ServerName: u.Hostname()}
+	case "redis":
+	default:
+		return nil, fmt.Errorf("invalid URL scheme: %s", u.Scheme)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if host == "" {
+		return nil, fmt.Errorf("missing sentinel address")
+	}
+	if port == "" {
+		port = defaultSentinelPort
+	}
+	q := u.Query()
+	opts.SentinelAddrs = append([]string{net.JoinHostPort(host, port)}, q["addr"]...)
+
+	opts.MasterName = strings.Trim(u.Path, "/")
+	if opts.MasterName == "" {
+		return nil, fmt.Errorf("missing master name in path")
+	}
+
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	opts.SentinelPassword = q.Get("sentinel_password")
+
+	if db := q.Get("db"); db != "" {
+		opts.DB, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid db %q: %w", db, err)
+		}
+	}
+
+	return opts, nil
+}
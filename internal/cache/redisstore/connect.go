@@ -0,0 +1,52 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+const (
+	// pingTimeout bounds each startup ping.
+	pingTimeout = 2 * time.Second
+	// pingBackoffBase and pingBackoffMax bound the exponential backoff
+	// between startup pings.
+	pingBackoffBase = 250 * time.Millisecond
+	pingBackoffMax  = 5 * time.Second
+)
+
+// ping waits for client to answer, so a proxy started alongside Redis does
+// not crash-loop until it is up. It makes up to cfg.RedisConnectAttempts
+// pings with jittered exponential backoff between them, giving up early
+// rather than waiting past cfg.RedisConnectWait.
+func ping(cfg config.Config, client redis.UniversalClient, logger *slog.Logger) error {
+	deadline := time.Now().Add(cfg.RedisConnectWait)
+	backoff := pingBackoffBase
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err := client.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		// Wait between half and all of the backoff, so instances started
+		// together do not ping in lockstep.
+		delay := backoff/2 + rand.N(backoff/2+1)
+		if attempt >= cfg.RedisConnectAttempts || time.Now().Add(delay).After(deadline) {
+			if attempt > 1 {
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+			return err
+		}
+		logger.Warn("redis not ready, retrying", slog.Int("attempt", attempt), slog.Duration("retry_in", delay), slog.String("error", err.Error()))
+		time.Sleep(delay)
+		backoff = min(backoff*2, pingBackoffMax)
+	}
+}
@@ -0,0 +1,39 @@
+package redisstore
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// formatMsgpack is the leading byte of envelopes encoded with MessagePack.
+// JSON envelopes carry no prefix, so entries written before the format was
+// selectable, which always start with '{', still decode.
+const formatMsgpack byte = 0x01
+
+// encodeEnvelope serializes env in the given config.CacheEncoding format.
+func encodeEnvelope(format string, env envelope) ([]byte, error) {
+	if format != config.CacheEncodingMsgpack {
+		return json.Marshal(env)
+	}
+	data, err := msgpack.Marshal(&env)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{formatMsgpack}, data...), nil
+}
+
+// decodeEnvelope reads an envelope in either format, whichever is configured
+// for writing, so the format can be switched without flushing the cache.
+func decodeEnvelope(data []byte, env *envelope) error {
+	if len(data) == 0 {
+		return errors.New("empty cache entry")
+	}
+	if data[0] == formatMsgpack {
+		return msgpack.Unmarshal(data[1:], env)
+	}
+	return json.Unmarshal(data, env)
+}
@@ -0,0 +1,68 @@
+package redisstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// benchmarkEnvelope is a typical cached user lookup.
+var benchmarkEnvelope = envelope{
+	StoredAt:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	ExpiresAt:   time.Date(2026, 1, 2, 3, 9, 5, 0, time.UTC),
+	ContentType: "application/json",
+	Payload:     []byte(`{"description":"","created":"2006-02-27T21:06:40.3Z","isBanned":false,"hasVerifiedBadge":true,"id":1,"name":"Roblox","displayName":"Roblox","avatarUrl":"https://tr.rbxcdn.com/30DAY-AvatarBust-1-48x48.png"}`),
+	ETag:        `"d41d8cd98f00b204e9800998ecf8427e"`,
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, format := range []string{config.CacheEncodingJSON, config.CacheEncodingMsgpack} {
+		data, err := encodeEnvelope(format, benchmarkEnvelope)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", format, err)
+		}
+		var got envelope
+		if err := decodeEnvelope(data, &got); err != nil {
+			t.Fatalf("%s: decode: %v", format, err)
+		}
+		if !got.StoredAt.Equal(benchmarkEnvelope.StoredAt) || !got.ExpiresAt.Equal(benchmarkEnvelope.ExpiresAt) ||
+			string(got.Payload) != string(benchmarkEnvelope.Payload) || got.ETag != benchmarkEnvelope.ETag {
+			t.Fatalf("%s: round trip = %+v, want %+v", format, got, benchmarkEnvelope)
+		}
+	}
+}
+
+func BenchmarkJSONEnvelope(b *testing.B) {
+	benchmarkEnvelopeFormat(b, config.CacheEncodingJSON)
+}
+
+func BenchmarkMsgpackEnvelope(b *testing.B) {
+	benchmarkEnvelopeFormat(b, config.CacheEncodingMsgpack)
+}
+
+func benchmarkEnvelopeFormat(b *testing.B, format string) {
+	data, err := encodeEnvelope(format, benchmarkEnvelope)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("encode", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := encodeEnvelope(format, benchmarkEnvelope); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("decode", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		for b.Loop() {
+			var env envelope
+			if err := decodeEnvelope(data, &env); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
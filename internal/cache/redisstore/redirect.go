@@ -0,0 +1,130 @@
+package redisstore
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+)
+
+// redirectLogInterval spaces the warnings logged while a cluster keeps
+// redirecting commands.
+const redirectLogInterval = 30 * time.Second
+
+// Redirect kinds, as counted by cache_redis_redirects.
+const (
+	redirectMoved = "moved"
+	redirectAsk   = "ask"
+)
+
+// redirectMonitor watches the MOVED and ASK redirects a cluster client follows
+// while slots migrate. The client retries them itself, up to the max_redirects
+// budget of the cluster URL; the monitor counts every one and warns at most
+// once per redirectLogInterval, so a migration shows up in logs and metrics
+// without flooding either.
+type redirectMonitor struct {
+	logger *slog.Logger
+	// redirects counts redirects by kind.
+	redirects *expvar.Map
+	// exhausted counts commands abandoned after exceeding the redirect
+	// budget.
+	exhausted *expvar.Int
+
+	mu      sync.Mutex
+	lastLog time.Time
+	// unlogged counts redirects since the last warning.
+	unlogged int64
+}
+
+// watchRedirects attaches a redirect monitor to every node of client, which
+// must not have run a command yet. It returns nil unless client is a cluster
+// client.
+func watchRedirects(client redis.UniversalClient, logger *slog.Logger) *redirectMonitor {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return nil
+	}
+	m := &redirectMonitor{
+		logger:    logger,
+		redirects: metrics.LabeledCounter("cache_redis_redirects"),
+		exhausted: metrics.Counter("cache_redis_redirect_misses"),
+	}
+	cluster.OnNewNode(func(node *redis.Client) { node.AddHook(m) })
+	return m
+}
+
+// redirectKind returns the kind of redirect err is, or "" if it is none.
+func redirectKind(err error) string {
+	switch {
+	case redis.HasErrorPrefix(err, "MOVED "):
+		return redirectMoved
+	case redis.HasErrorPrefix(err, "ASK "):
+		return redirectAsk
+	default:
+		return ""
+	}
+}
+
+// exceeded reports whether err is a redirect the client gave up following,
+// counting it if so. A nil monitor never reports one.
+func (m *redirectMonitor) exceeded(err error) bool {
+	if m == nil || redirectKind(err) == "" {
+		return false
+	}
+	m.exhausted.Add(1)
+	return true
+}
+
+func (m *redirectMonitor) observe(ctx context.Context, err error) {
+	kind := redirectKind(err)
+	if kind == "" {
+		return
+	}
+	m.redirects.Add(kind, 1)
+
+	m.mu.Lock()
+	m.unlogged++
+	now := time.Now()
+	if now.Sub(m.lastLog) < redirectLogInterval {
+		m.mu.Unlock()
+		return
+	}
+	count := m.unlogged
+	m.unlogged, m.lastLog = 0, now
+	m.mu.Unlock()
+
+	m.logger.WarnContext(ctx, "redis cluster redirecting commands, slots may be migrating", slog.String("kind", kind), slog.Int64("redirects", count), slog.String("error", err.Error()))
+}
+
+// DialHook implements redis.Hook.
+func (m *redirectMonitor) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+// ProcessHook implements redis.Hook.
+func (m *redirectMonitor) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		m.observe(ctx, err)
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook.
+func (m *redirectMonitor) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			m.observe(ctx, cmd.Err())
+		}
+		return err
+	}
+}
@@ -0,0 +1,203 @@
+package redisstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+)
+
+// movingNode is a fake cluster node that answers every keyed command with a
+// MOVED redirect to target, as a node does while its slots migrate away.
+type movingNode struct {
+	ln     net.Listener
+	target string
+}
+
+func newMovingNode(t *testing.T) *movingNode {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	n := &movingNode{ln: ln}
+	t.Cleanup(func() { _ = ln.Close() })
+	go n.serve()
+	return n
+}
+
+func (n *movingNode) addr() string { return n.ln.Addr().String() }
+
+func (n *movingNode) serve() {
+	for {
+		conn, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+		go n.handle(conn)
+	}
+}
+
+func (n *movingNode) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			reply = "-ERR unknown command 'HELLO'\r\n"
+		case "CLIENT":
+			reply = "+OK\r\n"
+		case "PING":
+			reply = "+PONG\r\n"
+		default:
+			reply = fmt.Sprintf("-MOVED 1234 %s\r\n", n.target)
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected %q", line)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("bad array header %q", line)
+	}
+	args := make([]string, count)
+	for i := range args {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("bad bulk header %q", header)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+// newClusterStore returns a store on a cluster client that maps every slot
+// to node and follows at most maxRedirects redirects.
+func newClusterStore(t *testing.T, node *movingNode, maxRedirects int) *Store {
+	t.Helper()
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{{Start: 0, End: 16383, Nodes: []redis.ClusterNode{{Addr: node.addr()}}}}, nil
+		},
+		MaxRedirects: maxRedirects,
+		DialTimeout:  time.Second,
+		ReadTimeout:  time.Second,
+	})
+	s := &Store{client: client, redirects: watchRedirects(client, slog.New(slog.DiscardHandler))}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func counterValue(v expvar.Var) int64 {
+	if n, ok := v.(*expvar.Int); ok {
+		return n.Value()
+	}
+	return 0
+}
+
+func TestClusterRedirectsAreFollowedAndCounted(t *testing.T) {
+	mr := miniredis.RunT(t)
+	node := newMovingNode(t)
+	node.target = mr.Addr()
+	s := newClusterStore(t, node, 3)
+	ctx := context.Background()
+
+	if err := s.Set(ctx, "roblox:user:1", []byte(`{"id":1}`), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if !mr.Exists("roblox:user:1") {
+		t.Fatalf("redirected set did not reach the target node, keys = %q", mr.Keys())
+	}
+	entry, ok, err := s.Get(ctx, "roblox:user:1")
+	if err != nil || !ok {
+		t.Fatalf("get: ok=%v err=%v", ok, err)
+	}
+	if string(entry.Payload) != `{"id":1}` {
+		t.Fatalf("payload = %s", entry.Payload)
+	}
+
+	if n := counterValue(s.redirects.redirects.Get(redirectMoved)); n != 2 {
+		t.Fatalf("moved redirects = %d, want 2", n)
+	}
+	if n := s.redirects.exhausted.Value(); n != 0 {
+		t.Fatalf("redirect misses = %d, want 0", n)
+	}
+}
+
+func TestExhaustedRedirectBudgetDegradesToMiss(t *testing.T) {
+	const maxRedirects = 2
+	node := newMovingNode(t)
+	// Redirecting back to itself never settles, so every attempt is spent.
+	node.target = node.addr()
+	s := newClusterStore(t, node, maxRedirects)
+	ctx := context.Background()
+
+	_, ok, err := s.Get(ctx, "roblox:user:1")
+	if err != nil || ok {
+		t.Fatalf("get: ok=%v err=%v, want a miss without error", ok, err)
+	}
+	if err := s.SetEntry(ctx, "roblox:user:1", cache.Entry{Payload: []byte(`{}`)}, time.Minute); err != nil {
+		t.Fatalf("set: %v, want the write skipped without error", err)
+	}
+	if n := s.redirects.exhausted.Value(); n != 2 {
+		t.Fatalf("redirect misses = %d, want 2", n)
+	}
+	// Each command is tried once plus maxRedirects times.
+	if n := counterValue(s.redirects.redirects.Get(redirectMoved)); n != 2*(maxRedirects+1) {
+		t.Fatalf("moved redirects = %d, want %d", n, 2*(maxRedirects+1))
+	}
+
+	// Deletes still report the failure.
+	if err := s.Delete(ctx, "roblox:user:1"); err == nil || !strings.Contains(err.Error(), "MOVED ") {
+		t.Fatalf("delete error = %v, want the MOVED error", err)
+	}
+}
+
+func TestNonClusterClientsAreNotWatched(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:0"})
+	defer client.Close()
+	if m := watchRedirects(client, slog.New(slog.DiscardHandler)); m != nil {
+		t.Fatal("watchRedirects returned a monitor for a single-node client")
+	}
+	var m *redirectMonitor
+	if m.exceeded(errors.New("MOVED 1 127.0.0.1:1")) {
+		t.Fatal("a nil monitor treated an error as an exhausted redirect")
+	}
+}
@@ -2,90 +2,311 @@ package redisstore
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
 )
 
+// scanBatchSize is the COUNT hint for each SCAN when deleting by prefix.
+const scanBatchSize = 500
+
 // Store implements cache.Store backed by Redis.
 type Store struct {
-	client *redis.Client
+	client redis.UniversalClient
+	// replica serves Get when a read replica is configured. Nil reads from
+	// client.
+	replica redis.UniversalClient
+	// replicaFallbacks counts reads retried on client after the replica
+	// failed.
+	replicaFallbacks *expvar.Int
+	// staleGrace extends the Redis expiry past the logical TTL so expired
+	// entries remain readable for stale-if-error serving.
+	staleGrace time.Duration
+	// maxKeyBytes bounds the length of keys written to Redis. Zero disables it.
+	maxKeyBytes int
+	// compressMinBytes is the payload size from which payloads are stored
+	// gzip-compressed. Zero disables compression.
+	compressMinBytes int
+	// format is the config.CacheEncoding envelopes are written in. Both
+	// formats are always readable.
+	format string
+	// redirects tracks cluster redirects. Nil unless client is a cluster
+	// client.
+	redirects *redirectMonitor
 }
 
 type envelope struct {
-	StoredAt time.Time       `json:"stored_at"`
-	Payload  json.RawMessage `json:"payload"`
+	StoredAt    time.Time       `json:"stored_at" msgpack:"stored_at"`
+	ExpiresAt   time.Time       `json:"expires_at,omitempty" msgpack:"expires_at,omitempty"`
+	ContentType string          `json:"content_type,omitempty" msgpack:"content_type,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty" msgpack:"payload,omitempty"`
+	// Body carries non-JSON payloads, which cannot be embedded as raw JSON.
+	Body         []byte `json:"body,omitempty" msgpack:"body,omitempty"`
+	ETag         string `json:"etag,omitempty" msgpack:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty" msgpack:"last_modified,omitempty"`
+	// Encoding names the compression applied to Body, if any. Envelopes
+	// written before compression existed leave it empty.
+	Encoding string `json:"encoding,omitempty" msgpack:"encoding,omitempty"`
 }
 
-// New constructs a Redis-backed cache store.
-func New(rawURL string) (*Store, error) {
-	opts, err := redis.ParseURL(rawURL)
+// New constructs a Redis-backed cache store against a single node, a cluster,
+// or a sentinel-managed failover group. It waits for Redis to come up as
+// configured, logging failed attempts to logger.
+func New(cfg config.Config, logger *slog.Logger) (*Store, error) {
+	client, err := newClient(cfg.RedisURL, cfg.RedisMode)
 	if err != nil {
-		return nil, fmt.Errorf("parse redis url: %w", err)
+		return nil, err
 	}
 
-	client := redis.NewClient(opts)
+	logger = logger.With(slog.String("component", "redis"))
+	// Node hooks only reach nodes created afterwards, so watch before ping.
+	redirects := watchRedirects(client, logger)
+	if err := ping(cfg, client, logger); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("redis ping failed: %w", err)
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+	s := &Store{
+		client:           client,
+		staleGrace:       cfg.StaleIfErrorWindow,
+		maxKeyBytes:      cfg.MaxCacheKeyBytes,
+		compressMinBytes: cfg.CacheCompressMinBytes,
+		format:           cfg.CacheEncoding,
+		redirects:        redirects,
+	}
 
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("redis ping failed: %w", err)
+	if cfg.RedisReplicaURL != "" {
+		replica, err := newClient(cfg.RedisReplicaURL, "")
+		if err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("redis replica: %w", err)
+		}
+		if err := ping(cfg, replica, logger.With(slog.Bool("replica", true))); err != nil {
+			_ = replica.Close()
+			_ = client.Close()
+			return nil, fmt.Errorf("redis replica ping failed: %w", err)
+		}
+		s.replica = replica
+		s.replicaFallbacks = metrics.Counter("cache_replica_fallbacks")
 	}
 
-	return &Store{client: client}, nil
+	return s, nil
 }
 
 // Client returns the underlying redis client.
-func (s *Store) Client() *redis.Client {
+func (s *Store) Client() redis.UniversalClient {
 	return s.client
 }
 
 // Close terminates the underlying Redis client connections.
 func (s *Store) Close() error {
-	return s.client.Close()
+	if s.replica == nil {
+		return s.client.Close()
+	}
+	return errors.Join(s.client.Close(), s.replica.Close())
 }
 
-// Get retrieves a cached entry if present.
+// Get retrieves a cached entry if present. With a replica configured the
+// replica is read first and the primary only when the replica fails; a
+// replica miss is a miss, even if the primary has since been written.
 func (s *Store) Get(ctx context.Context, key string) (cache.Entry, bool, error) {
-	data, err := s.client.Get(ctx, key).Bytes()
+	storageKey := s.storageKey(key)
+	var data []byte
+	var err error
+	if s.replica != nil {
+		data, err = s.replica.Get(ctx, storageKey).Bytes()
+		if err != nil && err != redis.Nil && ctx.Err() == nil {
+			s.replicaFallbacks.Add(1)
+			data, err = s.client.Get(ctx, storageKey).Bytes()
+		}
+	} else {
+		data, err = s.client.Get(ctx, storageKey).Bytes()
+	}
 	if err != nil {
-		if err == redis.Nil {
+		// A key still redirected once the redirect budget is spent sits in a
+		// migrating slot; read it as a miss rather than fail the lookup.
+		if err == redis.Nil || s.redirects.exceeded(err) {
 			return cache.Entry{}, false, nil
 		}
 		return cache.Entry{}, false, fmt.Errorf("redis get %q: %w", key, err)
 	}
 
 	var env envelope
-	if err := json.Unmarshal(data, &env); err != nil {
+	if err := decodeEnvelope(data, &env); err != nil {
 		return cache.Entry{}, false, fmt.Errorf("decode cached payload %q: %w", key, err)
 	}
 
+	payload := []byte(env.Payload)
+	if len(env.Body) > 0 {
+		payload = append([]byte(nil), env.Body...)
+	}
+	if env.Encoding != "" {
+		if payload, err = decompress(env.Encoding, env.Body); err != nil {
+			return cache.Entry{}, false, fmt.Errorf("decompress cached payload %q: %w", key, err)
+		}
+	}
+
 	return cache.Entry{
-		Payload:  append([]byte(nil), env.Payload...),
-		StoredAt: env.StoredAt,
+		Payload:      payload,
+		StoredAt:     env.StoredAt,
+		ExpiresAt:    env.ExpiresAt,
+		ContentType:  env.ContentType,
+		ETag:         env.ETag,
+		LastModified: env.LastModified,
 	}, true, nil
 }
 
-// Set stores a cached entry with the provided TTL.
+// Set stores a cached JSON payload with the provided TTL.
 func (s *Store) Set(ctx context.Context, key string, payload []byte, ttl time.Duration) error {
+	return s.SetEntry(ctx, key, cache.Entry{Payload: payload}, ttl)
+}
+
+// SetEntry stores a cached entry with the provided TTL. The key is kept in Redis
+// for an additional stale grace period after the entry expires.
+func (s *Store) SetEntry(ctx context.Context, key string, entry cache.Entry, ttl time.Duration) error {
+	now := time.Now().UTC()
 	env := envelope{
-		StoredAt: time.Now().UTC(),
-		Payload:  append([]byte(nil), payload...),
+		StoredAt:     now,
+		ContentType:  entry.ContentType,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+	}
+	switch {
+	case s.compressMinBytes > 0 && len(entry.Payload) >= s.compressMinBytes:
+		compressed, err := compress(entry.Payload)
+		if err != nil {
+			return fmt.Errorf("compress cached payload %q: %w", key, err)
+		}
+		env.Body = compressed
+		env.Encoding = encodingGzip
+	case isJSON(entry.ContentType):
+		env.Payload = append([]byte(nil), entry.Payload...)
+	default:
+		env.Body = append([]byte(nil), entry.Payload...)
+	}
+	if ttl > 0 {
+		env.ExpiresAt = now.Add(ttl)
+		ttl += s.staleGrace
 	}
 
-	data, err := json.Marshal(env)
+	data, err := encodeEnvelope(s.format, env)
 	if err != nil {
 		return fmt.Errorf("encode cached payload %q: %w", key, err)
 	}
 
-	if err := s.client.Set(ctx, key, data, ttl).Err(); err != nil {
+	if err := s.client.Set(ctx, s.storageKey(key), data, ttl).Err(); err != nil {
+		// Skipping a write to a migrating slot only costs a later miss.
+		if s.redirects.exceeded(err) {
+			return nil
+		}
 		return fmt.Errorf("redis set %q: %w", key, err)
 	}
 
 	return nil
 }
+
+// Delete removes the entry stored under key, if any.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, s.storageKey(key)).Err(); err != nil {
+		return fmt.Errorf("redis del %q: %w", key, err)
+	}
+	return nil
+}
+
+// DeletePrefix removes every key under prefix using SCAN and batched DEL, so
+// Redis is never blocked by a single large command. On a cluster every master
+// is scanned.
+func (s *Store) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	if cluster, ok := s.client.(*redis.ClusterClient); ok {
+		var deleted atomic.Int64
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			n, err := deletePrefixOnNode(ctx, node, prefix)
+			deleted.Add(n)
+			return err
+		})
+		return deleted.Load(), err
+	}
+	return deletePrefixOnNode(ctx, s.client, prefix)
+}
+
+func deletePrefixOnNode(ctx context.Context, client redis.Cmdable, prefix string) (int64, error) {
+	pattern := escapeGlob(prefix) + "*"
+
+	var (
+		cursor  uint64
+		deleted int64
+	)
+	for {
+		keys, next, err := client.Scan(ctx, cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			return deleted, fmt.Errorf("redis scan %q: %w", prefix, err)
+		}
+		// DEL per key keeps cluster slots happy; pipelining avoids a round trip each.
+		if len(keys) > 0 {
+			pipe := client.Pipeline()
+			cmds := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = pipe.Del(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return deleted, fmt.Errorf("redis del %q: %w", prefix, err)
+			}
+			for _, cmd := range cmds {
+				deleted += cmd.Val()
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
+// escapeGlob escapes the characters SCAN MATCH treats as pattern syntax.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// storageKey maps key onto the Redis key it is stored under. Keys longer than
+// maxKeyBytes keep a readable prefix and end in a SHA-256 digest of the full
+// key, so Get and Set always agree while the stored key stays bounded.
+func (s *Store) storageKey(key string) string {
+	if s.maxKeyBytes <= 0 || len(key) <= s.maxKeyBytes {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	digest := hex.EncodeToString(sum[:])
+	keep := s.maxKeyBytes - len(digest) - 1
+	return key[:keep] + "#" + digest
+}
+
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
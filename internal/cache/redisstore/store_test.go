@@ -0,0 +1,106 @@
+package redisstore
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// newTestStore returns a store backed by a fresh miniredis server. cfg is
+// completed with the Redis URL and a single connect attempt.
+func newTestStore(t *testing.T, cfg config.Config) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	cfg.RedisURL = "redis://" + mr.Addr()
+	cfg.RedisConnectAttempts = 1
+	cfg.RedisConnectWait = time.Second
+
+	s, err := New(cfg, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s, mr
+}
+
+func TestOversizedKeysAreHashedConsistently(t *testing.T) {
+	const maxKeyBytes = 128
+	s, mr := newTestStore(t, config.Config{MaxCacheKeyBytes: maxKeyBytes})
+	ctx := context.Background()
+
+	prefix := "roblox:search:" + strings.Repeat("a", 200)
+	keys := []string{prefix + ":limit=10", prefix + ":limit=20"}
+	for i, key := range keys {
+		payload := []byte(`{"n":` + strconv.Itoa(i) + `}`)
+		if err := s.Set(ctx, key, payload, time.Minute); err != nil {
+			t.Fatalf("set %d: %v", i, err)
+		}
+	}
+
+	for i, key := range keys {
+		entry, ok, err := s.Get(ctx, key)
+		if err != nil || !ok {
+			t.Fatalf("get %d: ok=%v err=%v", i, ok, err)
+		}
+		if want := `{"n":` + strconv.Itoa(i) + `}`; string(entry.Payload) != want {
+			t.Fatalf("get %d payload = %s, want %s", i, entry.Payload, want)
+		}
+	}
+
+	stored := mr.Keys()
+	if len(stored) != len(keys) {
+		t.Fatalf("stored %d keys, want %d distinct keys: %q", len(stored), len(keys), stored)
+	}
+	for _, key := range stored {
+		if len(key) > maxKeyBytes {
+			t.Errorf("stored key is %d bytes, want at most %d: %q", len(key), maxKeyBytes, key)
+		}
+		if !strings.HasPrefix(key, "roblox:search:") {
+			t.Errorf("stored key %q lost its readable prefix", key)
+		}
+	}
+}
+
+func TestShortKeysAreStoredVerbatim(t *testing.T) {
+	s, mr := newTestStore(t, config.Config{MaxCacheKeyBytes: 128})
+
+	if err := s.Set(context.Background(), "roblox:user:1", []byte(`{}`), time.Minute); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if !mr.Exists("roblox:user:1") {
+		t.Fatalf("stored keys = %q, want the key unchanged", mr.Keys())
+	}
+}
+
+func TestDeletePrefixOnlyRemovesPrefixedKeys(t *testing.T) {
+	s, mr := newTestStore(t, config.Config{})
+	ctx := context.Background()
+
+	// Enough keys to take several SCAN pages, plus a glob metacharacter in
+	// the prefix that must match literally.
+	const prefixed = 2*scanBatchSize + 7
+	for i := 0; i < prefixed; i++ {
+		mr.Set("p*x:user:"+strconv.Itoa(i), "{}")
+	}
+	for _, key := range []string{"pAx:user:1", "other:p*x:user:1", "p*y:user:1"} {
+		mr.Set(key, "{}")
+	}
+
+	deleted, err := s.DeletePrefix(ctx, "p*x:")
+	if err != nil {
+		t.Fatalf("DeletePrefix: %v", err)
+	}
+	if deleted != prefixed {
+		t.Fatalf("deleted = %d, want %d", deleted, prefixed)
+	}
+	if keys := mr.Keys(); len(keys) != 3 {
+		t.Fatalf("remaining keys = %q, want only the unprefixed ones", keys)
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/tracing"
+)
+
+// Traced wraps store so every Get and Set is recorded as a span.
+func Traced(store Store) Store {
+	return tracedStore{next: store}
+}
+
+type tracedStore struct {
+	next Store
+}
+
+func (s tracedStore) Get(ctx context.Context, key string) (Entry, bool, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "cache.get")
+	entry, ok, err := s.next.Get(ctx, key)
+	span.SetAttributes(attribute.String("cache.key", key), attribute.Bool("cache.found", ok))
+	tracing.EndSpan(span, err)
+	return entry, ok, err
+}
+
+func (s tracedStore) Set(ctx context.Context, key string, payload []byte, ttl time.Duration) error {
+	ctx, span := tracing.Tracer().Start(ctx, "cache.set")
+	err := s.next.Set(ctx, key, payload, ttl)
+	span.SetAttributes(attribute.String("cache.key", key), attribute.Int("cache.size", len(payload)))
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (s tracedStore) SetEntry(ctx context.Context, key string, entry Entry, ttl time.Duration) error {
+	ctx, span := tracing.Tracer().Start(ctx, "cache.set")
+	err := s.next.SetEntry(ctx, key, entry, ttl)
+	span.SetAttributes(attribute.String("cache.key", key), attribute.Int("cache.size", len(entry.Payload)))
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (s tracedStore) Delete(ctx context.Context, key string) error {
+	ctx, span := tracing.Tracer().Start(ctx, "cache.delete")
+	err := s.next.Delete(ctx, key)
+	span.SetAttributes(attribute.String("cache.key", key))
+	tracing.EndSpan(span, err)
+	return err
+}
+
+func (s tracedStore) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "cache.delete_prefix")
+	n, err := DeletePrefix(ctx, s.next, prefix)
+	span.SetAttributes(attribute.String("cache.prefix", prefix), attribute.Int64("cache.deleted", n))
+	tracing.EndSpan(span, err)
+	return n, err
+}
@@ -0,0 +1,155 @@
+// Package chaos injects faults into upstream requests and cache calls, so
+// retries, fallbacks, the cache breaker and fail-open serving can be
+// exercised outside production.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+)
+
+var (
+	// ErrInjectedUpstream is returned in place of an upstream response.
+	ErrInjectedUpstream = errors.New("chaos: injected upstream error")
+	// ErrInjectedCache is returned in place of a cache result.
+	ErrInjectedCache = errors.New("chaos: injected cache error")
+)
+
+// Injector decides which calls fail and logs every fault it injects.
+type Injector struct {
+	upstreamErrorRate float64
+	latencyRate       float64
+	latency           time.Duration
+	cacheErrorRate    float64
+	logger            *slog.Logger
+	// injected counts injected faults by kind.
+	injected *expvar.Map
+}
+
+// New returns an injector for cfg, or nil when chaos mode is disabled.
+func New(cfg config.Config, logger *slog.Logger) *Injector {
+	if !cfg.ChaosEnabled {
+		return nil
+	}
+	i := &Injector{
+		upstreamErrorRate: cfg.ChaosUpstreamErrorRate,
+		latencyRate:       cfg.ChaosLatencyRate,
+		latency:           cfg.ChaosLatency,
+		cacheErrorRate:    cfg.ChaosCacheErrorRate,
+		logger:            logger.With(slog.String("component", "chaos")),
+		injected:          metrics.LabeledCounter("chaos_faults_injected"),
+	}
+	i.logger.Warn("chaos mode enabled: faults will be injected",
+		slog.Float64("upstream_error_rate", i.upstreamErrorRate),
+		slog.Float64("latency_rate", i.latencyRate),
+		slog.Duration("latency", i.latency),
+		slog.Float64("cache_error_rate", i.cacheErrorRate),
+	)
+	return i
+}
+
+// inject reports whether to inject a fault at rate, logging it as kind when
+// it does.
+func (i *Injector) inject(ctx context.Context, rate float64, kind string, attrs ...slog.Attr) bool {
+	if rate <= 0 || rand.Float64() >= rate {
+		return false
+	}
+	i.injected.Add(kind, 1)
+	i.logger.LogAttrs(ctx, slog.LevelWarn, "chaos fault injected", append([]slog.Attr{slog.String("fault", kind)}, attrs...)...)
+	return true
+}
+
+// RoundTripper wraps next so upstream requests are delayed or failed at the
+// configured rates.
+func (i *Injector) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	return roundTripper{next: next, injector: i}
+}
+
+type roundTripper struct {
+	next     http.RoundTripper
+	injector *Injector
+}
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	i, ctx := rt.injector, req.Context()
+	if i.inject(ctx, i.latencyRate, "upstream_latency", slog.String("host", req.URL.Host), slog.Duration("latency", i.latency)) {
+		timer := time.NewTimer(i.latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+	if i.inject(ctx, i.upstreamErrorRate, "upstream_error", slog.String("host", req.URL.Host), slog.String("path", req.URL.Path)) {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, ErrInjectedUpstream
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// CloseIdleConnections forwards to next, so clients can still drop idle
+// connections.
+func (rt roundTripper) CloseIdleConnections() {
+	if c, ok := rt.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// Store wraps next so cache calls fail at the configured rate.
+func (i *Injector) Store(next cache.Store) cache.Store {
+	return store{next: next, injector: i}
+}
+
+type store struct {
+	next     cache.Store
+	injector *Injector
+}
+
+func (s store) fail(ctx context.Context, op, key string) bool {
+	return s.injector.inject(ctx, s.injector.cacheErrorRate, "cache_error", slog.String("op", op), slog.String("key", key))
+}
+
+func (s store) Get(ctx context.Context, key string) (cache.Entry, bool, error) {
+	if s.fail(ctx, "get", key) {
+		return cache.Entry{}, false, ErrInjectedCache
+	}
+	return s.next.Get(ctx, key)
+}
+
+func (s store) Set(ctx context.Context, key string, payload []byte, ttl time.Duration) error {
+	if s.fail(ctx, "set", key) {
+		return ErrInjectedCache
+	}
+	return s.next.Set(ctx, key, payload, ttl)
+}
+
+func (s store) SetEntry(ctx context.Context, key string, entry cache.Entry, ttl time.Duration) error {
+	if s.fail(ctx, "set", key) {
+		return ErrInjectedCache
+	}
+	return s.next.SetEntry(ctx, key, entry, ttl)
+}
+
+func (s store) Delete(ctx context.Context, key string) error {
+	if s.fail(ctx, "delete", key) {
+		return ErrInjectedCache
+	}
+	return s.next.Delete(ctx, key)
+}
+
+// DeletePrefix is never failed: it only serves the admin flush.
+func (s store) DeletePrefix(ctx context.Context, prefix string) (int64, error) {
+	return cache.DeletePrefix(ctx, s.next, prefix)
+}
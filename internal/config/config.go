@@ -2,15 +2,22 @@ package config
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
 )
 
 // Role identifies the runtime mode for the proxy service.
@@ -21,52 +28,485 @@ const (
 	RoleMember   Role = "member"
 )
 
+// TTL jitter modes accepted by PROXY_CACHE_TTL_JITTER_MODE.
+const (
+	JitterModeRandom = "random"
+	JitterModeHash   = "hash"
+)
+
+// User payload validation modes accepted by PROXY_USER_PAYLOAD_VALIDATION.
+const (
+	// UserValidationOff returns user payloads as assembled.
+	UserValidationOff = "off"
+	// UserValidationRequired rejects payloads without an id or name.
+	UserValidationRequired = "required"
+	// UserValidationStrict additionally requires displayName and created.
+	UserValidationStrict = "strict"
+)
+
+// Forwarding header styles accepted by PROXY_FORWARDED_HEADERS.
+const (
+	// ForwardedHeadersLegacy sets X-Forwarded-For, -Proto and -Host.
+	ForwardedHeadersLegacy = "x-forwarded"
+	// ForwardedHeadersRFC7239 sets the standard Forwarded header instead.
+	ForwardedHeadersRFC7239 = "forwarded"
+	// ForwardedHeadersBoth sets both.
+	ForwardedHeadersBoth = "both"
+)
+
+// Upstream replay modes accepted by PROXY_UPSTREAM_REPLAY_MODE.
 const (
-	defaultListenAddr          = ":8080"
-	defaultRequestTimeout      = 6 * time.Second
-	defaultTransportTimeout    = 15 * time.Second
-	defaultDialTimeout         = 750 * time.Millisecond
-	defaultIdleConnTimeout     = 90 * time.Second
-	defaultMaxIdleConns        = 512
-	defaultMaxIdleConnsPerHost = 256
-	defaultBackgroundRefresh   = 5 * time.Hour
-	defaultCacheTTL            = 30 * 24 * time.Hour
+	// UpstreamReplayOff calls Roblox as usual.
+	UpstreamReplayOff = "off"
+	// UpstreamReplayReplay serves lookups from recorded responses only.
+	UpstreamReplayReplay = "replay"
+	// UpstreamReplayRecord calls Roblox and records what it returns.
+	UpstreamReplayRecord = "record"
 )
 
+// Avatar failure modes accepted by PROXY_USER_AVATAR_FAILURE.
+const (
+	// AvatarFailureStrict fails a user lookup whose avatar fetch fails.
+	AvatarFailureStrict = "strict"
+	// AvatarFailureLenient returns the user with an empty avatar instead.
+	AvatarFailureLenient = "lenient"
+)
+
+// Redis envelope encodings accepted by PROXY_CACHE_ENCODING.
+const (
+	CacheEncodingJSON    = "json"
+	CacheEncodingMsgpack = "msgpack"
+)
+
+const (
+	defaultListenAddr            = ":8080"
+	defaultRequestTimeout        = 6 * time.Second
+	defaultTransportTimeout      = 15 * time.Second
+	defaultDialTimeout           = 750 * time.Millisecond
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultDialKeepAlive         = 60 * time.Second
+	defaultAdminFlushTimeout     = 10 * time.Second
+	defaultMaxRedirects          = 10
+	defaultMaxIdleConns          = 512
+	defaultMaxIdleConnsPerHost   = 256
+	defaultBackgroundRefresh     = 5 * time.Hour
+	defaultCacheTTL              = 30 * 24 * time.Hour
+	defaultAvatarImageTTL        = 6 * time.Hour
+	defaultMaxRequestBodyBytes   = 1 << 20
+	defaultCopyBufferBytes       = 32 << 10
+	defaultStreamFlushBytes      = 64 << 10
+	defaultMemberFallbacks       = 1
+	defaultUpstreamQueueTimeout  = 250 * time.Millisecond
+	defaultCacheBreakerThreshold = 5
+	defaultBatchMaxOperations    = 50
+	defaultBatchConcurrency      = 8
+	defaultShadowFraction        = 0.05
+	defaultShadowConcurrency     = 16
+	defaultCoalesceMaxBytes      = 1 << 20
+	defaultDNSCacheTTL           = time.Minute
+	defaultMaxUpstreamResponse   = 8 << 20
+	defaultCacheBreakerCooldown  = 10 * time.Second
+	defaultMaxCacheKeyBytes      = 1024
+	minMaxCacheKeyBytes          = 128
+	defaultDrainTimeout          = 15 * time.Second
+	defaultShutdownTimeout       = 5 * time.Second
+	defaultHealthInterval        = 30 * time.Second
+	defaultTLSReloadInterval     = time.Minute
+	defaultRateLimitBurst        = 20
+	defaultThumbnailBurst        = 10
+	defaultRefreshBurst          = 10
+	defaultPopularityThreshold   = 32
+	defaultCacheKeyPrefix        = "roblox:"
+	defaultUserAgent             = "RobloxProxyCluster/1.0"
+	defaultRobloxBaseDomain      = "roblox.com"
+	// chaosAcknowledgement must be the value of PROXY_CHAOS_ACKNOWLEDGE for
+	// PROXY_CHAOS_ENABLED to take effect.
+	chaosAcknowledgement = "inject-faults"
+	// replayAcknowledgement must be the value of
+	// PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE for PROXY_UPSTREAM_REPLAY_MODE to
+	// take effect.
+	replayAcknowledgement          = "not-production"
+	defaultRedisConnectAttempts    = 5
+	defaultRedisConnectWait        = 30 * time.Second
+	defaultWarmupConcurrency       = 8
+	defaultMaxBackgroundRefreshes  = 256
+	defaultSearchMaxPages          = 5
+	defaultSearchAvatarConcurrency = 8
+	defaultRateLimitMaxClients     = 100000
+)
+
+// defaultRobloxServices are the public Roblox API subdomains proxied unless
+// PROXY_ROBLOX_SERVICES says otherwise.
+var defaultRobloxServices = []string{
+	"accountinformation", "accountsettings", "adconfiguration", "apis", "assetdelivery",
+	"auth", "avatar", "badges", "billing", "catalog", "chat", "clientsettings",
+	"contacts", "develop", "economy", "engagementpayouts", "followings", "friends",
+	"gamejoin", "gamepersistence", "games", "groups", "inventory", "itemconfiguration",
+	"locale", "notifications", "points", "premiumfeatures", "presence",
+	"privatemessages", "publish", "search", "thumbnails", "trades", "translations",
+	"twostepverification", "users", "voice", "www",
+}
+
+// HealthProbe configures how one kind of upstream target is health checked.
+type HealthProbe struct {
+	Path           string
+	Method         string
+	ExpectedStatus int
+	Interval       time.Duration
+}
+
+// RouteRateLimit is the per-client rate limit of one route.
+type RouteRateLimit struct {
+	PerSecond float64
+	Burst     int
+}
+
+// CacheRule marks proxied GET paths matching Pattern as cacheable for TTL.
+type CacheRule struct {
+	// Pattern is a path.Match glob, where * does not cross a slash. A
+	// trailing "/**" matches everything below the prefix.
+	Pattern string
+	TTL     time.Duration
+}
+
+// Matches reports whether the request path p falls under the rule.
+func (r CacheRule) Matches(p string) bool {
+	if prefix, ok := strings.CutSuffix(r.Pattern, "/**"); ok {
+		return strings.HasPrefix(p, prefix+"/")
+	}
+	ok, _ := path.Match(r.Pattern, p)
+	return ok
+}
+
 // Config aggregates runtime configuration derived from environment variables.
 type Config struct {
-	Role                   Role
-	ListenAddr             string
-	ProviderClusters       []string
-	MemberClusters         []string
-	RedisURL               string
-	RequestTimeout         time.Duration
-	TransportTimeout       time.Duration
-	DialTimeout            time.Duration
-	IdleConnTimeout        time.Duration
-	MaxIdleConns           int
-	MaxIdleConnsPerHost    int
-	BackgroundRefreshAfter time.Duration
-	CacheTTL               time.Duration
-	DiscordWebhookURL      string
-}
-
-// Load parses environment variables and returns a validated Config.
+	Role                     Role
+	ListenAddr               string
+	ProviderClusters         []string
+	MemberClusters           []string
+	RedisURL                 string
+	RequestTimeout           time.Duration
+	TransportTimeout         time.Duration
+	DialTimeout              time.Duration
+	IdleConnTimeout          time.Duration
+	MaxIdleConns             int
+	MaxIdleConnsPerHost      int
+	BackgroundRefreshAfter   time.Duration
+	CacheTTL                 time.Duration
+	DiscordWebhookURL        string
+	MaxRequestBodyBytes      int64
+	StaleIfErrorWindow       time.Duration
+	MaxCacheKeyBytes         int
+	RateLimitPerSecond       float64
+	RateLimitBurst           int
+	RateLimitMaxClients      int
+	ReservedPaths            []string
+	DrainTimeout             time.Duration
+	ShutdownTimeout          time.Duration
+	CacheLogLevel            slog.Level
+	AvatarImageCaching       bool
+	AvatarImageTTL           time.Duration
+	WriteAllowedSubdomains   []string
+	MemberHeaderTemplates    map[string]map[string]string
+	HealthChecksEnabled      bool
+	HealthStatic             HealthProbe
+	HealthDirect             HealthProbe
+	HealthProvider           HealthProbe
+	InMemoryCacheSize        int
+	PrefetchAvatars          bool
+	LogLevel                 slog.Level
+	AccessLogLevel           slog.Level
+	AccessLogFields          []string
+	MaxConcurrentFetches     int
+	TLSCertFile              string
+	TLSKeyFile               string
+	TLSReloadInterval        time.Duration
+	StripRequestHeaders      []string
+	AddRequestHeaders        map[string]string
+	ValidateRawJSON          bool
+	ThumbnailConcurrency     int
+	ThumbnailRatePerSecond   float64
+	ThumbnailBurst           int
+	TracingEndpoint          string
+	TracingSampleRatio       float64
+	RefreshRateLimit         float64
+	RefreshBurst             int
+	CacheTTLMin              time.Duration
+	CacheTTLMax              time.Duration
+	PopularityThreshold      int
+	RedisMode                string
+	CacheKeyPrefix           string
+	AdminToken               string
+	WarmupFile               string
+	WarmupConcurrency        int
+	SearchMaxResults         int
+	SearchMaxPages           int
+	SearchAvatarConcurrency  int
+	CacheTTLJitter           float64
+	CacheTTLJitterMode       string
+	MaxBackgroundRefreshes   int
+	DisableBackgroundRefresh bool
+	DebugEndpoints           bool
+	AuthTokens               []string
+	AuthExemptPaths          []string
+	CopyBufferBytes          int
+	StreamFlushBytes         int
+	// MaxConcurrentUpstream bounds upstream requests in flight across the
+	// process. Zero is unbounded.
+	MaxConcurrentUpstream int
+	// UpstreamQueueTimeout is how long a request waits for an upstream slot.
+	UpstreamQueueTimeout time.Duration
+	// MemberFallbacks caps how many further targets a member tries after the
+	// one chosen for a request fails.
+	MemberFallbacks int
+	// UpstreamHTTP2 negotiates HTTP/2 over TLS with upstreams.
+	UpstreamHTTP2 bool
+	// UpstreamH2C speaks cleartext HTTP/2 with prior knowledge to http://
+	// upstreams. Only enable it for trusted internal hops that support h2c.
+	UpstreamH2C bool
+	// SearchEmbedAvatars is the default for the search embedAvatars parameter.
+	SearchEmbedAvatars bool
+	// CacheFailOpen treats cache read errors as misses instead of failing the
+	// request.
+	CacheFailOpen bool
+	// CacheBreakerThreshold is the number of consecutive cache errors that
+	// open the circuit breaker. Zero disables the breaker.
+	CacheBreakerThreshold int
+	// CacheBreakerCooldown is how long the breaker stays open before probing
+	// the cache again.
+	CacheBreakerCooldown time.Duration
+	// ServiceTimeouts overrides RequestTimeout for upstream calls to a Roblox
+	// service, keyed by subdomain (e.g. "thumbnails").
+	ServiceTimeouts map[string]time.Duration
+	// BatchMaxOperations caps the operations accepted by one batch request.
+	BatchMaxOperations int
+	// BatchConcurrency bounds the operations of a batch run at once.
+	BatchConcurrency int
+	// CacheCompressMinBytes is the payload size from which Redis entries are
+	// stored gzip-compressed. Zero disables compression.
+	CacheCompressMinBytes int
+	// RobloxAuthEnabled turns on injection of RobloxCookie as .ROBLOSECURITY
+	// into direct requests to RobloxAuthPaths. The cookie is ignored otherwise.
+	RobloxAuthEnabled bool
+	// RobloxCookie is the Roblox session cookie. It must never be logged.
+	RobloxCookie string
+	// RobloxAuthPaths are allow-listed "subdomain/path" prefixes, e.g.
+	// "friends" or "inventory/v2/users".
+	RobloxAuthPaths []string
+	// StreamingPaths are request path prefixes whose responses are relayed
+	// without an upstream timeout and flushed chunk by chunk, for long-poll
+	// and server-sent event endpoints.
+	StreamingPaths []string
+	// CacheablePaths lists generic proxy paths whose successful GET responses
+	// are cached, most specific first.
+	CacheablePaths []CacheRule
+	// ShadowTargets are http(s) upstreams, such as a cluster node being
+	// brought into service, that are sent copies of sampled read requests.
+	// Their responses are discarded.
+	ShadowTargets []string
+	// ShadowFraction is the share of requests, between 0 and 1, mirrored to
+	// ShadowTargets.
+	ShadowFraction float64
+	// ShadowConcurrency bounds the mirrored requests in flight; requests
+	// sampled beyond it are not mirrored.
+	ShadowConcurrency int
+	// UserPayloadValidation is how strictly combined user payloads are
+	// checked before they are cached: one of the UserValidation modes.
+	UserPayloadValidation string
+	// CoalesceMaxBytes is the largest generic proxy GET response shared
+	// between identical concurrent requests. Zero disables coalescing.
+	CoalesceMaxBytes int
+	// DNSCacheEnabled caches the addresses of DNSCacheDomains for upstream
+	// dials instead of resolving them for every new connection.
+	DNSCacheEnabled bool
+	// DNSCacheTTL is how long resolved addresses are reused.
+	DNSCacheTTL time.Duration
+	// DNSCacheDomains are the hostnames, including their subdomains, whose
+	// addresses are cached.
+	DNSCacheDomains []string
+	// MaxUpstreamResponseBytes caps the upstream body read for user, search
+	// and avatar lookups.
+	MaxUpstreamResponseBytes int64
+	// MaxServableAge is the age past which a cached entry is refetched
+	// synchronously instead of served, however long its TTL. Zero disables
+	// the limit.
+	MaxServableAge time.Duration
+	// UpstreamPoolPerHost gives every upstream host its own transport, so its
+	// idle connections count against a budget no other host can exhaust.
+	UpstreamPoolPerHost bool
+	// ForwardedHeaders selects the headers describing the client on upstream
+	// requests: one of the ForwardedHeaders styles.
+	ForwardedHeaders string
+	// DirectTargetTemplate renders the upstream URL of direct and SOCKS5
+	// member targets from the request's service and path. Empty sends
+	// requests to https://<service>.<RobloxBaseDomain><path>.
+	DirectTargetTemplate string
+	// UpstreamReplayMode serves member lookups from, or records them to,
+	// UpstreamReplayDir: one of the UpstreamReplay modes. It is meant for
+	// development and tests only, so it also requires
+	// PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE.
+	UpstreamReplayMode string
+	// UpstreamReplayDir holds the recorded upstream responses.
+	UpstreamReplayDir string
+	// UserAvatarFailure decides whether a failed avatar fetch fails the user
+	// lookup: one of the AvatarFailure modes. A saturated thumbnails limiter
+	// degrades the avatar in either mode.
+	UserAvatarFailure string
+	// CacheEncoding is the format Redis entries are written in: one of the
+	// CacheEncoding constants. Entries in either format are read.
+	CacheEncoding string
+	// DialKeepAlive is the TCP keep-alive period of upstream connections.
+	// Zero uses Go's default and negative disables keep-alive probes.
+	DialKeepAlive time.Duration
+	// MaxConnAge retires upstream connections once they are this old, after
+	// the request using them completes. Zero keeps them until idle.
+	MaxConnAge time.Duration
+	// AdminFlushTimeout bounds a POST /admin/cache/flush.
+	AdminFlushTimeout time.Duration
+	// StripResponseHeaders lists upstream response headers never relayed to
+	// clients, such as cookies and Roblox-internal headers.
+	StripResponseHeaders []string
+	// MaxRedirects is how many redirects an upstream request follows. Zero
+	// relays the first 3xx response to the client instead.
+	MaxRedirects int
+	// RedisReplicaURL names a read replica of RedisURL that serves cache
+	// reads, with its topology taken from the URL scheme. Writes always go to
+	// RedisURL. Empty reads from RedisURL too.
+	RedisReplicaURL string
+	// UserAgent is sent on upstream requests the proxy builds itself.
+	UserAgent string
+	// ServiceUserAgents overrides UserAgent per Roblox service, keyed by
+	// lowercased service. Proxied requests to a listed service also carry the
+	// override in place of the client's user agent.
+	ServiceUserAgents map[string]string
+	// RedisConnectAttempts is how many times startup pings Redis before
+	// failing; one fails fast. Pings back off exponentially, with jitter.
+	RedisConnectAttempts int
+	// RedisConnectWait bounds the total time startup waits for Redis.
+	RedisConnectWait time.Duration
+	// AllowedMethods are the request methods a member serves; others are
+	// answered 405 before routing.
+	AllowedMethods []string
+	// SlowRequestThreshold is the duration from which a request is logged at
+	// warn level, whatever AccessLogLevel is. Zero disables it.
+	SlowRequestThreshold time.Duration
+	// RobloxBaseDomain is the domain direct targets address services under,
+	// as <service>.<RobloxBaseDomain>, so members can point at non-production
+	// Roblox environments.
+	RobloxBaseDomain string
+	// RobloxServices are the Roblox subdomains members proxy requests to;
+	// other first path segments are answered 404 instead of being sent to a
+	// host that does not exist. Empty allows any service.
+	RobloxServices []string
+	// ChaosEnabled injects faults into upstream requests and cache calls for
+	// resilience testing. It also requires PROXY_CHAOS_ACKNOWLEDGE, so it
+	// cannot be switched on by accident.
+	ChaosEnabled bool
+	// ChaosUpstreamErrorRate is the share of upstream requests failed.
+	ChaosUpstreamErrorRate float64
+	// ChaosLatencyRate is the share of upstream requests delayed by
+	// ChaosLatency.
+	ChaosLatencyRate float64
+	ChaosLatency     time.Duration
+	// ChaosCacheErrorRate is the share of cache calls failed.
+	ChaosCacheErrorRate float64
+	// AvatarBatchWindow is how long an avatar lookup waits for others of the
+	// same size to share one thumbnails call. Zero fetches each on its own.
+	// Cached avatars with validators are still revalidated individually;
+	// batched responses carry none, so their entries are refetched in full.
+	AvatarBatchWindow time.Duration
+	// RouteRateLimits gives each listed route its own per-client bucket,
+	// keyed by route name: the first path segment, such as "games" or
+	// "avatar-image", or "user-lookup" and "user-search" for the lookups
+	// served at the root. Other routes share the RateLimitPerSecond bucket.
+	RouteRateLimits map[string]RouteRateLimit
+	// BatchFetchConcurrency bounds the cache-miss fetches batch operations
+	// run at once, across all batch requests. Zero leaves them bounded only
+	// by BatchConcurrency per request.
+	BatchFetchConcurrency int
+	// MetricsExemplars attaches the trace ID of the active sampled span to
+	// latency histogram observations, so a latency bucket links to a trace.
+	// It only has an effect with tracing enabled.
+	MetricsExemplars bool
+}
+
+// Load parses environment variables, falling back to the file named by
+// PROXY_CONFIG_FILE for variables that are unset, and returns a validated
+// Config.
 func Load() (Config, error) {
+	src, err := newSource(os.Getenv("PROXY_CONFIG_FILE"))
+	if err != nil {
+		return Config{}, err
+	}
+
 	cfg := Config{
-		ListenAddr:             stringOrDefault(os.Getenv("PROXY_LISTEN_ADDR"), defaultListenAddr),
-		RequestTimeout:         durationOrDefault(os.Getenv("PROXY_REQUEST_TIMEOUT"), defaultRequestTimeout),
-		TransportTimeout:       durationOrDefault(os.Getenv("PROXY_TRANSPORT_TIMEOUT"), defaultTransportTimeout),
-		DialTimeout:            durationOrDefault(os.Getenv("PROXY_DIAL_TIMEOUT"), defaultDialTimeout),
-		IdleConnTimeout:        durationOrDefault(os.Getenv("PROXY_IDLE_CONN_TIMEOUT"), defaultIdleConnTimeout),
-		MaxIdleConns:           intOrDefault(os.Getenv("PROXY_MAX_IDLE_CONNS"), defaultMaxIdleConns),
-		MaxIdleConnsPerHost:    intOrDefault(os.Getenv("PROXY_MAX_IDLE_CONNS_PER_HOST"), defaultMaxIdleConnsPerHost),
-		BackgroundRefreshAfter: durationOrDefault(os.Getenv("PROXY_BACKGROUND_REFRESH_AFTER"), defaultBackgroundRefresh),
-		CacheTTL:               durationOrDefault(os.Getenv("PROXY_CACHE_TTL"), defaultCacheTTL),
-		DiscordWebhookURL:      strings.TrimSpace(os.Getenv("PROXY_DISCORD_WEBHOOK_URL")),
-	}
-
-	roleRaw := strings.TrimSpace(strings.ToLower(os.Getenv("PROXY_ROLE")))
+		ListenAddr:               stringOrDefault(src.get("PROXY_LISTEN_ADDR"), defaultListenAddr),
+		RequestTimeout:           durationOrDefault(src.get("PROXY_REQUEST_TIMEOUT"), defaultRequestTimeout),
+		TransportTimeout:         durationOrDefault(src.get("PROXY_TRANSPORT_TIMEOUT"), defaultTransportTimeout),
+		DialTimeout:              durationOrDefault(src.get("PROXY_DIAL_TIMEOUT"), defaultDialTimeout),
+		IdleConnTimeout:          durationOrDefault(src.get("PROXY_IDLE_CONN_TIMEOUT"), defaultIdleConnTimeout),
+		MaxIdleConns:             intOrDefault(src.get("PROXY_MAX_IDLE_CONNS"), defaultMaxIdleConns),
+		MaxIdleConnsPerHost:      intOrDefault(src.get("PROXY_MAX_IDLE_CONNS_PER_HOST"), defaultMaxIdleConnsPerHost),
+		BackgroundRefreshAfter:   durationOrDefault(src.get("PROXY_BACKGROUND_REFRESH_AFTER"), defaultBackgroundRefresh),
+		MaxBackgroundRefreshes:   intOrDefault(src.get("PROXY_MAX_BACKGROUND_REFRESHES"), defaultMaxBackgroundRefreshes),
+		DisableBackgroundRefresh: boolOrDefault(src.get("PROXY_DISABLE_BACKGROUND_REFRESH"), false),
+		RefreshRateLimit:         floatOrDefault(src.get("PROXY_REFRESH_RATE_LIMIT"), 0),
+		RefreshBurst:             intOrDefault(src.get("PROXY_REFRESH_BURST"), defaultRefreshBurst),
+		CacheTTL:                 durationOrDefault(src.get("PROXY_CACHE_TTL"), defaultCacheTTL),
+		CacheTTLJitter:           floatOrDefault(src.get("PROXY_CACHE_TTL_JITTER"), 0),
+		CacheTTLJitterMode:       strings.ToLower(stringOrDefault(src.get("PROXY_CACHE_TTL_JITTER_MODE"), JitterModeRandom)),
+		CacheKeyPrefix:           stringOrDefault(src.get("PROXY_CACHE_KEY_PREFIX"), defaultCacheKeyPrefix),
+		AdminToken:               strings.TrimSpace(src.get("PROXY_ADMIN_TOKEN")),
+		DebugEndpoints:           boolOrDefault(src.get("PROXY_DEBUG_ENDPOINTS"), false),
+		CacheTTLMin:              durationOrDefault(src.get("PROXY_CACHE_TTL_MIN"), 0),
+		CacheTTLMax:              durationOrDefault(src.get("PROXY_CACHE_TTL_MAX"), 0),
+		PopularityThreshold:      intOrDefault(src.get("PROXY_POPULARITY_THRESHOLD"), defaultPopularityThreshold),
+		DiscordWebhookURL:        strings.TrimSpace(src.get("PROXY_DISCORD_WEBHOOK_URL")),
+		UpstreamHTTP2:            boolOrDefault(src.get("PROXY_UPSTREAM_HTTP2"), true),
+		UpstreamH2C:              boolOrDefault(src.get("PROXY_UPSTREAM_H2C"), false),
+		MaxConcurrentUpstream:    intOrDefault(src.get("PROXY_MAX_CONCURRENT_UPSTREAM"), 0),
+		UpstreamQueueTimeout:     durationOrDefault(src.get("PROXY_UPSTREAM_QUEUE_TIMEOUT"), defaultUpstreamQueueTimeout),
+		MemberFallbacks:          intOrDefault(src.get("PROXY_MEMBER_FALLBACKS"), defaultMemberFallbacks),
+		CopyBufferBytes:          intOrDefault(src.get("PROXY_COPY_BUFFER_BYTES"), defaultCopyBufferBytes),
+		StreamFlushBytes:         intOrDefault(src.get("PROXY_STREAM_FLUSH_BYTES"), defaultStreamFlushBytes),
+		MaxRequestBodyBytes:      int64OrDefault(src.get("PROXY_MAX_REQUEST_BODY_BYTES"), defaultMaxRequestBodyBytes),
+		StaleIfErrorWindow:       durationOrDefault(src.get("PROXY_STALE_IF_ERROR_WINDOW"), 0),
+		MaxCacheKeyBytes:         intOrDefault(src.get("PROXY_MAX_CACHE_KEY_BYTES"), defaultMaxCacheKeyBytes),
+		AvatarImageCaching:       boolOrDefault(src.get("PROXY_AVATAR_IMAGE_CACHING"), false),
+		AvatarImageTTL:           durationOrDefault(src.get("PROXY_AVATAR_IMAGE_TTL"), defaultAvatarImageTTL),
+		InMemoryCacheSize:        intOrDefault(src.get("PROXY_IN_MEMORY_CACHE_SIZE"), 0),
+		TLSCertFile:              strings.TrimSpace(src.get("PROXY_TLS_CERT_FILE")),
+		TLSKeyFile:               strings.TrimSpace(src.get("PROXY_TLS_KEY_FILE")),
+		TLSReloadInterval:        durationOrDefault(src.get("PROXY_TLS_RELOAD_INTERVAL"), defaultTLSReloadInterval),
+		MaxConcurrentFetches:     intOrDefault(src.get("PROXY_MAX_CONCURRENT_FETCHES"), 0),
+		ThumbnailConcurrency:     intOrDefault(src.get("PROXY_THUMBNAIL_CONCURRENCY"), 0),
+		ThumbnailRatePerSecond:   floatOrDefault(src.get("PROXY_THUMBNAIL_RATE_PER_SECOND"), 0),
+		ThumbnailBurst:           intOrDefault(src.get("PROXY_THUMBNAIL_BURST"), defaultThumbnailBurst),
+		PrefetchAvatars:          boolOrDefault(src.get("PROXY_PREFETCH_AVATARS"), false),
+		SearchMaxResults:         intOrDefault(src.get("PROXY_SEARCH_MAX_RESULTS"), 0),
+		SearchMaxPages:           intOrDefault(src.get("PROXY_SEARCH_MAX_PAGES"), defaultSearchMaxPages),
+		BatchMaxOperations:       intOrDefault(src.get("PROXY_BATCH_MAX_OPERATIONS"), defaultBatchMaxOperations),
+		BatchConcurrency:         intOrDefault(src.get("PROXY_BATCH_CONCURRENCY"), defaultBatchConcurrency),
+		CacheCompressMinBytes:    intOrDefault(src.get("PROXY_CACHE_COMPRESS_MIN_BYTES"), 0),
+		CacheFailOpen:            boolOrDefault(src.get("PROXY_CACHE_FAIL_OPEN"), true),
+		CacheBreakerThreshold:    intOrDefault(src.get("PROXY_CACHE_BREAKER_THRESHOLD"), defaultCacheBreakerThreshold),
+		CacheBreakerCooldown:     durationOrDefault(src.get("PROXY_CACHE_BREAKER_COOLDOWN"), defaultCacheBreakerCooldown),
+		SearchEmbedAvatars:       boolOrDefault(src.get("PROXY_SEARCH_EMBED_AVATARS"), true),
+		SearchAvatarConcurrency:  intOrDefault(src.get("PROXY_SEARCH_AVATAR_CONCURRENCY"), defaultSearchAvatarConcurrency),
+		WarmupFile:               strings.TrimSpace(src.get("PROXY_WARMUP_FILE")),
+		WarmupConcurrency:        intOrDefault(src.get("PROXY_WARMUP_CONCURRENCY"), defaultWarmupConcurrency),
+		ValidateRawJSON:          boolOrDefault(src.get("PROXY_VALIDATE_RAW_JSON"), true),
+		TracingEndpoint:          strings.TrimSpace(src.get("PROXY_TRACING_ENDPOINT")),
+		TracingSampleRatio:       floatOrDefault(src.get("PROXY_TRACING_SAMPLE_RATIO"), 1),
+		HealthChecksEnabled:      boolOrDefault(src.get("PROXY_HEALTH_CHECKS_ENABLED"), false),
+		DrainTimeout:             durationOrDefault(src.get("PROXY_DRAIN_TIMEOUT"), defaultDrainTimeout),
+		ShutdownTimeout:          durationOrDefault(src.get("PROXY_SHUTDOWN_TIMEOUT"), defaultShutdownTimeout),
+		RateLimitPerSecond:       floatOrDefault(src.get("PROXY_RATE_LIMIT_PER_SECOND"), 0),
+		RateLimitBurst:           intOrDefault(src.get("PROXY_RATE_LIMIT_BURST"), defaultRateLimitBurst),
+		RateLimitMaxClients:      intOrDefault(src.get("PROXY_RATE_LIMIT_MAX_CLIENTS"), defaultRateLimitMaxClients),
+	}
+
+	roleRaw := strings.TrimSpace(strings.ToLower(src.get("PROXY_ROLE")))
 	switch Role(roleRaw) {
 	case RoleProvider:
 		cfg.Role = RoleProvider
@@ -76,35 +516,510 @@ func Load() (Config, error) {
 		return Config{}, fmt.Errorf("invalid PROXY_ROLE %q: must be %q or %q", roleRaw, RoleProvider, RoleMember)
 	}
 
-	cfg.RedisURL = strings.TrimSpace(os.Getenv("PROXY_REDIS_URL"))
-	if cfg.RedisURL == "" {
-		return Config{}, errors.New("PROXY_REDIS_URL must be provided")
+	for _, lv := range []struct {
+		env      string
+		dest     *slog.Level
+		fallback slog.Level
+	}{
+		{"PROXY_LOG_LEVEL", &cfg.LogLevel, slog.LevelInfo},
+		{"PROXY_ACCESS_LOG_LEVEL", &cfg.AccessLogLevel, slog.LevelInfo},
+		{"PROXY_CACHE_LOG_LEVEL", &cfg.CacheLogLevel, slog.LevelInfo},
+	} {
+		level, err := levelOrDefault(src.get(lv.env), lv.fallback)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", lv.env, err)
+		}
+		*lv.dest = level
+	}
+
+	cfg.AccessLogFields = splitAndClean(strings.ToLower(src.get("PROXY_ACCESS_LOG_FIELDS")))
+
+	for _, hp := range []struct {
+		kind  string
+		dest  *HealthProbe
+		probe HealthProbe
+	}{
+		{"STATIC", &cfg.HealthStatic, HealthProbe{Path: "/healthz", Method: http.MethodGet, ExpectedStatus: http.StatusOK, Interval: defaultHealthInterval}},
+		// users.roblox.com/v1/users/1 is a cheap, stable public endpoint.
+		{"DIRECT", &cfg.HealthDirect, HealthProbe{Path: "/users/v1/users/1", Method: http.MethodGet, ExpectedStatus: http.StatusOK, Interval: defaultHealthInterval}},
+		{"PROVIDER", &cfg.HealthProvider, HealthProbe{Path: "/healthz", Method: http.MethodGet, ExpectedStatus: http.StatusOK, Interval: defaultHealthInterval}},
+	} {
+		probe, err := healthProbeFromEnv(src, hp.kind, hp.probe)
+		if err != nil {
+			return Config{}, err
+		}
+		*hp.dest = probe
+	}
+
+	cfg.StripRequestHeaders = splitAndClean(src.get("PROXY_STRIP_REQUEST_HEADERS"))
+	if raw := strings.TrimSpace(src.get("PROXY_ADD_REQUEST_HEADERS")); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &cfg.AddRequestHeaders); err != nil {
+			return Config{}, fmt.Errorf("invalid PROXY_ADD_REQUEST_HEADERS: %w", err)
+		}
+	}
+
+	cfg.RobloxAuthEnabled = boolOrDefault(src.get("PROXY_ROBLOX_AUTH_ENABLED"), false)
+	if cfg.RobloxAuthEnabled {
+		cookie, err := robloxCookie(src)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.RobloxCookie = cookie
+		cfg.RobloxAuthPaths = splitAndClean(src.get("PROXY_ROBLOX_AUTH_PATHS"))
+		if cfg.RobloxCookie == "" || len(cfg.RobloxAuthPaths) == 0 {
+			return Config{}, errors.New("PROXY_ROBLOX_AUTH_ENABLED requires PROXY_ROBLOX_COOKIE or PROXY_ROBLOX_COOKIE_FILE, and PROXY_ROBLOX_AUTH_PATHS")
+		}
+	}
+
+	serviceTimeouts, err := parseServiceTimeouts(src.get("PROXY_SERVICE_TIMEOUTS"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ServiceTimeouts = serviceTimeouts
+
+	cfg.UserAgent = stringOrDefault(src.get("PROXY_USER_AGENT"), defaultUserAgent)
+	if strings.ContainsAny(cfg.UserAgent, "\r\n") {
+		return Config{}, errors.New("invalid PROXY_USER_AGENT: must be a single line")
+	}
+	serviceUserAgents, err := parseServiceUserAgents(src.get("PROXY_SERVICE_USER_AGENTS"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.ServiceUserAgents = serviceUserAgents
+
+	cacheRules, err := parseCacheRules(src.get("PROXY_CACHEABLE_PATHS"))
+	if err != nil {
+		return Config{}, err
+	}
+	cfg.CacheablePaths = cacheRules
+
+	cfg.StreamingPaths = splitAndClean(src.get("PROXY_STREAMING_PATHS"))
+	for _, p := range cfg.StreamingPaths {
+		if !strings.HasPrefix(p, "/") {
+			return Config{}, fmt.Errorf("PROXY_STREAMING_PATHS entry %q must start with /", p)
+		}
+	}
+
+	cfg.ShadowTargets = splitAndClean(src.get("PROXY_SHADOW_TARGETS"))
+	for _, raw := range cfg.ShadowTargets {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Config{}, fmt.Errorf("PROXY_SHADOW_TARGETS entry %q must be an http or https URL", raw)
+		}
+	}
+	cfg.ShadowFraction = floatOrDefault(src.get("PROXY_SHADOW_FRACTION"), defaultShadowFraction)
+	if cfg.ShadowFraction < 0 || cfg.ShadowFraction > 1 {
+		return Config{}, errors.New("PROXY_SHADOW_FRACTION must be between 0 and 1")
+	}
+	cfg.ShadowConcurrency = intOrDefault(src.get("PROXY_SHADOW_CONCURRENCY"), defaultShadowConcurrency)
+	if cfg.ShadowConcurrency <= 0 {
+		return Config{}, errors.New("PROXY_SHADOW_CONCURRENCY must be greater than zero")
+	}
+
+	cfg.UserPayloadValidation = strings.ToLower(stringOrDefault(src.get("PROXY_USER_PAYLOAD_VALIDATION"), UserValidationRequired))
+	switch cfg.UserPayloadValidation {
+	case UserValidationOff, UserValidationRequired, UserValidationStrict:
+	default:
+		return Config{}, fmt.Errorf("invalid PROXY_USER_PAYLOAD_VALIDATION %q: must be %q, %q or %q", cfg.UserPayloadValidation, UserValidationOff, UserValidationRequired, UserValidationStrict)
+	}
+
+	cfg.CoalesceMaxBytes = intOrDefault(src.get("PROXY_COALESCE_MAX_BYTES"), defaultCoalesceMaxBytes)
+	if cfg.CoalesceMaxBytes < 0 {
+		return Config{}, errors.New("PROXY_COALESCE_MAX_BYTES must not be negative")
+	}
+
+	cfg.RobloxBaseDomain = strings.TrimSuffix(strings.ToLower(stringOrDefault(src.get("PROXY_ROBLOX_BASE_DOMAIN"), defaultRobloxBaseDomain)), ".")
+	if !validDomain(cfg.RobloxBaseDomain) {
+		return Config{}, fmt.Errorf("invalid PROXY_ROBLOX_BASE_DOMAIN %q: must be a domain name such as roblox.com", cfg.RobloxBaseDomain)
+	}
+
+	cfg.DNSCacheEnabled = boolOrDefault(src.get("PROXY_DNS_CACHE_ENABLED"), false)
+	cfg.DNSCacheTTL = durationOrDefault(src.get("PROXY_DNS_CACHE_TTL"), defaultDNSCacheTTL)
+	if cfg.DNSCacheTTL <= 0 {
+		return Config{}, errors.New("PROXY_DNS_CACHE_TTL must be greater than zero")
+	}
+	cfg.DNSCacheDomains = splitAndClean(strings.ToLower(src.get("PROXY_DNS_CACHE_DOMAINS")))
+	if len(cfg.DNSCacheDomains) == 0 {
+		cfg.DNSCacheDomains = []string{cfg.RobloxBaseDomain}
+	}
+
+	cfg.MaxUpstreamResponseBytes = int64OrDefault(src.get("PROXY_MAX_UPSTREAM_RESPONSE_BYTES"), defaultMaxUpstreamResponse)
+	if cfg.MaxUpstreamResponseBytes <= 0 {
+		return Config{}, errors.New("PROXY_MAX_UPSTREAM_RESPONSE_BYTES must be greater than zero")
+	}
+
+	cfg.UpstreamPoolPerHost = boolOrDefault(src.get("PROXY_UPSTREAM_POOL_PER_HOST"), false)
+
+	cfg.ForwardedHeaders = strings.ToLower(stringOrDefault(src.get("PROXY_FORWARDED_HEADERS"), ForwardedHeadersLegacy))
+	switch cfg.ForwardedHeaders {
+	case ForwardedHeadersLegacy, ForwardedHeadersRFC7239, ForwardedHeadersBoth:
+	default:
+		return Config{}, fmt.Errorf("invalid PROXY_FORWARDED_HEADERS %q: must be %q, %q or %q", cfg.ForwardedHeaders, ForwardedHeadersLegacy, ForwardedHeadersRFC7239, ForwardedHeadersBoth)
+	}
+
+	cfg.DirectTargetTemplate = strings.TrimSpace(src.get("PROXY_DIRECT_TARGET_TEMPLATE"))
+
+	cfg.UpstreamReplayMode = strings.ToLower(stringOrDefault(src.get("PROXY_UPSTREAM_REPLAY_MODE"), UpstreamReplayOff))
+	cfg.UpstreamReplayDir = strings.TrimSpace(src.get("PROXY_UPSTREAM_REPLAY_DIR"))
+	switch cfg.UpstreamReplayMode {
+	case UpstreamReplayOff:
+	case UpstreamReplayReplay, UpstreamReplayRecord:
+		if cfg.UpstreamReplayDir == "" {
+			return Config{}, fmt.Errorf("PROXY_UPSTREAM_REPLAY_MODE=%s requires PROXY_UPSTREAM_REPLAY_DIR", cfg.UpstreamReplayMode)
+		}
+		if cfg.Role != RoleMember {
+			return Config{}, errors.New("PROXY_UPSTREAM_REPLAY_MODE is only supported by members")
+		}
+		if strings.TrimSpace(src.get("PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE")) != replayAcknowledgement {
+			return Config{}, fmt.Errorf("PROXY_UPSTREAM_REPLAY_MODE bypasses Roblox and requires PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE=%s", replayAcknowledgement)
+		}
+	default:
+		return Config{}, fmt.Errorf("invalid PROXY_UPSTREAM_REPLAY_MODE %q: must be %q, %q or %q", cfg.UpstreamReplayMode, UpstreamReplayOff, UpstreamReplayReplay, UpstreamReplayRecord)
+	}
+
+	cfg.UserAvatarFailure = strings.ToLower(stringOrDefault(src.get("PROXY_USER_AVATAR_FAILURE"), AvatarFailureLenient))
+	switch cfg.UserAvatarFailure {
+	case AvatarFailureStrict, AvatarFailureLenient:
+	default:
+		return Config{}, fmt.Errorf("invalid PROXY_USER_AVATAR_FAILURE %q: must be %q or %q", cfg.UserAvatarFailure, AvatarFailureStrict, AvatarFailureLenient)
+	}
+
+	cfg.CacheEncoding = strings.ToLower(stringOrDefault(src.get("PROXY_CACHE_ENCODING"), CacheEncodingJSON))
+	if cfg.CacheEncoding != CacheEncodingJSON && cfg.CacheEncoding != CacheEncodingMsgpack {
+		return Config{}, fmt.Errorf("invalid PROXY_CACHE_ENCODING %q: must be %q or %q", cfg.CacheEncoding, CacheEncodingJSON, CacheEncodingMsgpack)
+	}
+
+	cfg.DialKeepAlive = durationOrDefault(src.get("PROXY_DIAL_KEEP_ALIVE"), defaultDialKeepAlive)
+	cfg.MaxConnAge = durationOrDefault(src.get("PROXY_MAX_CONN_AGE"), 0)
+	if cfg.MaxConnAge < 0 {
+		return Config{}, errors.New("PROXY_MAX_CONN_AGE must not be negative")
+	}
+
+	cfg.AdminFlushTimeout = durationOrDefault(src.get("PROXY_ADMIN_FLUSH_TIMEOUT"), defaultAdminFlushTimeout)
+	if cfg.AdminFlushTimeout <= 0 {
+		return Config{}, errors.New("PROXY_ADMIN_FLUSH_TIMEOUT must be greater than zero")
+	}
+
+	cfg.MaxRedirects = intOrDefault(src.get("PROXY_MAX_REDIRECTS"), defaultMaxRedirects)
+	if cfg.MaxRedirects < 0 {
+		return Config{}, errors.New("PROXY_MAX_REDIRECTS must not be negative")
+	}
+
+	// An explicitly empty PROXY_STRIP_RESPONSE_HEADERS relays every upstream
+	// header except hop-by-hop ones.
+	if raw, ok := src.lookup("PROXY_STRIP_RESPONSE_HEADERS"); ok {
+		cfg.StripResponseHeaders = splitAndClean(raw)
+	} else {
+		cfg.StripResponseHeaders = []string{"Set-Cookie", "Roblox-Machine-Id", "X-Roblox-Edge", "X-Roblox-Region"}
+	}
+	for _, name := range cfg.StripResponseHeaders {
+		if protectedResponseHeader(name) {
+			return Config{}, fmt.Errorf("invalid PROXY_STRIP_RESPONSE_HEADERS: %s must be relayed", name)
+		}
+	}
+
+	cfg.AllowedMethods = splitAndClean(strings.ToUpper(stringOrDefault(src.get("PROXY_ALLOWED_METHODS"), "GET,HEAD,POST,OPTIONS")))
+	for _, method := range cfg.AllowedMethods {
+		if strings.Trim(method, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return Config{}, fmt.Errorf("invalid PROXY_ALLOWED_METHODS method %q", method)
+		}
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		return Config{}, errors.New("PROXY_ALLOWED_METHODS must list at least one method")
+	}
+
+	cfg.SlowRequestThreshold = durationOrDefault(src.get("PROXY_SLOW_REQUEST_THRESHOLD"), 0)
+	if cfg.SlowRequestThreshold < 0 {
+		return Config{}, errors.New("PROXY_SLOW_REQUEST_THRESHOLD must not be negative")
+	}
+
+	// An explicitly empty PROXY_ROBLOX_SERVICES proxies to any subdomain.
+	if raw, ok := src.lookup("PROXY_ROBLOX_SERVICES"); ok {
+		cfg.RobloxServices = splitAndClean(strings.ToLower(raw))
+	} else {
+		cfg.RobloxServices = defaultRobloxServices
+	}
+
+	cfg.ChaosEnabled = boolOrDefault(src.get("PROXY_CHAOS_ENABLED"), false)
+	if cfg.ChaosEnabled {
+		if strings.TrimSpace(src.get("PROXY_CHAOS_ACKNOWLEDGE")) != chaosAcknowledgement {
+			return Config{}, fmt.Errorf("PROXY_CHAOS_ENABLED injects faults into live traffic and requires PROXY_CHAOS_ACKNOWLEDGE=%s", chaosAcknowledgement)
+		}
+		cfg.ChaosUpstreamErrorRate = floatOrDefault(src.get("PROXY_CHAOS_UPSTREAM_ERROR_RATE"), 0)
+		cfg.ChaosLatencyRate = floatOrDefault(src.get("PROXY_CHAOS_LATENCY_RATE"), 0)
+		cfg.ChaosLatency = durationOrDefault(src.get("PROXY_CHAOS_LATENCY"), time.Second)
+		cfg.ChaosCacheErrorRate = floatOrDefault(src.get("PROXY_CHAOS_CACHE_ERROR_RATE"), 0)
+		for _, rate := range []float64{cfg.ChaosUpstreamErrorRate, cfg.ChaosLatencyRate, cfg.ChaosCacheErrorRate} {
+			if rate < 0 || rate > 1 {
+				return Config{}, errors.New("PROXY_CHAOS_*_RATE settings must be between 0 and 1")
+			}
+		}
+		if cfg.ChaosLatency < 0 {
+			return Config{}, errors.New("PROXY_CHAOS_LATENCY must not be negative")
+		}
+	}
+
+	cfg.AvatarBatchWindow = durationOrDefault(src.get("PROXY_AVATAR_BATCH_WINDOW"), 0)
+	if cfg.AvatarBatchWindow < 0 {
+		return Config{}, errors.New("PROXY_AVATAR_BATCH_WINDOW must not be negative")
+	}
+
+	cfg.BatchFetchConcurrency = intOrDefault(src.get("PROXY_BATCH_FETCH_CONCURRENCY"), 0)
+	if cfg.BatchFetchConcurrency < 0 {
+		return Config{}, errors.New("PROXY_BATCH_FETCH_CONCURRENCY must not be negative")
+	}
+
+	cfg.RouteRateLimits, err = parseRouteRateLimits(src.get("PROXY_ROUTE_RATE_LIMITS"))
+	if err != nil {
+		return Config{}, err
+	}
+	if len(cfg.RouteRateLimits) > 0 && cfg.RateLimitMaxClients <= 0 {
+		return Config{}, errors.New("PROXY_RATE_LIMIT_MAX_CLIENTS must be positive when route rate limits are set")
+	}
+
+	cfg.MetricsExemplars = boolOrDefault(src.get("PROXY_METRICS_EXEMPLARS"), false)
+
+	cfg.WriteAllowedSubdomains = splitAndClean(strings.ToLower(src.get("PROXY_WRITE_ALLOWED_SUBDOMAINS")))
+
+	cfg.ReservedPaths = splitAndClean(src.get("PROXY_RESERVED_PATHS"))
+	if len(cfg.ReservedPaths) == 0 {
+		cfg.ReservedPaths = []string{"/"}
+	}
+
+	// An explicitly empty PROXY_CACHE_KEY_PREFIX stores keys unprefixed, for
+	// deployments that own their cache store outright.
+	if raw, ok := src.lookup("PROXY_CACHE_KEY_PREFIX"); ok {
+		cfg.CacheKeyPrefix = strings.TrimSpace(raw)
+	}
+
+	cfg.AuthTokens = splitAndClean(src.get("PROXY_AUTH_TOKENS"))
+	// An explicitly empty PROXY_AUTH_EXEMPT_PATHS protects every endpoint.
+	if raw, ok := src.lookup("PROXY_AUTH_EXEMPT_PATHS"); ok {
+		cfg.AuthExemptPaths = splitAndClean(raw)
+	} else {
+		cfg.AuthExemptPaths = []string{"/healthz", "/readyz", "/metrics", "/version"}
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return Config{}, errors.New("PROXY_TLS_CERT_FILE and PROXY_TLS_KEY_FILE must be provided together")
+	}
+
+	if cfg.TLSReloadInterval <= 0 {
+		return Config{}, errors.New("PROXY_TLS_RELOAD_INTERVAL must be positive")
+	}
+
+	if cfg.MaxConcurrentFetches < 0 {
+		return Config{}, errors.New("PROXY_MAX_CONCURRENT_FETCHES must not be negative")
+	}
+
+	if cfg.ThumbnailConcurrency < 0 || cfg.ThumbnailRatePerSecond < 0 {
+		return Config{}, errors.New("PROXY_THUMBNAIL_CONCURRENCY and PROXY_THUMBNAIL_RATE_PER_SECOND must not be negative")
+	}
+
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		return Config{}, errors.New("PROXY_TRACING_SAMPLE_RATIO must be between 0 and 1")
+	}
+
+	if cfg.DebugEndpoints && cfg.AdminToken == "" {
+		return Config{}, errors.New("PROXY_DEBUG_ENDPOINTS requires PROXY_ADMIN_TOKEN")
+	}
+
+	if cfg.CacheBreakerThreshold < 0 || cfg.CacheBreakerThreshold > 0 && cfg.CacheBreakerCooldown <= 0 {
+		return Config{}, errors.New("PROXY_CACHE_BREAKER_THRESHOLD must not be negative and PROXY_CACHE_BREAKER_COOLDOWN must be positive")
+	}
+
+	if cfg.MaxConcurrentUpstream < 0 || cfg.UpstreamQueueTimeout < 0 {
+		return Config{}, errors.New("PROXY_MAX_CONCURRENT_UPSTREAM and PROXY_UPSTREAM_QUEUE_TIMEOUT must not be negative")
+	}
+
+	if cfg.MemberFallbacks < 0 {
+		return Config{}, errors.New("PROXY_MEMBER_FALLBACKS must not be negative")
+	}
+
+	if cfg.CopyBufferBytes <= 0 {
+		return Config{}, errors.New("PROXY_COPY_BUFFER_BYTES must be positive")
+	}
+
+	if cfg.StreamFlushBytes < 0 {
+		return Config{}, errors.New("PROXY_STREAM_FLUSH_BYTES must not be negative")
+	}
+
+	if cfg.BatchMaxOperations <= 0 || cfg.BatchConcurrency <= 0 {
+		return Config{}, errors.New("PROXY_BATCH_MAX_OPERATIONS and PROXY_BATCH_CONCURRENCY must be positive")
+	}
+
+	if cfg.WarmupConcurrency <= 0 {
+		return Config{}, errors.New("PROXY_WARMUP_CONCURRENCY must be positive")
+	}
+
+	if cfg.SearchMaxResults < 0 || cfg.SearchMaxPages <= 0 {
+		return Config{}, errors.New("PROXY_SEARCH_MAX_RESULTS must not be negative and PROXY_SEARCH_MAX_PAGES must be positive")
+	}
+
+	if cfg.SearchAvatarConcurrency <= 0 {
+		return Config{}, errors.New("PROXY_SEARCH_AVATAR_CONCURRENCY must be positive")
+	}
+
+	if cfg.InMemoryCacheSize < 0 {
+		return Config{}, errors.New("PROXY_IN_MEMORY_CACHE_SIZE must not be negative")
+	}
+
+	cfg.RedisURL = strings.TrimSpace(src.get("PROXY_REDIS_URL"))
+	cfg.RedisMode = strings.ToLower(strings.TrimSpace(src.get("PROXY_REDIS_MODE")))
+	switch cfg.RedisMode {
+	case "", "single", "cluster", "sentinel":
+	default:
+		return Config{}, fmt.Errorf("invalid PROXY_REDIS_MODE %q: must be single, cluster or sentinel", cfg.RedisMode)
+	}
+	cfg.RedisReplicaURL = strings.TrimSpace(src.get("PROXY_REDIS_REPLICA_URL"))
+	if cfg.RedisReplicaURL != "" && cfg.RedisURL == "" {
+		return Config{}, errors.New("PROXY_REDIS_REPLICA_URL requires PROXY_REDIS_URL")
+	}
+	cfg.RedisConnectAttempts = intOrDefault(src.get("PROXY_REDIS_CONNECT_ATTEMPTS"), defaultRedisConnectAttempts)
+	cfg.RedisConnectWait = durationOrDefault(src.get("PROXY_REDIS_CONNECT_WAIT"), defaultRedisConnectWait)
+	if cfg.RedisConnectAttempts < 1 || cfg.RedisConnectWait < 0 {
+		return Config{}, errors.New("PROXY_REDIS_CONNECT_ATTEMPTS must be at least 1 and PROXY_REDIS_CONNECT_WAIT must not be negative")
+	}
+	if cfg.RedisURL == "" && cfg.InMemoryCacheSize == 0 {
+		return Config{}, errors.New("PROXY_REDIS_URL or PROXY_IN_MEMORY_CACHE_SIZE must be provided")
 	}
 
 	switch cfg.Role {
 	case RoleProvider:
-		cfg.ProviderClusters = splitAndClean(os.Getenv("PROXY_PROVIDER_CLUSTERS"))
+		cfg.ProviderClusters = splitAndClean(src.get("PROXY_PROVIDER_CLUSTERS"))
 		if len(cfg.ProviderClusters) == 0 {
 			return Config{}, errors.New("PROXY_PROVIDER_CLUSTERS must list at least one upstream")
 		}
 	case RoleMember:
-		cfg.MemberClusters = splitAndClean(os.Getenv("PROXY_MEMBER_CLUSTERS"))
+		cfg.MemberClusters = splitAndClean(src.get("PROXY_MEMBER_CLUSTERS"))
 		if len(cfg.MemberClusters) == 0 {
 			return Config{}, errors.New("PROXY_MEMBER_CLUSTERS must list at least one upstream")
 		}
+		if raw := strings.TrimSpace(src.get("PROXY_MEMBER_HEADER_TEMPLATES")); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &cfg.MemberHeaderTemplates); err != nil {
+				return Config{}, fmt.Errorf("invalid PROXY_MEMBER_HEADER_TEMPLATES: %w", err)
+			}
+			for target, headers := range cfg.MemberHeaderTemplates {
+				for name, text := range headers {
+					if _, err := upstream.ParseHeaderTemplate(name, text); err != nil {
+						return Config{}, fmt.Errorf("invalid PROXY_MEMBER_HEADER_TEMPLATES: header %q for %q: %w", name, target, err)
+					}
+				}
+			}
+		}
 	}
 
 	if cfg.BackgroundRefreshAfter <= 0 {
 		return Config{}, errors.New("PROXY_BACKGROUND_REFRESH_AFTER must be positive")
 	}
 
+	if cfg.MaxBackgroundRefreshes < 0 {
+		return Config{}, errors.New("PROXY_MAX_BACKGROUND_REFRESHES must not be negative")
+	}
+
+	if cfg.RefreshRateLimit < 0 {
+		return Config{}, errors.New("PROXY_REFRESH_RATE_LIMIT must not be negative")
+	}
+
 	if cfg.CacheTTL <= 0 {
 		return Config{}, errors.New("PROXY_CACHE_TTL must be positive")
 	}
 
+	if (cfg.CacheTTLMin > 0) != (cfg.CacheTTLMax > 0) || cfg.CacheTTLMin > cfg.CacheTTLMax {
+		return Config{}, errors.New("PROXY_CACHE_TTL_MIN and PROXY_CACHE_TTL_MAX must be set together with min not above max")
+	}
+
+	if cfg.PopularityThreshold <= 0 {
+		return Config{}, errors.New("PROXY_POPULARITY_THRESHOLD must be positive")
+	}
+
+	if cfg.CacheTTLJitter < 0 || cfg.CacheTTLJitter >= 1 {
+		return Config{}, errors.New("PROXY_CACHE_TTL_JITTER must be at least 0 and below 1")
+	}
+
+	if cfg.CacheTTLJitterMode != JitterModeRandom && cfg.CacheTTLJitterMode != JitterModeHash {
+		return Config{}, fmt.Errorf("invalid PROXY_CACHE_TTL_JITTER_MODE %q: must be %q or %q", cfg.CacheTTLJitterMode, JitterModeRandom, JitterModeHash)
+	}
+
+	// With jitter the shortest possible TTL must still leave room for a
+	// background refresh, or entries would expire before ever being refreshed.
+	if cfg.CacheTTLJitter > 0 {
+		base := cfg.CacheTTL
+		if cfg.CacheTTLMin > 0 {
+			base = cfg.CacheTTLMin
+		}
+		shortest := time.Duration(float64(base) * (1 - cfg.CacheTTLJitter))
+		if cfg.BackgroundRefreshAfter >= shortest {
+			return Config{}, fmt.Errorf("PROXY_BACKGROUND_REFRESH_AFTER (%s) must be below the shortest jittered TTL (%s)", cfg.BackgroundRefreshAfter, shortest)
+		}
+	}
+
+	cfg.MaxServableAge = durationOrDefault(src.get("PROXY_MAX_SERVABLE_AGE"), 0)
+	if cfg.MaxServableAge < 0 {
+		return Config{}, errors.New("PROXY_MAX_SERVABLE_AGE must not be negative")
+	}
+	// Background refreshes must get a chance to run before entries are
+	// refused outright.
+	if cfg.MaxServableAge > 0 && !cfg.DisableBackgroundRefresh && cfg.MaxServableAge <= cfg.BackgroundRefreshAfter {
+		return Config{}, fmt.Errorf("PROXY_MAX_SERVABLE_AGE (%s) must be above PROXY_BACKGROUND_REFRESH_AFTER (%s)", cfg.MaxServableAge, cfg.BackgroundRefreshAfter)
+	}
+
+	if cfg.StaleIfErrorWindow < 0 {
+		return Config{}, errors.New("PROXY_STALE_IF_ERROR_WINDOW must not be negative")
+	}
+
+	if cfg.CacheCompressMinBytes < 0 {
+		return Config{}, errors.New("PROXY_CACHE_COMPRESS_MIN_BYTES must not be negative")
+	}
+
+	if cfg.MaxCacheKeyBytes != 0 && cfg.MaxCacheKeyBytes < minMaxCacheKeyBytes {
+		return Config{}, fmt.Errorf("PROXY_MAX_CACHE_KEY_BYTES must be 0 or at least %d", minMaxCacheKeyBytes)
+	}
+
+	if cfg.AvatarImageTTL <= 0 {
+		return Config{}, errors.New("PROXY_AVATAR_IMAGE_TTL must be positive")
+	}
+
+	if cfg.DrainTimeout < 0 || cfg.ShutdownTimeout <= 0 {
+		return Config{}, errors.New("PROXY_DRAIN_TIMEOUT must not be negative and PROXY_SHUTDOWN_TIMEOUT must be positive")
+	}
+
+	if cfg.RateLimitPerSecond < 0 {
+		return Config{}, errors.New("PROXY_RATE_LIMIT_PER_SECOND must not be negative")
+	}
+
+	if cfg.RateLimitPerSecond > 0 && (cfg.RateLimitBurst <= 0 || cfg.RateLimitMaxClients <= 0) {
+		return Config{}, errors.New("PROXY_RATE_LIMIT_BURST and PROXY_RATE_LIMIT_MAX_CLIENTS must be positive when rate limiting is enabled")
+	}
+
+	if cfg.MaxRequestBodyBytes <= 0 {
+		return Config{}, errors.New("PROXY_MAX_REQUEST_BODY_BYTES must be positive")
+	}
+
 	return cfg, nil
 }
 
+func healthProbeFromEnv(src source, kind string, fallback HealthProbe) (HealthProbe, error) {
+	prefix := "PROXY_HEALTH_" + kind + "_"
+	probe := HealthProbe{
+		Path:           stringOrDefault(src.get(prefix+"PATH"), fallback.Path),
+		Method:         strings.ToUpper(stringOrDefault(src.get(prefix+"METHOD"), fallback.Method)),
+		ExpectedStatus: intOrDefault(src.get(prefix+"STATUS"), fallback.ExpectedStatus),
+		Interval:       durationOrDefault(src.get(prefix+"INTERVAL"), fallback.Interval),
+	}
+
+	if !strings.HasPrefix(probe.Path, "/") {
+		return HealthProbe{}, fmt.Errorf("%sPATH must start with /", prefix)
+	}
+	if probe.ExpectedStatus < 100 || probe.ExpectedStatus > 599 {
+		return HealthProbe{}, fmt.Errorf("%sSTATUS must be a valid HTTP status", prefix)
+	}
+	if probe.Interval <= 0 {
+		return HealthProbe{}, fmt.Errorf("%sINTERVAL must be positive", prefix)
+	}
+	return probe, nil
+}
+
 func stringOrDefault(value string, fallback string) string {
 	if strings.TrimSpace(value) == "" {
 		return fallback
@@ -136,6 +1051,237 @@ func intOrDefault(raw string, fallback int) int {
 	return val
 }
 
+func int64OrDefault(raw string, fallback int64) int64 {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback
+	}
+	val, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+func floatOrDefault(raw string, fallback float64) float64 {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback
+	}
+	val, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+func boolOrDefault(raw string, fallback bool) bool {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback
+	}
+	val, err := strconv.ParseBool(raw)
+	if err != nil {
+		return fallback
+	}
+	return val
+}
+
+func levelOrDefault(raw string, fallback slog.Level) (slog.Level, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return fallback, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(raw)); err != nil {
+		return fallback, err
+	}
+	return level, nil
+}
+
+// robloxCookie reads the session cookie from PROXY_ROBLOX_COOKIE_FILE, which
+// keeps it out of the environment, or else from PROXY_ROBLOX_COOKIE.
+func robloxCookie(src source) (string, error) {
+	if path := strings.TrimSpace(src.get("PROXY_ROBLOX_COOKIE_FILE")); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read PROXY_ROBLOX_COOKIE_FILE: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return strings.TrimSpace(src.get("PROXY_ROBLOX_COOKIE")), nil
+}
+
+// parseServiceTimeouts reads PROXY_SERVICE_TIMEOUTS, keyed by lowercased
+// service.
+func parseServiceTimeouts(raw string) (map[string]time.Duration, error) {
+	pairs, err := parseDurationPairs("PROXY_SERVICE_TIMEOUTS", "service", raw)
+	if err != nil || pairs == nil {
+		return nil, err
+	}
+	timeouts := make(map[string]time.Duration, len(pairs))
+	for service, timeout := range pairs {
+		timeouts[strings.ToLower(service)] = timeout
+	}
+	return timeouts, nil
+}
+
+// parseServiceUserAgents reads PROXY_SERVICE_USER_AGENTS: "service=agent"
+// pairs separated by commas, or a JSON object of the same. Keys are lowercased.
+func parseServiceUserAgents(raw string) (map[string]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	pairs := map[string]string{}
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &pairs); err != nil {
+			return nil, fmt.Errorf("invalid PROXY_SERVICE_USER_AGENTS: %w", err)
+		}
+	} else {
+		for _, part := range splitAndClean(raw) {
+			service, agent, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid PROXY_SERVICE_USER_AGENTS entry %q: want service=agent", part)
+			}
+			pairs[service] = agent
+		}
+	}
+
+	agents := make(map[string]string, len(pairs))
+	for service, agent := range pairs {
+		service, agent = strings.ToLower(strings.TrimSpace(service)), strings.TrimSpace(agent)
+		if service == "" || agent == "" || strings.ContainsAny(agent, "\r\n") {
+			return nil, fmt.Errorf("invalid PROXY_SERVICE_USER_AGENTS entry for %q: want a non-empty service and single-line agent", service)
+		}
+		agents[service] = agent
+	}
+	return agents, nil
+}
+
+// parseRouteRateLimits parses route=rate:burst pairs, given as a
+// comma-separated list or a JSON object of strings, into route rate limits.
+func parseRouteRateLimits(raw string) (map[string]RouteRateLimit, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	pairs := map[string]string{}
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &pairs); err != nil {
+			return nil, fmt.Errorf("invalid PROXY_ROUTE_RATE_LIMITS: %w", err)
+		}
+	} else {
+		for _, part := range splitAndClean(raw) {
+			route, limit, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid PROXY_ROUTE_RATE_LIMITS entry %q: want route=rate:burst", part)
+			}
+			pairs[route] = limit
+		}
+	}
+
+	limits := make(map[string]RouteRateLimit, len(pairs))
+	for route, limit := range pairs {
+		route = strings.ToLower(strings.TrimSpace(route))
+		rate, burst, _ := strings.Cut(limit, ":")
+		perSecond, rateErr := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		n, burstErr := strconv.Atoi(strings.TrimSpace(burst))
+		if route == "" || rateErr != nil || burstErr != nil || perSecond <= 0 || n <= 0 {
+			return nil, fmt.Errorf("invalid PROXY_ROUTE_RATE_LIMITS entry %q: want route=rate:burst with a positive rate and burst", route+"="+limit)
+		}
+		limits[route] = RouteRateLimit{PerSecond: perSecond, Burst: n}
+	}
+	return limits, nil
+}
+
+// parseCacheRules reads PROXY_CACHEABLE_PATHS. Rules are ordered most
+// specific, i.e. longest pattern, first.
+func parseCacheRules(raw string) ([]CacheRule, error) {
+	pairs, err := parseDurationPairs("PROXY_CACHEABLE_PATHS", "pattern", raw)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]CacheRule, 0, len(pairs))
+	for pattern, ttl := range pairs {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "/") {
+			return nil, fmt.Errorf("invalid PROXY_CACHEABLE_PATHS pattern %q: must be an absolute path glob", pattern)
+		}
+		rules = append(rules, CacheRule{Pattern: pattern, TTL: ttl})
+	}
+	slices.SortFunc(rules, func(a, b CacheRule) int {
+		return cmp.Or(cmp.Compare(len(b.Pattern), len(a.Pattern)), strings.Compare(a.Pattern, b.Pattern))
+	})
+	return rules, nil
+}
+
+// parseDurationPairs reads "key=duration" pairs separated by commas, or a JSON
+// object of the same, as written by a config file. Durations must be positive.
+func parseDurationPairs(env, keyName, raw string) (map[string]time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	pairs := map[string]string{}
+	if strings.HasPrefix(raw, "{") {
+		if err := json.Unmarshal([]byte(raw), &pairs); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", env, err)
+		}
+	} else {
+		for _, part := range splitAndClean(raw) {
+			key, value, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid %s entry %q: want %s=duration", env, part, keyName)
+			}
+			pairs[key] = value
+		}
+	}
+
+	durations := make(map[string]time.Duration, len(pairs))
+	for key, value := range pairs {
+		key = strings.TrimSpace(key)
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || key == "" || d <= 0 {
+			return nil, fmt.Errorf("invalid %s entry %q: want %s=duration with a positive duration", env, key+"="+value, keyName)
+		}
+		durations[key] = d
+	}
+	return durations, nil
+}
+
+// validDomain reports whether s is a dotted domain name of letters, digits
+// and hyphens.
+func validDomain(s string) bool {
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// protectedResponseHeader reports whether name describes the response body or
+// its CORS policy, which clients cannot do without.
+func protectedResponseHeader(name string) bool {
+	switch name = http.CanonicalHeaderKey(name); name {
+	case "Content-Type", "Content-Length", "Content-Encoding", "Content-Range":
+		return true
+	}
+	return strings.HasPrefix(name, "Access-Control-")
+}
+
 func splitAndClean(raw string) []string {
 	if strings.TrimSpace(raw) == "" {
 		return nil
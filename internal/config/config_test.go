@@ -0,0 +1,111 @@
+package config
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseRouteRateLimits(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    map[string]RouteRateLimit
+		wantErr bool
+	}{
+		{raw: "", want: nil},
+		{
+			raw: "User-Search=0.5:2, games=10:20",
+			want: map[string]RouteRateLimit{
+				"user-search": {PerSecond: 0.5, Burst: 2},
+				"games":       {PerSecond: 10, Burst: 20},
+			},
+		},
+		{raw: `{"batch": "1:1"}`, want: map[string]RouteRateLimit{"batch": {PerSecond: 1, Burst: 1}}},
+		{raw: "games=10", wantErr: true},
+		{raw: "games=0:5", wantErr: true},
+		{raw: "games=5:0", wantErr: true},
+		{raw: "=5:5", wantErr: true},
+		{raw: "games", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseRouteRateLimits(tt.raw)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseRouteRateLimits(%q) = %v, want an error", tt.raw, got)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(got, tt.want) {
+			t.Errorf("parseRouteRateLimits(%q) = %v, %v; want %v", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+// loadMember loads a member config from the minimal environment plus env.
+func loadMember(t *testing.T, env map[string]string) (Config, error) {
+	t.Helper()
+	t.Setenv("PROXY_ROLE", "member")
+	t.Setenv("PROXY_MEMBER_CLUSTERS", "https://roblox.example")
+	t.Setenv("PROXY_IN_MEMORY_CACHE_SIZE", "1000")
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	return Load()
+}
+
+func TestLoadValidatesHeaderTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates string
+		wantErr   string
+	}{
+		{name: "known fields", templates: `{"https://roblox.example": {"X-Route": "{{.Target}} {{.Path}}?{{.Query}} {{.Hash}}"}}`},
+		{name: "unknown field", templates: `{"https://roblox.example": {"X-Route": "{{.Foo}}"}}`, wantErr: `header "X-Route"`},
+		{name: "syntax error", templates: `{"https://roblox.example": {"X-Route": "{{.Path"}}`, wantErr: `header "X-Route"`},
+		{name: "line break", templates: `{"https://roblox.example": {"X-Route": "a\r\nX-Injected: {{.Path}}"}}`, wantErr: "line break"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMember(t, map[string]string{"PROXY_MEMBER_HEADER_TEMPLATES": tt.templates})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Load: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRequiresReplayAcknowledgement(t *testing.T) {
+	tests := []struct {
+		name    string
+		ack     string
+		wantErr bool
+	}{
+		{name: "missing", ack: "", wantErr: true},
+		{name: "wrong value", ack: "yes", wantErr: true},
+		{name: "acknowledged", ack: replayAcknowledgement},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadMember(t, map[string]string{
+				"PROXY_UPSTREAM_REPLAY_MODE":        UpstreamReplayReplay,
+				"PROXY_UPSTREAM_REPLAY_DIR":         t.TempDir(),
+				"PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE": tt.ack,
+			})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE") {
+					t.Fatalf("Load error = %v, want the missing acknowledgement", err)
+				}
+				return
+			}
+			if err != nil || cfg.UpstreamReplayMode != UpstreamReplayReplay {
+				t.Fatalf("Load = %q, %v; want replay mode", cfg.UpstreamReplayMode, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// source resolves configuration variables from the environment, falling back
+// to values read from a config file.
+type source struct {
+	file map[string]string
+}
+
+// newSource reads the YAML or JSON config file at path, chosen by extension.
+// The file holds a flat mapping of variable names, as used in the environment,
+// to values. Lists may be given as sequences and JSON-valued variables such as
+// PROXY_ADD_REQUEST_HEADERS as nested mappings. An empty path reads no file.
+func newSource(path string) (source, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return source{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return source{}, fmt.Errorf("read PROXY_CONFIG_FILE: %w", err)
+	}
+
+	var raw map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return source{}, fmt.Errorf("PROXY_CONFIG_FILE %q must end in .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return source{}, fmt.Errorf("parse PROXY_CONFIG_FILE: %w", err)
+	}
+
+	file := make(map[string]string, len(raw))
+	for key, value := range raw {
+		str, err := fileValueString(value)
+		if err != nil {
+			return source{}, fmt.Errorf("PROXY_CONFIG_FILE %s: %w", key, err)
+		}
+		file[key] = str
+	}
+	return source{file: file}, nil
+}
+
+// fileValueString renders a decoded file value the way the same variable is
+// written in the environment.
+func fileValueString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			str, err := fileValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = str
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", value)
+	}
+}
+
+func (s source) get(key string) string {
+	v, _ := s.lookup(key)
+	return v
+}
+
+// lookup returns the environment value of key when set, otherwise the file
+// value.
+func (s source) lookup(key string) (string, bool) {
+	if v, ok := os.LookupEnv(key); ok {
+		return v, true
+	}
+	v, ok := s.file[key]
+	return v, ok
+}
+
+// Reloadable lists the settings Reload can apply to a running process. Every
+// other setting only takes effect after a restart.
+var Reloadable = []string{
+	"PROXY_CACHE_TTL",
+	"PROXY_CACHE_TTL_JITTER",
+	"PROXY_CACHE_TTL_JITTER_MODE",
+	"PROXY_CACHEABLE_PATHS",
+	"PROXY_USER_PAYLOAD_VALIDATION",
+	"PROXY_USER_AVATAR_FAILURE",
+	"PROXY_AVATAR_IMAGE_TTL",
+	"PROXY_BACKGROUND_REFRESH_AFTER",
+	"PROXY_MAX_SERVABLE_AGE",
+	"PROXY_RATE_LIMIT_PER_SECOND",
+	"PROXY_RATE_LIMIT_BURST",
+	"PROXY_RATE_LIMIT_MAX_CLIENTS",
+	"PROXY_ROUTE_RATE_LIMITS",
+	"PROXY_MEMBER_CLUSTERS",
+	"PROXY_MEMBER_HEADER_TEMPLATES",
+	"PROXY_DIRECT_TARGET_TEMPLATE",
+	"PROXY_PROVIDER_CLUSTERS",
+	"PROXY_STRIP_REQUEST_HEADERS",
+	"PROXY_ADD_REQUEST_HEADERS",
+	"PROXY_STRIP_RESPONSE_HEADERS",
+	"PROXY_ROBLOX_SERVICES",
+}
+
+// Reload returns current with the reloadable settings taken from next, which
+// must already be validated. It also reports the fields of next that differ
+// from current but need a restart to take effect.
+func Reload(current, next Config) (Config, []string) {
+	merged := current
+	merged.CacheTTL = next.CacheTTL
+	merged.CacheTTLJitter = next.CacheTTLJitter
+	merged.CacheTTLJitterMode = next.CacheTTLJitterMode
+	merged.CacheablePaths = next.CacheablePaths
+	merged.UserPayloadValidation = next.UserPayloadValidation
+	merged.UserAvatarFailure = next.UserAvatarFailure
+	merged.AvatarImageTTL = next.AvatarImageTTL
+	merged.BackgroundRefreshAfter = next.BackgroundRefreshAfter
+	merged.MaxServableAge = next.MaxServableAge
+	merged.RateLimitPerSecond = next.RateLimitPerSecond
+	merged.RateLimitBurst = next.RateLimitBurst
+	merged.RateLimitMaxClients = next.RateLimitMaxClients
+	merged.RouteRateLimits = next.RouteRateLimits
+	merged.MemberClusters = next.MemberClusters
+	merged.MemberHeaderTemplates = next.MemberHeaderTemplates
+	merged.DirectTargetTemplate = next.DirectTargetTemplate
+	merged.ProviderClusters = next.ProviderClusters
+	merged.StripRequestHeaders = next.StripRequestHeaders
+	merged.AddRequestHeaders = next.AddRequestHeaders
+	merged.StripResponseHeaders = next.StripResponseHeaders
+	merged.RobloxServices = next.RobloxServices
+
+	return merged, changedFields(merged, next)
+}
+
+// changedFields names the fields whose values differ between a and b.
+func changedFields(a, b Config) []string {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	var changed []string
+	for i := 0; i < av.NumField(); i++ {
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			changed = append(changed, av.Type().Field(i).Name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
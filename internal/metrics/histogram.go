@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency histograms.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Exemplar links a bucket to a trace that landed in it, following the
+// OpenMetrics exemplar model: a spike in the bucket can be followed to the
+// trace itself.
+type Exemplar struct {
+	TraceID   string    `json:"trace_id"`
+	Value     float64   `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Bucket is one cumulative histogram bucket: Count observations were at most
+// UpperBound. Exemplar is the latest observation with a trace that fell in this
+// bucket but not the one below it, if any.
+type Bucket struct {
+	UpperBound float64   `json:"le"`
+	Count      uint64    `json:"count"`
+	Exemplar   *Exemplar `json:"exemplar,omitempty"`
+}
+
+// HistogramSnapshot is the state of a histogram at one point in time. The last
+// bucket has an infinite upper bound, which JSON renders as "+Inf".
+type HistogramSnapshot struct {
+	Buckets []Bucket `json:"buckets"`
+	Count   uint64   `json:"count"`
+	Sum     float64  `json:"sum"`
+}
+
+// Histogram counts observations into fixed buckets. It is an expvar.Var, so it
+// is served with the other metrics.
+type Histogram struct {
+	bounds []float64
+
+	mu        sync.Mutex
+	counts    []uint64
+	exemplars []*Exemplar
+	count     uint64
+	sum       float64
+}
+
+// NewHistogram returns a histogram registered under name, replacing any
+// previous one. bounds are the bucket upper bounds; an infinite bucket is
+// always added after them.
+func NewHistogram(name string, bounds []float64) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	h := &Histogram{
+		bounds:    append(sorted, math.Inf(1)),
+		counts:    make([]uint64, len(sorted)+1),
+		exemplars: make([]*Exemplar, len(sorted)+1),
+	}
+	registry.Set(name, h)
+	return h
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	h.ObserveExemplar(v, "")
+}
+
+// ObserveExemplar records v and, when traceID is not empty, keeps it as the
+// exemplar of v's bucket.
+func (h *Histogram) ObserveExemplar(v float64, traceID string) {
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	if traceID != "" {
+		h.exemplars[i] = &Exemplar{TraceID: traceID, Value: v, Timestamp: time.Now()}
+	}
+}
+
+// Snapshot returns the current counts and exemplars.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	snap := HistogramSnapshot{Buckets: make([]Bucket, len(h.bounds)), Count: h.count, Sum: h.sum}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		snap.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+		if e := h.exemplars[i]; e != nil {
+			exemplar := *e
+			snap.Buckets[i].Exemplar = &exemplar
+		}
+	}
+	return snap
+}
+
+// String implements expvar.Var.
+func (h *Histogram) String() string {
+	b, err := json.Marshal(h.Snapshot())
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// MarshalJSON renders an infinite upper bound as "+Inf", which JSON numbers
+// cannot express.
+func (b Bucket) MarshalJSON() ([]byte, error) {
+	type bucket Bucket
+	if !math.IsInf(b.UpperBound, 1) {
+		return json.Marshal(bucket(b))
+	}
+	return json.Marshal(struct {
+		UpperBound string `json:"le"`
+		bucket
+	}{"+Inf", bucket(b)})
+}
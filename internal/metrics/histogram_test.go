@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHistogramCountsCumulativeBuckets(t *testing.T) {
+	h := NewHistogram("test_histogram", []float64{1, 0.1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v)
+	}
+
+	snap := h.Snapshot()
+	want := []uint64{2, 3, 4}
+	if len(snap.Buckets) != len(want) {
+		t.Fatalf("buckets = %d, want %d", len(snap.Buckets), len(want))
+	}
+	for i, b := range snap.Buckets {
+		if b.Count != want[i] {
+			t.Errorf("bucket le=%v count = %d, want %d", b.UpperBound, b.Count, want[i])
+		}
+		if b.Exemplar != nil {
+			t.Errorf("bucket le=%v has an exemplar without traced observations", b.UpperBound)
+		}
+	}
+	if snap.Count != 4 || snap.Sum != 3.65 {
+		t.Fatalf("count = %d, sum = %v, want 4 and 3.65", snap.Count, snap.Sum)
+	}
+	if !strings.Contains(h.String(), `"le":"+Inf"`) {
+		t.Fatalf("String() = %s, want an +Inf bucket", h.String())
+	}
+	if !json.Valid([]byte(h.String())) {
+		t.Fatalf("String() = %s, want valid JSON", h.String())
+	}
+}
+
+func TestHistogramKeepsLatestExemplarPerBucket(t *testing.T) {
+	h := NewHistogram("test_histogram", []float64{0.1, 1})
+	h.ObserveExemplar(0.5, "trace-a")
+	h.ObserveExemplar(0.7, "trace-b")
+	h.ObserveExemplar(0.05, "")
+
+	snap := h.Snapshot()
+	if e := snap.Buckets[0].Exemplar; e != nil {
+		t.Fatalf("bucket le=0.1 exemplar = %+v, want none", e)
+	}
+	e := snap.Buckets[1].Exemplar
+	if e == nil || e.TraceID != "trace-b" || e.Value != 0.7 {
+		t.Fatalf("bucket le=1 exemplar = %+v, want trace-b at 0.7", e)
+	}
+}
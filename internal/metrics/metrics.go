@@ -0,0 +1,35 @@
+// Package metrics publishes proxy counters and gauges through expvar. All
+// values live under the "proxy" map and are served as JSON at /metrics.
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var registry = expvar.NewMap("proxy")
+
+// Counter returns a counter registered under name, replacing any previous one.
+func Counter(name string) *expvar.Int {
+	v := new(expvar.Int)
+	registry.Set(name, v)
+	return v
+}
+
+// LabeledCounter returns a set of counters registered under name, keyed by a
+// label such as an operation, replacing any previous one.
+func LabeledCounter(name string) *expvar.Map {
+	v := new(expvar.Map).Init()
+	registry.Set(name, v)
+	return v
+}
+
+// Gauge registers fn to be sampled whenever metrics are read.
+func Gauge(name string, fn func() int64) {
+	registry.Set(name, expvar.Func(func() any { return fn() }))
+}
+
+// Handler serves all published expvars as JSON.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
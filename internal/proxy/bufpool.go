@@ -0,0 +1,45 @@
+package proxy
+
+import "sync"
+
+// DefaultCopyBufferSize is the size of response copy buffers when none is
+// configured.
+const DefaultCopyBufferSize = 32 << 10
+
+// BufferPool hands out reusable fixed-size buffers for streaming response
+// bodies. It is safe for concurrent use.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool constructs a pool of size-byte buffers. Non-positive sizes use
+// DefaultCopyBufferSize.
+func NewBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		size = DefaultCopyBufferSize
+	}
+	p := &BufferPool{size: size}
+	// Pointers avoid an allocation when the slice header is boxed by Put.
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	return p
+}
+
+// Get checks out a buffer. It must be handed back with Put once nothing
+// references it any more.
+func (p *BufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Put returns buf to the pool.
+func (p *BufferPool) Put(buf *[]byte) {
+	if buf == nil || len(*buf) != p.size {
+		return
+	}
+	p.pool.Put(buf)
+}
+
+var defaultBufferPool = NewBufferPool(DefaultCopyBufferSize)
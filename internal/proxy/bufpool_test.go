@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchmarkBodyBytes is the size of the response bodies streamed by the
+// benchmarks, several copy buffers long.
+const benchmarkBodyBytes = 256 << 10
+
+// discardWriter is an http.ResponseWriter that drops what it is sent. It does
+// not implement io.ReaderFrom, so copies into it go through the copy buffer.
+type discardWriter struct{ header http.Header }
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// bodyReader reads payload without implementing io.WriterTo, like a response
+// body, so copies from it go through the copy buffer.
+func bodyReader(payload []byte) io.Reader {
+	return struct{ io.Reader }{bytes.NewReader(payload)}
+}
+
+func BenchmarkForwarderStreamsBody(b *testing.B) {
+	payload := bytes.Repeat([]byte("x"), benchmarkBodyBytes)
+	upstream := newCountingUpstream(b, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(payload)
+	})
+	f := newTestForwarder(upstream.Client())
+	target := upstream.targetURL(b, "/games/v1/games")
+	r := httptest.NewRequest(http.MethodGet, "/games/v1/games", nil)
+
+	b.ReportAllocs()
+	b.SetBytes(benchmarkBodyBytes)
+	for b.Loop() {
+		w := &discardWriter{header: make(http.Header)}
+		if err := f.Do(w, r, target, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCopyBuffer compares copying a body through a pooled buffer with
+// allocating a buffer per copy, as the forwarder did before it pooled them.
+func BenchmarkCopyBuffer(b *testing.B) {
+	payload := bytes.Repeat([]byte("x"), benchmarkBodyBytes)
+	w := &discardWriter{header: make(http.Header)}
+
+	b.Run("pooled", func(b *testing.B) {
+		pool := NewBufferPool(DefaultCopyBufferSize)
+		b.ReportAllocs()
+		b.SetBytes(benchmarkBodyBytes)
+		for b.Loop() {
+			buf := pool.Get()
+			if _, err := io.CopyBuffer(w, bodyReader(payload), *buf); err != nil {
+				b.Fatal(err)
+			}
+			pool.Put(buf)
+		}
+	})
+	b.Run("allocated", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(benchmarkBodyBytes)
+		for b.Loop() {
+			buf := make([]byte, DefaultCopyBufferSize)
+			if _, err := io.CopyBuffer(w, bodyReader(payload), buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
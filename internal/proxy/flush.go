@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+)
+
+// flushWriter flushes the wrapped response after every threshold bytes so
+// large bodies reach the client while they are still being copied, even behind
+// layers that would otherwise buffer them until the handler returns.
+type flushWriter struct {
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	threshold int
+	pending   int
+}
+
+// newFlushWriter wraps w. A non-positive threshold disables flushing and
+// returns w unchanged.
+func newFlushWriter(w http.ResponseWriter, threshold int) http.ResponseWriter {
+	if threshold <= 0 {
+		return w
+	}
+	return &flushWriter{w: w, rc: http.NewResponseController(w), threshold: threshold}
+}
+
+func (fw *flushWriter) Header() http.Header { return fw.w.Header() }
+
+func (fw *flushWriter) WriteHeader(status int) { fw.w.WriteHeader(status) }
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil || fw.rc == nil {
+		return n, err
+	}
+	fw.pending += n
+	if fw.pending >= fw.threshold {
+		fw.pending = 0
+		if err := fw.rc.Flush(); err != nil {
+			if !errors.Is(err, http.ErrNotSupported) {
+				return n, err
+			}
+			// Writers that cannot flush are simply written to.
+			fw.rc = nil
+		}
+	}
+	return n, nil
+}
+
+func (fw *flushWriter) Unwrap() http.ResponseWriter { return fw.w }
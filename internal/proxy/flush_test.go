@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flushCounter records what was written before each flush.
+type flushCounter struct {
+	http.ResponseWriter
+	written string
+	flushed []string
+}
+
+func (c *flushCounter) Write(p []byte) (int, error) {
+	c.written += string(p)
+	return len(p), nil
+}
+
+func (c *flushCounter) Flush() { c.flushed = append(c.flushed, c.written) }
+
+func TestFlushWriterFlushesEveryThreshold(t *testing.T) {
+	c := &flushCounter{ResponseWriter: httptest.NewRecorder()}
+	w := newFlushWriter(c, 4)
+	for _, chunk := range []string{"ab", "cd", "e", "fgh", "i"} {
+		if _, err := io.WriteString(w, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []string{"abcd", "abcdefgh"}; !slices.Equal(c.flushed, want) {
+		t.Fatalf("flushed after %q, want %q", c.flushed, want)
+	}
+}
+
+func TestFlushWriterDisabled(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if w := newFlushWriter(rec, 0); w != http.ResponseWriter(rec) {
+		t.Fatal("a zero threshold wrapped the writer")
+	}
+}
+
+func TestForwarderStreamsChunkedResponses(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newCountingUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "first\n")
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(w, "second\n")
+	})
+	f := newTestForwarder(upstream.Client())
+	f.FlushBytes = 1
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := f.Do(w, r, upstream.targetURL(t, "/games/v1/stream"), nil); err != nil {
+			t.Errorf("Do: %v", err)
+		}
+	}))
+	t.Cleanup(front.Close)
+	defer close(release)
+
+	resp, err := front.Client().Get(front.URL + "/games/v1/stream")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if !slices.Contains(resp.TransferEncoding, "chunked") {
+		t.Fatalf("transfer encoding = %q, want chunked", resp.TransferEncoding)
+	}
+
+	// The first chunk must arrive while the upstream is still holding the
+	// rest of the body.
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if strings.TrimSpace(line) != "first" {
+			t.Fatalf("first chunk = %q, want %q", line, "first")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the first chunk was held back until the response completed")
+	}
+}
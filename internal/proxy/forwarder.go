@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -8,11 +9,19 @@ import (
 	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/tracing"
 )
 
 // Forwarder streams the incoming request to an upstream target with minimal overhead.
@@ -21,8 +30,72 @@ type Forwarder struct {
 	Logger            *slog.Logger
 	RequestTimeout    time.Duration
 	DiscordWebhookURL string
+	// MaxRequestBodyBytes caps the size of request bodies relayed upstream. Zero
+	// disables the limit.
+	MaxRequestBodyBytes int64
+	// Tracker records in-flight forwards for graceful draining. May be nil.
+	Tracker *Tracker
+	// StripRequestHeaders lists client headers that are never sent upstream.
+	StripRequestHeaders []string
+	// AddRequestHeaders are set on every upstream request, replacing any
+	// client-supplied value.
+	AddRequestHeaders map[string]string
+	// StripResponseHeaders lists upstream response headers that are never
+	// relayed to the client.
+	StripResponseHeaders []string
+	// headerMu guards the header rules once SetRequestHeaderRules or
+	// SetResponseHeaderRules may run concurrently with requests.
+	headerMu sync.RWMutex
+	// Limiter bounds concurrent upstream requests. Nil is unbounded.
+	Limiter *UpstreamLimiter
+	// Buffers supplies the buffers response bodies are copied through. Nil
+	// uses a shared pool of DefaultCopyBufferSize buffers.
+	Buffers *BufferPool
+	// FlushBytes flushes the response to the client after every FlushBytes
+	// bytes copied. Zero leaves flushing to the server.
+	FlushBytes int
+	// ServiceTimeouts overrides RequestTimeout per Roblox service, keyed by
+	// the first segment of the request path.
+	ServiceTimeouts map[string]time.Duration
+	// RobloxAuth injects the Roblox session cookie into allow-listed
+	// requests. Nil disables it.
+	RobloxAuth *RobloxAuth
+	// StreamingPaths are request path prefixes, such as long-poll or
+	// server-sent event endpoints, whose responses may stay open: they have no
+	// upstream timeout and are flushed as each chunk arrives.
+	StreamingPaths []string
+	// Shadow mirrors sampled read requests to shadow targets. Nil disables
+	// mirroring.
+	Shadow *Shadow
+	// ForwardedHeaders is the config.ForwardedHeaders style used to tell the
+	// upstream about the client. Empty means config.ForwardedHeadersLegacy.
+	ForwardedHeaders string
+	// UserAgent is the user agent of requests the proxy builds itself.
+	UserAgent string
+	// ServiceUserAgents overrides UserAgent per Roblox service, keyed by the
+	// lowercased first segment of the request path. Relayed requests to a
+	// listed service carry the override instead of the client's user agent.
+	ServiceUserAgents map[string]string
+}
+
+// ErrRequestBodyTooLarge is returned by Do when the incoming body exceeds the
+// configured MaxRequestBodyBytes.
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+
+// RoundTripError wraps a failure to obtain a response from the upstream. When
+// DoVia returns one nothing has been written to the client, so the request can
+// be retried elsewhere if its body allows.
+type RoundTripError struct {
+	Err error
 }
 
+func (e *RoundTripError) Error() string { return e.Err.Error() }
+
+func (e *RoundTripError) Unwrap() error { return e.Err }
+
+// ErrDraining is returned when a forward is attempted after shutdown has begun.
+var ErrDraining = errors.New("proxy is shutting down")
+
 var hopHeaders = []string{
 	"Connection",
 	"Proxy-Connection",
@@ -35,41 +108,135 @@ var hopHeaders = []string{
 	"Upgrade",
 }
 
-// Do forwards the request to the target URL.
-func (f *Forwarder) Do(w http.ResponseWriter, r *http.Request, target *url.URL) error {
-	if f.Client == nil {
+// Do forwards the request to the target URL. Headers in extra are set on the
+// upstream request after the client's headers have been copied.
+func (f *Forwarder) Do(w http.ResponseWriter, r *http.Request, target *url.URL, extra http.Header) error {
+	return f.DoVia(f.Client, w, r, target, extra)
+}
+
+// DoVia is like Do but sends the upstream request with client, for targets
+// that need their own transport.
+func (f *Forwarder) DoVia(client *http.Client, w http.ResponseWriter, r *http.Request, target *url.URL, extra http.Header) (err error) {
+	if client == nil {
 		return errors.New("forwarder client is nil")
 	}
 
-	f.Logger.Info("forwarding request", slog.String("method", r.Method), slog.String("url", r.URL.String()), slog.String("target", target.String()))
+	if err := f.limitBody(w, r); err != nil {
+		return err
+	}
+
+	if !f.Tracker.Begin() {
+		return ErrDraining
+	}
+	defer f.Tracker.Done()
+
+	f.Logger.InfoContext(r.Context(), "forwarding request", slog.String("method", r.Method), slog.String("url", r.URL.String()), slog.String("target", target.String()))
+
+	ctx, span := tracing.Tracer().Start(r.Context(), "proxy.forward", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(attribute.String("http.request.method", r.Method), attribute.String("server.address", target.Host))
+	defer func() { tracing.EndSpan(span, err) }()
+
+	streaming := f.Streams(r.URL.Path)
+	if streaming {
+		// Only the client going away ends the request. The server's own
+		// deadlines would otherwise cut the connection.
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+		unbounded := *client
+		unbounded.Timeout = 0
+		client = &unbounded
+	} else {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.TimeoutFor(ServiceOf(r.URL.Path)))
+		defer cancel()
+	}
+
+	reqmeta.FromContext(r.Context()).SetUpstreamHost(target.Host)
 
-	ctx, cancel := context.WithTimeout(r.Context(), f.RequestTimeout)
-	defer cancel()
+	upstreamReq, err := cloneRequestWithURL(ctx, r, target, f.ForwardedHeaders)
+	if err != nil {
+		return err
+	}
+	if agent, ok := f.ServiceUserAgents[strings.ToLower(ServiceOf(r.URL.Path))]; ok {
+		upstreamReq.Header.Set("User-Agent", agent)
+	}
+	f.ApplyRequestHeaderRules(upstreamReq.Header)
+	if id := reqmeta.FromContext(r.Context()).RequestID(); id != "" {
+		upstreamReq.Header.Set(reqmeta.HeaderRequestID, id)
+	}
+	for k, vv := range extra {
+		upstreamReq.Header[k] = vv
+	}
+	authenticated := f.RobloxAuth.Applies(target)
+	if authenticated {
+		// The body is kept so the request can be replayed after a CSRF
+		// challenge.
+		if err := bufferBody(upstreamReq); err != nil {
+			return err
+		}
+		f.RobloxAuth.Apply(upstreamReq.Header)
+	}
 
-	upstreamReq, err := cloneRequestWithURL(ctx, r, target)
+	release, err := f.Limiter.Acquire(ctx)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	reqResp, err := f.Client.Do(upstreamReq)
+	reqResp, err := f.roundTrip(client, upstreamReq)
 	if err != nil {
 		return err
 	}
+	if authenticated && f.RobloxAuth.Challenged(reqResp) {
+		reqResp.Body.Close()
+		retry := upstreamReq.Clone(ctx)
+		if upstreamReq.GetBody != nil {
+			if retry.Body, err = upstreamReq.GetBody(); err != nil {
+				return err
+			}
+		}
+		f.RobloxAuth.Apply(retry.Header)
+		if reqResp, err = f.roundTrip(client, retry); err != nil {
+			return err
+		}
+	}
 	defer reqResp.Body.Close()
+	if authenticated {
+		f.RobloxAuth.Scrub(reqResp.Header)
+	}
 
 	if reqResp.StatusCode == 429 {
 		config.SendDiscordWebhook(f.DiscordWebhookURL, fmt.Sprintf("Received 429 from upstream: %s", target.String()))
 	}
 
+	span.SetAttributes(attribute.Int("http.response.status_code", reqResp.StatusCode))
+
 	copyHeaders(w.Header(), reqResp.Header)
 	for _, h := range hopHeaders {
 		w.Header().Del(h)
 	}
+	f.ApplyResponseHeaderRules(w.Header())
 	w.WriteHeader(reqResp.StatusCode)
 
+	flushBytes := f.FlushBytes
+	if streaming || IsEventStream(reqResp.Header.Get("Content-Type")) {
+		// Send the headers now and every chunk as soon as it is read.
+		_ = http.NewResponseController(w).Flush()
+		flushBytes = 1
+	}
+
 	if reqResp.Body != nil {
-		buf := make([]byte, 32*1024)
-		if _, err := io.CopyBuffer(w, reqResp.Body, buf); err != nil {
+		pool := f.Buffers
+		if pool == nil {
+			pool = defaultBufferPool
+		}
+		// CopyBuffer is synchronous, so the buffer is unreferenced once it
+		// returns and can go straight back to the pool.
+		buf := pool.Get()
+		_, err := io.CopyBuffer(newFlushWriter(w, flushBytes), reqResp.Body, *buf)
+		pool.Put(buf)
+		if err != nil {
 			return err
 		}
 	}
@@ -77,7 +244,177 @@ func (f *Forwarder) Do(w http.ResponseWriter, r *http.Request, target *url.URL)
 	return nil
 }
 
-func cloneRequestWithURL(ctx context.Context, r *http.Request, target *url.URL) (*http.Request, error) {
+// Mirror sends a copy of a request for path and rawQuery to Shadow, if the
+// request is sampled. header is copied, with the request header rules
+// applied, before Mirror returns; the mirrored request never affects the
+// caller.
+func (f *Forwarder) Mirror(ctx context.Context, method, path, rawQuery string, header http.Header) {
+	if f.Shadow == nil {
+		return
+	}
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	f.ApplyRequestHeaderRules(header)
+	f.Shadow.mirror(ctx, method, path, rawQuery, header)
+}
+
+// Streams reports whether path is under one of StreamingPaths.
+func (f *Forwarder) Streams(path string) bool {
+	for _, prefix := range f.StreamingPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEventStream reports whether contentType is text/event-stream.
+func IsEventStream(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream")
+}
+
+// roundTrip sends req, wrapping failures to get a response in RoundTripError.
+func (f *Forwarder) roundTrip(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return nil, ErrRequestBodyTooLarge
+		}
+		return nil, &RoundTripError{Err: err}
+	}
+	return resp, nil
+}
+
+// bufferBody reads req's body into memory and sets GetBody so the request can
+// be sent again. The body has already been bounded by limitBody.
+func bufferBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return ErrRequestBodyTooLarge
+		}
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	req.TransferEncoding = nil
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil
+}
+
+// TimeoutFor returns the upstream timeout for requests to service.
+func (f *Forwarder) TimeoutFor(service string) time.Duration {
+	if timeout, ok := f.ServiceTimeouts[strings.ToLower(service)]; ok {
+		return timeout
+	}
+	return f.RequestTimeout
+}
+
+// UserAgentFor returns the user agent of requests the proxy builds itself for
+// service.
+func (f *Forwarder) UserAgentFor(service string) string {
+	if agent, ok := f.ServiceUserAgents[strings.ToLower(service)]; ok {
+		return agent
+	}
+	return f.UserAgent
+}
+
+// ServiceOf returns the Roblox service a proxied path addresses, which is its
+// first segment.
+func ServiceOf(path string) string {
+	service, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return service
+}
+
+// limitBody rejects oversized bodies before any upstream connection is made.
+// Bodies of unknown length are buffered up to the limit so the check can happen
+// up front as well.
+func (f *Forwarder) limitBody(w http.ResponseWriter, r *http.Request) error {
+	if f.MaxRequestBodyBytes <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	if r.ContentLength > f.MaxRequestBodyBytes {
+		return ErrRequestBodyTooLarge
+	}
+
+	limited := http.MaxBytesReader(w, r.Body, f.MaxRequestBodyBytes)
+	if r.ContentLength >= 0 {
+		r.Body = limited
+		return nil
+	}
+
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return ErrRequestBodyTooLarge
+		}
+		return err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	return nil
+}
+
+// ApplyRequestHeaderRules strips and injects the configured request headers.
+// Header names are matched case-insensitively.
+func (f *Forwarder) ApplyRequestHeaderRules(header http.Header) {
+	f.headerMu.RLock()
+	defer f.headerMu.RUnlock()
+
+	for _, name := range f.StripRequestHeaders {
+		header.Del(name)
+	}
+	for name, value := range f.AddRequestHeaders {
+		header.Set(name, value)
+	}
+}
+
+// SetRequestHeaderRules replaces the header rules of a forwarder that is
+// already serving requests.
+func (f *Forwarder) SetRequestHeaderRules(strip []string, add map[string]string) {
+	f.headerMu.Lock()
+	defer f.headerMu.Unlock()
+
+	f.StripRequestHeaders = strip
+	f.AddRequestHeaders = add
+}
+
+// ApplyResponseHeaderRules strips the configured response headers. Header
+// names are matched case-insensitively.
+func (f *Forwarder) ApplyResponseHeaderRules(header http.Header) {
+	f.headerMu.RLock()
+	defer f.headerMu.RUnlock()
+
+	for _, name := range f.StripResponseHeaders {
+		header.Del(name)
+	}
+}
+
+// SetResponseHeaderRules replaces the response header rules of a forwarder
+// that is already serving requests.
+func (f *Forwarder) SetResponseHeaderRules(strip []string) {
+	f.headerMu.Lock()
+	defer f.headerMu.Unlock()
+
+	f.StripResponseHeaders = strip
+}
+
+func cloneRequestWithURL(ctx context.Context, r *http.Request, target *url.URL, forwarded string) (*http.Request, error) {
 	var body io.ReadCloser
 	if r.Body != nil {
 		body = r.Body
@@ -93,7 +430,8 @@ func cloneRequestWithURL(ctx context.Context, r *http.Request, target *url.URL)
 		upstreamReq.Header.Del(h)
 	}
 
-	setForwardedHeaders(upstreamReq.Header, r)
+	setForwardedHeaders(upstreamReq.Header, r, forwarded)
+	tracing.Inject(ctx, upstreamReq.Header)
 
 	upstreamReq.ContentLength = r.ContentLength
 	upstreamReq.TransferEncoding = r.TransferEncoding
@@ -103,26 +441,81 @@ func cloneRequestWithURL(ctx context.Context, r *http.Request, target *url.URL)
 	return upstreamReq, nil
 }
 
-func setForwardedHeaders(header http.Header, r *http.Request) {
+// ClientIP extracts the address of the directly connected client. IPv6
+// addresses are returned without brackets, and IPv4-mapped ones as IPv4.
+func ClientIP(r *http.Request) string {
 	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		clientIP = r.RemoteAddr
+		clientIP = strings.TrimSuffix(strings.TrimPrefix(r.RemoteAddr, "["), "]")
+	}
+	if addr, err := netip.ParseAddr(clientIP); err == nil {
+		return addr.Unmap().String()
 	}
+	return clientIP
+}
+
+// setForwardedHeaders describes the client to the upstream in the given
+// config.ForwardedHeaders style, extending any chain an earlier proxy sent.
+func setForwardedHeaders(header http.Header, r *http.Request, style string) {
+	clientIP := ClientIP(r)
+	proto := schemeFromRequest(r)
 
-	if clientIP != "" {
-		prior := header.Get("X-Forwarded-For")
-		if prior == "" {
-			header.Set("X-Forwarded-For", clientIP)
-		} else {
-			header.Set("X-Forwarded-For", strings.Join([]string{prior, clientIP}, ", "))
+	if style != config.ForwardedHeadersRFC7239 {
+		if clientIP != "" {
+			// Earlier hops may have sent the header more than once; they
+			// form a single list.
+			chain := header.Values("X-Forwarded-For")
+			header.Set("X-Forwarded-For", strings.Join(append(chain, clientIP), ", "))
 		}
+
+		if r.Header.Get("X-Forwarded-Proto") == "" {
+			header.Set("X-Forwarded-Proto", proto)
+		}
+
+		header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	if style == config.ForwardedHeadersRFC7239 || style == config.ForwardedHeadersBoth {
+		element := "for=" + forwardedNode(clientIP) + ";proto=" + forwardedValue(proto)
+		if r.Host != "" {
+			element += ";host=" + forwardedValue(r.Host)
+		}
+		chain := header.Values("Forwarded")
+		header.Set("Forwarded", strings.Join(append(chain, element), ", "))
+	}
+}
+
+// forwardedNode formats ip as an RFC 7239 node. IPv6 addresses are bracketed
+// and quoted, and anything that is not an address is reported as unknown.
+func forwardedNode(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "unknown"
 	}
+	if addr.Is6() {
+		return `"[` + addr.WithZone("").String() + `]"`
+	}
+	return addr.String()
+}
 
-	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "" {
-		header.Set("X-Forwarded-Proto", schemeFromRequest(r))
+// forwardedValue quotes v unless it is a token, as RFC 7239 requires of values
+// such as a host with a port.
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return strconv.Quote(v)
+		}
 	}
+	return v
+}
 
-	header.Set("X-Forwarded-Host", r.Host)
+// isTokenChar reports whether c may appear in an RFC 7230 token.
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
 }
 
 func schemeFromRequest(r *http.Request) string {
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// countingUpstream is an httptest server that records every connection
+// dialled to it and every request it answers with handle.
+type countingUpstream struct {
+	*httptest.Server
+	conns    atomic.Int64
+	requests atomic.Int64
+}
+
+func newCountingUpstream(t testing.TB, handle http.HandlerFunc) *countingUpstream {
+	t.Helper()
+	u := &countingUpstream{}
+	u.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		handle(w, r)
+	}))
+	u.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			u.conns.Add(1)
+		}
+	}
+	u.Start()
+	t.Cleanup(u.Close)
+	return u
+}
+
+// targetURL returns the upstream URL of path on u.
+func (u *countingUpstream) targetURL(t testing.TB, path string) *url.URL {
+	t.Helper()
+	target, err := url.Parse(u.URL + path)
+	if err != nil {
+		t.Fatalf("parse target: %v", err)
+	}
+	return target
+}
+
+func newTestForwarder(client *http.Client) *Forwarder {
+	return &Forwarder{Client: client, Logger: slog.New(slog.DiscardHandler), RequestTimeout: 5 * time.Second}
+}
+
+func TestForwarderRejectsOversizedBodyBeforeDialing(t *testing.T) {
+	const limit = 16
+	oversized := strings.Repeat("x", limit+1)
+
+	tests := []struct {
+		name string
+		// chunked hides the body length, so the limit can only be enforced
+		// by reading the body.
+		chunked bool
+	}{
+		{name: "declared length"},
+		{name: "chunked", chunked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newCountingUpstream(t, func(w http.ResponseWriter, r *http.Request) {})
+			f := newTestForwarder(upstream.Client())
+			f.MaxRequestBodyBytes = limit
+
+			req := httptest.NewRequest(http.MethodPost, "/games/v1/games", strings.NewReader(oversized))
+			if tt.chunked {
+				req.Body = io.NopCloser(req.Body)
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+
+			err := f.Do(rec, req, upstream.targetURL(t, "/games/v1/games"), nil)
+			if !errors.Is(err, ErrRequestBodyTooLarge) {
+				t.Fatalf("Do error = %v, want ErrRequestBodyTooLarge", err)
+			}
+			if n := upstream.conns.Load(); n != 0 {
+				t.Fatalf("upstream saw %d connections, want 0", n)
+			}
+		})
+	}
+}
+
+func TestForwarderRelaysBodyWithinLimit(t *testing.T) {
+	const limit = 16
+	var got string
+	upstream := newCountingUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got = string(b)
+	})
+	f := newTestForwarder(upstream.Client())
+	f.MaxRequestBodyBytes = limit
+
+	req := httptest.NewRequest(http.MethodPost, "/games/v1/games", io.NopCloser(strings.NewReader("small")))
+	req.ContentLength = -1
+	if err := f.Do(httptest.NewRecorder(), req, upstream.targetURL(t, "/games/v1/games"), nil); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+	if got != "small" {
+		t.Fatalf("upstream body = %q, want %q", got, "small")
+	}
+}
+
+func TestForwarderAppliesRequestHeaderRules(t *testing.T) {
+	received := make(chan http.Header, 1)
+	upstream := newCountingUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	})
+	f := newTestForwarder(upstream.Client())
+	f.StripRequestHeaders = []string{"cookie", "X-Internal-Token"}
+	f.AddRequestHeaders = map[string]string{"X-Proxy-Source": "member"}
+
+	req := httptest.NewRequest(http.MethodGet, "/users/v1/users/1", nil)
+	req.Header.Set("Cookie", ".ROBLOSECURITY=secret")
+	req.Header.Set("X-Internal-Token", "secret")
+	req.Header.Set("X-Proxy-Source", "client")
+	req.Header.Set("Accept", "application/json")
+	if err := f.Do(httptest.NewRecorder(), req, upstream.targetURL(t, "/users/v1/users/1"), nil); err != nil {
+		t.Fatalf("Do: %v", err)
+	}
+
+	got := <-received
+	for _, name := range []string{"Cookie", "X-Internal-Token"} {
+		if v := got.Get(name); v != "" {
+			t.Errorf("upstream received %s = %q, want it stripped", name, v)
+		}
+	}
+	if v := got.Get("X-Proxy-Source"); v != "member" {
+		t.Errorf("upstream received X-Proxy-Source = %q, want the configured value", v)
+	}
+	if v := got.Get("Accept"); v != "application/json" {
+		t.Errorf("upstream received Accept = %q, want it relayed", v)
+	}
+	if req.Header.Get("Cookie") == "" {
+		t.Error("the client request's headers were modified")
+	}
+}
+
+func TestSetForwardedHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		host       string
+		incoming   http.Header
+		style      string
+		wantXFF    string
+		wantFwd    string
+	}{
+		{
+			name:       "ipv4 legacy",
+			remoteAddr: "203.0.113.7:52100",
+			host:       "proxy.example",
+			style:      config.ForwardedHeadersLegacy,
+			wantXFF:    "203.0.113.7",
+		},
+		{
+			name:       "ipv6 legacy",
+			remoteAddr: "[2001:db8::1]:52100",
+			host:       "proxy.example",
+			style:      config.ForwardedHeadersLegacy,
+			wantXFF:    "2001:db8::1",
+		},
+		{
+			name:       "ipv4-mapped ipv6 is reported as ipv4",
+			remoteAddr: "[::ffff:203.0.113.7]:52100",
+			host:       "proxy.example",
+			style:      config.ForwardedHeadersBoth,
+			wantXFF:    "203.0.113.7",
+			wantFwd:    "for=203.0.113.7;proto=http;host=proxy.example",
+		},
+		{
+			name:       "legacy chain is extended",
+			remoteAddr: "203.0.113.7:52100",
+			host:       "proxy.example",
+			incoming:   http.Header{"X-Forwarded-For": {"198.51.100.1", "198.51.100.2"}},
+			style:      config.ForwardedHeadersLegacy,
+			wantXFF:    "198.51.100.1, 198.51.100.2, 203.0.113.7",
+		},
+		{
+			name:       "ipv4 rfc7239",
+			remoteAddr: "203.0.113.7:52100",
+			host:       "proxy.example",
+			style:      config.ForwardedHeadersRFC7239,
+			wantFwd:    "for=203.0.113.7;proto=http;host=proxy.example",
+		},
+		{
+			name:       "ipv6 rfc7239 is bracketed and quoted",
+			remoteAddr: "[2001:db8::1]:52100",
+			host:       "proxy.example",
+			style:      config.ForwardedHeadersRFC7239,
+			wantFwd:    `for="[2001:db8::1]";proto=http;host=proxy.example`,
+		},
+		{
+			name:       "zone is dropped and host with port is quoted",
+			remoteAddr: "[fe80::1%eth0]:52100",
+			host:       "proxy.example:8080",
+			style:      config.ForwardedHeadersRFC7239,
+			wantFwd:    `for="[fe80::1]";proto=http;host="proxy.example:8080"`,
+		},
+		{
+			name:       "rfc7239 chain is extended",
+			remoteAddr: "[2001:db8::1]:52100",
+			host:       "proxy.example",
+			incoming:   http.Header{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-Proto": {"https"}},
+			style:      config.ForwardedHeadersRFC7239,
+			wantFwd:    `for=198.51.100.1, for="[2001:db8::1]";proto=https;host=proxy.example`,
+		},
+		{
+			name:       "unparseable address is unknown",
+			remoteAddr: "pipe",
+			host:       "proxy.example",
+			style:      config.ForwardedHeadersBoth,
+			wantXFF:    "pipe",
+			wantFwd:    "for=unknown;proto=http;host=proxy.example",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users/v1/users/1", nil)
+			r.RemoteAddr = tc.remoteAddr
+			r.Host = tc.host
+			for k, vv := range tc.incoming {
+				r.Header[k] = vv
+			}
+			header := r.Header.Clone()
+
+			setForwardedHeaders(header, r, tc.style)
+
+			if got := header.Get("X-Forwarded-For"); got != tc.wantXFF {
+				t.Errorf("X-Forwarded-For = %q, want %q", got, tc.wantXFF)
+			}
+			if got := header.Get("Forwarded"); got != tc.wantFwd {
+				t.Errorf("Forwarded = %q, want %q", got, tc.wantFwd)
+			}
+			if n := len(header.Values("X-Forwarded-For")); n > 1 {
+				t.Errorf("X-Forwarded-For sent %d times, want one list", n)
+			}
+			if tc.style == config.ForwardedHeadersRFC7239 && header.Get("X-Forwarded-Host") != "" {
+				t.Error("the forwarded style also set X-Forwarded-Host")
+			}
+		})
+	}
+}
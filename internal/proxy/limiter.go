@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// ErrUpstreamSaturated is returned when no upstream request slot frees up
+// within the limiter's wait.
+var ErrUpstreamSaturated = errors.New("too many concurrent upstream requests")
+
+// UpstreamLimiter bounds the number of upstream requests in flight across the
+// process. A nil limiter is unbounded.
+type UpstreamLimiter struct {
+	sem      *semaphore.Weighted
+	wait     time.Duration
+	inFlight atomic.Int64
+}
+
+// NewUpstreamLimiter allows max concurrent upstream requests, each waiting up
+// to wait for a slot. It returns nil when max is not positive.
+func NewUpstreamLimiter(max int, wait time.Duration) *UpstreamLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &UpstreamLimiter{sem: semaphore.NewWeighted(int64(max)), wait: wait}
+}
+
+// Acquire takes a slot, waiting until one frees up, the wait elapses, or ctx
+// is done. The returned function releases the slot.
+func (l *UpstreamLimiter) Acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	if !l.sem.TryAcquire(1) {
+		waitCtx, cancel := context.WithTimeout(ctx, l.wait)
+		defer cancel()
+		if err := l.sem.Acquire(waitCtx, 1); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, ErrUpstreamSaturated
+		}
+	}
+
+	l.inFlight.Add(1)
+	return func() {
+		l.inFlight.Add(-1)
+		l.sem.Release(1)
+	}, nil
+}
+
+// InFlight reports the number of upstream requests currently holding a slot.
+func (l *UpstreamLimiter) InFlight() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.inFlight.Load()
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+const headerCSRFToken = "X-Csrf-Token"
+
+// RobloxAuth authenticates requests to allow-listed Roblox endpoints with a
+// .ROBLOSECURITY session cookie. The cookie is only ever sent over HTTPS to
+// hosts under the Roblox base domain, and neither it nor anything derived from it is relayed
+// back to clients.
+type RobloxAuth struct {
+	cookie string
+	// hostSuffix is the base domain with a leading dot, e.g. ".roblox.com".
+	hostSuffix string
+	// paths are allow-listed "subdomain/path" prefixes, e.g. "friends" or
+	// "inventory/v2/users".
+	paths []string
+	// csrf is the last X-CSRF-TOKEN Roblox challenged with, reused until it
+	// is rejected.
+	csrf atomic.Pointer[string]
+}
+
+// NewRobloxAuth returns an authenticator sending cookie to subdomains of
+// baseDomain, or nil when cookie or paths are empty.
+func NewRobloxAuth(cookie, baseDomain string, paths []string) *RobloxAuth {
+	if cookie == "" || len(paths) == 0 {
+		return nil
+	}
+	a := &RobloxAuth{cookie: cookie, hostSuffix: "." + strings.ToLower(baseDomain)}
+	for _, p := range paths {
+		if p = strings.Trim(strings.ToLower(p), "/"); p != "" {
+			a.paths = append(a.paths, p)
+		}
+	}
+	return a
+}
+
+// Applies reports whether requests to target carry the session cookie. A nil
+// RobloxAuth applies to nothing.
+func (a *RobloxAuth) Applies(target *url.URL) bool {
+	if a == nil || target.Scheme != "https" {
+		return false
+	}
+	sub, ok := strings.CutSuffix(strings.ToLower(target.Hostname()), a.hostSuffix)
+	if !ok || sub == "" {
+		return false
+	}
+	key := strings.TrimSuffix(sub+"/"+strings.Trim(strings.ToLower(target.Path), "/"), "/")
+	for _, p := range a.paths {
+		if key == p || strings.HasPrefix(key, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply replaces the request's cookies with the session cookie and attaches
+// the current CSRF token, if one is known.
+func (a *RobloxAuth) Apply(header http.Header) {
+	header.Set("Cookie", ".ROBLOSECURITY="+a.cookie)
+	if token := a.csrf.Load(); token != nil {
+		header.Set(headerCSRFToken, *token)
+	} else {
+		header.Del(headerCSRFToken)
+	}
+}
+
+// Challenged reports whether resp is Roblox's CSRF challenge: a 403 carrying
+// a fresh token. The token is remembered for the retry and later requests.
+func (a *RobloxAuth) Challenged(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden {
+		return false
+	}
+	token := resp.Header.Get(headerCSRFToken)
+	if token == "" {
+		return false
+	}
+	a.csrf.Store(&token)
+	return true
+}
+
+// Scrub removes session state Roblox sends back so it never reaches clients.
+func (a *RobloxAuth) Scrub(header http.Header) {
+	header.Del("Set-Cookie")
+	header.Del(headerCSRFToken)
+}
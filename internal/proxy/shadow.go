@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
+)
+
+// HeaderShadow marks requests sent to a shadow target, so the shadow can tell
+// mirrored traffic apart from its own.
+const HeaderShadow = "X-Proxy-Shadow"
+
+// Shadow mirrors a sample of read requests to shadow targets and discards
+// their responses. Mirroring runs in the background: a slow, failing or
+// saturated shadow never delays or changes the response sent to the client.
+type Shadow struct {
+	targets  []*url.URL
+	fraction float64
+	client   *http.Client
+	logger   *slog.Logger
+	timeout  time.Duration
+	sem      *semaphore.Weighted
+	next     atomic.Uint64
+
+	sent    *expvar.Int
+	failed  *expvar.Int
+	dropped *expvar.Int
+}
+
+// NewShadow returns a Shadow for cfg's shadow targets, or nil when none are
+// configured or ShadowFraction is zero.
+func NewShadow(cfg config.Config, client *http.Client, logger *slog.Logger) *Shadow {
+	if len(cfg.ShadowTargets) == 0 || cfg.ShadowFraction <= 0 {
+		return nil
+	}
+	s := &Shadow{
+		fraction: cfg.ShadowFraction,
+		client:   client,
+		logger:   logger,
+		timeout:  cfg.RequestTimeout,
+		sem:      semaphore.NewWeighted(int64(cfg.ShadowConcurrency)),
+		sent:     metrics.Counter("shadow_requests_sent"),
+		failed:   metrics.Counter("shadow_requests_failed"),
+		dropped:  metrics.Counter("shadow_requests_dropped"),
+	}
+	for _, raw := range cfg.ShadowTargets {
+		// Entries were validated by config.Load.
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		u.Path = strings.TrimRight(u.Path, "/")
+		s.targets = append(s.targets, u)
+	}
+	return s
+}
+
+// mirror sends a copy of a GET or HEAD request for path and rawQuery to the
+// next shadow target when the request is sampled. Other methods are never
+// mirrored, since replaying them could repeat side effects. mirror takes
+// ownership of header.
+func (s *Shadow) mirror(ctx context.Context, method, path, rawQuery string, header http.Header) {
+	if method != http.MethodGet && method != http.MethodHead {
+		return
+	}
+	if rand.Float64() >= s.fraction {
+		return
+	}
+	if !s.sem.TryAcquire(1) {
+		s.dropped.Add(1)
+		return
+	}
+
+	base := s.targets[(s.next.Add(1)-1)%uint64(len(s.targets))]
+	target := base.ResolveReference(&url.URL{Path: base.Path + path, RawQuery: rawQuery})
+
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+	header.Set(HeaderShadow, "1")
+	if id := reqmeta.FromContext(ctx).RequestID(); id != "" {
+		header.Set(reqmeta.HeaderRequestID, id)
+	}
+
+	// The mirror outlives the client request, so it keeps only its values.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer s.sem.Release(1)
+		ctx, cancel := context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+
+		s.sent.Add(1)
+		if err := s.send(ctx, method, target, header); err != nil {
+			s.failed.Add(1)
+			s.logger.WarnContext(ctx, "shadow request failed", slog.String("target", target.Redacted()), slog.String("error", err.Error()))
+		}
+	}()
+}
+
+// send performs one mirrored request and drains its response.
+func (s *Shadow) send(ctx context.Context, method string, target *url.URL, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("shadow target answered %s", resp.Status)
+	}
+	return nil
+}
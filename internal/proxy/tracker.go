@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+)
+
+// Tracker counts in-flight upstream requests so shutdown can wait for them to
+// finish. A nil Tracker is valid and tracks nothing.
+type Tracker struct {
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	draining bool
+}
+
+// Begin registers an in-flight request. It returns false once draining has
+// started, in which case the caller must not proceed and must not call Done.
+func (t *Tracker) Begin() bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+// Done marks a request registered with Begin as finished.
+func (t *Tracker) Done() {
+	if t == nil {
+		return
+	}
+	t.wg.Done()
+}
+
+// StartDrain stops new requests from being registered.
+func (t *Tracker) StartDrain() {
+	t.mu.Lock()
+	t.draining = true
+	t.mu.Unlock()
+}
+
+// Draining reports whether StartDrain has been called.
+func (t *Tracker) Draining() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.draining
+}
+
+// Wait blocks until every registered request has finished or ctx is done.
+func (t *Tracker) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Keyed tracks an independent token bucket per key. The number of tracked keys
+// is bounded; the least recently seen key is evicted when the bound is reached.
+type Keyed struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   int
+	maxKeys int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type keyedEntry struct {
+	key    string
+	bucket bucket
+}
+
+// NewKeyed constructs a limiter granting each key rate tokens per second with
+// the given burst, remembering at most maxKeys keys.
+func NewKeyed(rate float64, burst, maxKeys int) *Keyed {
+	if maxKeys < 1 {
+		maxKeys = 1
+	}
+	return &Keyed{
+		rate:    rate,
+		burst:   burst,
+		maxKeys: maxKeys,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Allow consumes a token from the bucket for key, reporting the wait before the
+// next token when the bucket is empty.
+func (k *Keyed) Allow(key string, now time.Time) (bool, time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if el, ok := k.entries[key]; ok {
+		k.order.MoveToFront(el)
+		return el.Value.(*keyedEntry).bucket.take(now)
+	}
+
+	if k.order.Len() >= k.maxKeys {
+		oldest := k.order.Back()
+		k.order.Remove(oldest)
+		delete(k.entries, oldest.Value.(*keyedEntry).key)
+	}
+
+	entry := &keyedEntry{key: key, bucket: newBucket(k.rate, k.burst, now)}
+	k.entries[key] = k.order.PushFront(entry)
+	return entry.bucket.take(now)
+}
+
+// Len reports the number of keys currently tracked.
+func (k *Keyed) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.order.Len()
+}
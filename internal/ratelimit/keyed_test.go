@@ -0,0 +1,47 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedEvictsLeastRecentlySeenKey(t *testing.T) {
+	now := time.Now()
+	k := NewKeyed(0, 1, 2)
+
+	mustAllow := func(key string, want bool) {
+		t.Helper()
+		if got, _ := k.Allow(key, now); got != want {
+			t.Fatalf("Allow(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	mustAllow("a", true)
+	mustAllow("b", true)
+	// Seeing a again makes b the least recently seen key.
+	mustAllow("a", false)
+	mustAllow("c", true)
+
+	if n := k.Len(); n != 2 {
+		t.Fatalf("Len = %d, want the bound of 2", n)
+	}
+	// a kept its empty bucket; b was evicted and starts over with a full one.
+	mustAllow("a", false)
+	mustAllow("b", true)
+}
+
+func TestKeyedRefillsOverTime(t *testing.T) {
+	now := time.Now()
+	k := NewKeyed(2, 1, 10)
+
+	if ok, _ := k.Allow("a", now); !ok {
+		t.Fatal("first request denied")
+	}
+	ok, wait := k.Allow("a", now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("Allow = %v, %v; want false, 500ms", ok, wait)
+	}
+	if ok, _ := k.Allow("a", now.Add(wait)); !ok {
+		t.Fatal("request after the reported wait denied")
+	}
+}
@@ -0,0 +1,89 @@
+package reqmeta
+
+import (
+	"context"
+	"sync"
+)
+
+type contextKey struct{}
+
+// Info collects details about how a request was served so they can be reported
+// once the response is complete. A nil *Info is valid and records nothing.
+type Info struct {
+	mu           sync.Mutex
+	requestID    string
+	upstreamHost string
+	cacheResult  string
+}
+
+// NewContext returns a copy of ctx carrying a fresh Info.
+func NewContext(ctx context.Context) (context.Context, *Info) {
+	info := &Info{}
+	return context.WithValue(ctx, contextKey{}, info), info
+}
+
+// FromContext returns the Info carried by ctx, or nil.
+func FromContext(ctx context.Context) *Info {
+	info, _ := ctx.Value(contextKey{}).(*Info)
+	return info
+}
+
+// SetRequestID records the correlation ID of the request.
+func (i *Info) SetRequestID(id string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.requestID = id
+	i.mu.Unlock()
+}
+
+// RequestID returns the recorded correlation ID.
+func (i *Info) RequestID() string {
+	if i == nil {
+		return ""
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.requestID
+}
+
+// SetUpstreamHost records the upstream host that served the request.
+func (i *Info) SetUpstreamHost(host string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.upstreamHost = host
+	i.mu.Unlock()
+}
+
+// UpstreamHost returns the recorded upstream host.
+func (i *Info) UpstreamHost() string {
+	if i == nil {
+		return ""
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.upstreamHost
+}
+
+// SetCacheResult records the cache outcome for the request.
+func (i *Info) SetCacheResult(result string) {
+	if i == nil {
+		return
+	}
+	i.mu.Lock()
+	i.cacheResult = result
+	i.mu.Unlock()
+}
+
+// CacheResult returns the recorded cache outcome.
+func (i *Info) CacheResult() string {
+	if i == nil {
+		return ""
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.cacheResult
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+const (
+	adminPathPrefix = "/admin/"
+	adminFlushPath  = "/admin/cache/flush"
+	cachePath       = "/cache"
+	cachePathPrefix = "/cache/"
+
+	maxAdminBodyBytes = 4 << 10
+)
+
+// adminHandler serves operator endpoints behind a bearer token. It is only
+// constructed when PROXY_ADMIN_TOKEN is set.
+type adminHandler struct {
+	token  []byte
+	prefix string
+	cache  cache.Store
+	logger *slog.Logger
+	// keys resolves /cache/{kind}/{id} routes onto cache keys. Nil when the
+	// role has no logical cache entries.
+	keys func(kind, id string) ([]string, error)
+	// flushTimeout bounds a cache flush, so one over a large keyspace stops
+	// and reports progress before the server's write timeout.
+	flushTimeout time.Duration
+}
+
+func newAdminHandler(cfg config.Config, logger *slog.Logger, cacheStore cache.Store) *adminHandler {
+	if cfg.AdminToken == "" {
+		return nil
+	}
+	return &adminHandler{
+		token:        []byte(cfg.AdminToken),
+		prefix:       cfg.CacheKeyPrefix,
+		cache:        cacheStore,
+		logger:       logger.With(slog.String("component", "admin")),
+		flushTimeout: cfg.AdminFlushTimeout,
+	}
+}
+
+func (a *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	switch {
+	case r.URL.Path == adminFlushPath:
+		a.handleFlush(w, r)
+	case r.URL.Path == cachePath, strings.HasPrefix(r.URL.Path, cachePathPrefix):
+		a.handleInvalidate(w, r)
+	default:
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "unknown admin endpoint")
+	}
+}
+
+func (a *adminHandler) authorized(r *http.Request) bool {
+	return bearerAuthorized(r, a.token)
+}
+
+// bearerAuthorized reports whether r carries token as its bearer credential.
+func bearerAuthorized(r *http.Request, token []byte) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), token) == 1
+}
+
+// handleInvalidate deletes a single cache entry, named either directly as
+// DELETE /cache?key=... or logically as DELETE /cache/{kind}/{id}.
+func (a *adminHandler) handleInvalidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var keys []string
+	if r.URL.Path == cachePath {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "key is required")
+			return
+		}
+		keys = []string{key}
+	} else {
+		kind, id, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, cachePathPrefix), "/")
+		if !ok || a.keys == nil {
+			apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "unknown cache entry kind")
+			return
+		}
+		resolved, err := a.keys(kind, id)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, err.Error())
+			return
+		}
+		keys = resolved
+	}
+
+	for _, key := range keys {
+		if err := a.cache.Delete(r.Context(), key); err != nil {
+			a.logger.ErrorContext(r.Context(), "cache invalidation failed", slog.String("key", key), slog.String("error", err.Error()))
+			apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "cache invalidation failed")
+			return
+		}
+	}
+
+	a.logger.InfoContext(r.Context(), "cache invalidated", slog.Any("keys", keys), slog.String("remote", r.RemoteAddr))
+	data, err := json.Marshal(struct {
+		Deleted []string `json:"deleted"`
+	}{keys})
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "encode response")
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// handleFlush deletes every cache key under the proxy's prefix. The body must
+// repeat the prefix as {"confirm": "<prefix>"} so a stray request cannot wipe
+// the cache. Without a prefix a flush would also delete keys belonging to
+// anything else in the store, so it is refused.
+func (a *adminHandler) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.prefix == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeBadRequest, "cache flush requires a cache key prefix")
+		return
+	}
+
+	var body struct {
+		Confirm string `json:"confirm"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAdminBodyBytes)).Decode(&body); err != nil || body.Confirm != a.prefix {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeBadRequest, "confirm must equal the cache key prefix")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.flushTimeout)
+	defer cancel()
+
+	deleted, err := cache.DeletePrefix(ctx, a.cache, a.prefix)
+	if err != nil {
+		a.logger.Error("cache flush failed", slog.String("prefix", a.prefix), slog.Int64("deleted", deleted), slog.String("error", err.Error()))
+		status, message := http.StatusInternalServerError, fmt.Sprintf("cache flush failed after deleting %d keys", deleted)
+		switch {
+		case errors.Is(err, cache.ErrUnsupported):
+			status = http.StatusNotImplemented
+		case errors.Is(err, context.DeadlineExceeded):
+			// Deleted keys stay deleted, so repeating the flush continues
+			// where this one stopped.
+			status, message = http.StatusGatewayTimeout, fmt.Sprintf("cache flush timed out after deleting %d keys; repeat it to continue", deleted)
+		}
+		apierror.Write(w, status, apierror.FromStatus(status), message)
+		return
+	}
+
+	a.logger.Warn("cache flushed", slog.String("prefix", a.prefix), slog.Int64("deleted", deleted), slog.String("remote", r.RemoteAddr))
+	writeJSON(w, http.StatusOK, []byte(fmt.Sprintf(`{"deleted":%d}`, deleted)))
+}
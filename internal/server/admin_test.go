@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache/memorystore"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+const testAdminToken = "admin-secret"
+
+// newFlushTest returns an admin handler for prefix over an in-memory store
+// seeded with keys.
+func newFlushTest(t *testing.T, prefix string, keys ...string) (*adminHandler, *memorystore.Store) {
+	t.Helper()
+	store := memorystore.New(config.Config{InMemoryCacheSize: 100})
+	t.Cleanup(func() { _ = store.Close() })
+	for _, key := range keys {
+		if err := store.Set(context.Background(), key, []byte(`{}`), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := newAdminHandler(config.Config{AdminToken: testAdminToken, CacheKeyPrefix: prefix, AdminFlushTimeout: 5 * time.Second}, slog.New(slog.DiscardHandler), store)
+	return a, store
+}
+
+func flush(a *adminHandler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, adminFlushPath, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFlushDeletesOnlyPrefixedKeys(t *testing.T) {
+	a, store := newFlushTest(t, "proxy-a:", "proxy-a:user:1", "proxy-a:search:bob", "proxy-b:user:1", "user:1")
+
+	rec := flush(a, `{"confirm":"proxy-a:"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Body.String(); got != `{"deleted":2}` {
+		t.Fatalf("body = %s, want the deleted count", got)
+	}
+	for key, want := range map[string]bool{"proxy-a:user:1": false, "proxy-a:search:bob": false, "proxy-b:user:1": true, "user:1": true} {
+		if _, ok, _ := store.Get(context.Background(), key); ok != want {
+			t.Errorf("%s present = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestFlushRequiresConfirmation(t *testing.T) {
+	a, store := newFlushTest(t, "proxy-a:", "proxy-a:user:1")
+
+	for _, body := range []string{``, `{"confirm":"proxy-b:"}`} {
+		if rec := flush(a, body); rec.Code != http.StatusBadRequest {
+			t.Fatalf("flush with %q: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if store.Len() != 1 {
+		t.Fatalf("store holds %d keys after refused flushes, want 1", store.Len())
+	}
+}
+
+func TestFlushRefusedWithoutPrefix(t *testing.T) {
+	a, store := newFlushTest(t, "", "user:1")
+
+	if rec := flush(a, `{"confirm":""}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("store holds %d keys, want 1", store.Len())
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+)
+
+// Authenticate rejects requests whose bearer token is not one of tokens with
+// 401 Unauthorized. Several tokens may be valid at once so keys can be rotated
+// without downtime. Paths in exempt pass through untouched, as do paths next
+// authenticates itself, such as the admin endpoints.
+func Authenticate(next http.Handler, tokens []string, exempt []string) http.Handler {
+	// Comparing fixed-size digests keeps the comparison constant-time
+	// regardless of token length.
+	digests := make([][sha256.Size]byte, len(tokens))
+	for i, token := range tokens {
+		digests[i] = sha256.Sum256([]byte(token))
+	}
+
+	exemptPaths := make(map[string]struct{}, len(exempt))
+	for _, p := range exempt {
+		exemptPaths[p] = struct{}{}
+	}
+
+	self, _ := next.(selfAuthenticator)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := exemptPaths[r.URL.Path]
+		if ok || self != nil && self.authenticates(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !matchesAny(sha256.Sum256([]byte(token)), digests) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// selfAuthenticator is implemented by handlers that enforce their own
+// credentials on some paths.
+type selfAuthenticator interface {
+	authenticates(path string) bool
+}
+
+// matchesAny compares digest against every candidate without returning early,
+// so timing does not reveal which token matched.
+func matchesAny(digest [sha256.Size]byte, candidates [][sha256.Size]byte) bool {
+	match := 0
+	for i := range candidates {
+		match |= subtle.ConstantTimeCompare(digest[:], candidates[i][:])
+	}
+	return match == 1
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
+)
+
+const (
+	debugPathPrefix  = "/debug/"
+	debugCachePath   = "/debug/cache"
+	debugTargetsPath = "/debug/targets"
+)
+
+// debugHandler serves read-only inspection endpoints behind the admin token.
+// It is only constructed when PROXY_DEBUG_ENDPOINTS is enabled.
+type debugHandler struct {
+	token  []byte
+	cache  cache.Store
+	logger *slog.Logger
+	// role and health describe the upstream targets. health is nil when
+	// health checks are disabled.
+	role   string
+	health *upstream.HealthChecker
+}
+
+func newDebugHandler(cfg config.Config, logger *slog.Logger, cacheStore cache.Store) *debugHandler {
+	if !cfg.DebugEndpoints || cfg.AdminToken == "" {
+		return nil
+	}
+	return &debugHandler{
+		token:  []byte(cfg.AdminToken),
+		cache:  cacheStore,
+		logger: logger.With(slog.String("component", "debug")),
+	}
+}
+
+func (d *debugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !bearerAuthorized(r, d.token) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthorized, "unauthorized")
+		return
+	}
+
+	switch r.URL.Path {
+	case debugCachePath:
+		d.handleCache(w, r)
+	case debugTargetsPath:
+		d.handleTargets(w, r)
+	default:
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "unknown debug endpoint")
+	}
+}
+
+type debugCacheEntry struct {
+	Key        string    `json:"key"`
+	StoredAt   time.Time `json:"storedAt"`
+	ExpiresAt  time.Time `json:"expiresAt,omitzero"`
+	AgeSeconds float64   `json:"ageSeconds"`
+	// TTLRemainingSeconds is negative once the entry is stale and omitted when
+	// the entry never expires.
+	TTLRemainingSeconds *float64        `json:"ttlRemainingSeconds,omitempty"`
+	Expired             bool            `json:"expired"`
+	ContentType         string          `json:"contentType,omitempty"`
+	ETag                string          `json:"etag,omitempty"`
+	LastModified        string          `json:"lastModified,omitempty"`
+	Payload             json.RawMessage `json:"payload,omitempty"`
+	// Body carries non-JSON payloads, base64 encoded.
+	Body []byte `json:"body,omitempty"`
+}
+
+// handleCache reports the entry stored under the key query parameter exactly
+// as cache.Store.Get returns it.
+func (d *debugHandler) handleCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "key is required")
+		return
+	}
+
+	entry, ok, err := d.cache.Get(r.Context(), key)
+	if err != nil {
+		d.logger.ErrorContext(r.Context(), "debug cache lookup failed", slog.String("key", key), slog.String("error", err.Error()))
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "cache lookup failed")
+		return
+	}
+	if !ok {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "key not found in cache")
+		return
+	}
+
+	now := time.Now()
+	resp := debugCacheEntry{
+		Key:          key,
+		StoredAt:     entry.StoredAt,
+		ExpiresAt:    entry.ExpiresAt,
+		AgeSeconds:   now.Sub(entry.StoredAt).Seconds(),
+		Expired:      entry.Expired(now),
+		ContentType:  entry.ContentType,
+		ETag:         entry.ETag,
+		LastModified: entry.LastModified,
+	}
+	if !entry.ExpiresAt.IsZero() {
+		remaining := entry.ExpiresAt.Sub(now).Seconds()
+		resp.TTLRemainingSeconds = &remaining
+	}
+	if (entry.ContentType == "" || entry.ContentType == "application/json") && json.Valid(entry.Payload) {
+		resp.Payload = entry.Payload
+	} else {
+		resp.Body = entry.Payload
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "encode cache entry")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, data)
+}
+
+type debugTargets struct {
+	Role    string        `json:"role"`
+	Targets []debugTarget `json:"targets"`
+}
+
+type debugTarget struct {
+	URL  string `json:"url"`
+	Kind string `json:"kind"`
+	// ProbeURL is the URL health probes are sent to.
+	ProbeURL  string    `json:"probeUrl"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"lastCheck,omitzero"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// handleTargets lists the upstream targets in configuration order with the
+// health last observed by the background checker.
+func (d *debugHandler) handleTargets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if d.health == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "health checks are disabled")
+		return
+	}
+
+	resp := debugTargets{Role: d.role, Targets: []debugTarget{}}
+	for _, report := range d.health.Snapshot() {
+		resp.Targets = append(resp.Targets, debugTarget{
+			URL:       report.Check.Name,
+			Kind:      report.Check.Kind,
+			ProbeURL:  report.Check.URL.Redacted(),
+			Healthy:   report.Health.Healthy,
+			LastCheck: report.Health.LastCheck,
+			Failures:  report.Health.Failures,
+			LastError: report.Health.LastError,
+		})
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "encode targets")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, data)
+}
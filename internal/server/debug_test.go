@@ -0,0 +1,43 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// failingStore is a cache store whose reads all fail.
+type failingStore struct{ cache.Store }
+
+func (failingStore) Get(context.Context, string) (cache.Entry, bool, error) {
+	return cache.Entry{}, false, errors.New("store unavailable")
+}
+
+func TestDebugCacheLogsThroughInjectedLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	d := newDebugHandler(config.Config{DebugEndpoints: true, AdminToken: testAdminToken}, logger, failingStore{})
+
+	req := httptest.NewRequest(http.MethodGet, debugCachePath+"?key=roblox:user:1", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	d.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+	line := logs.String()
+	for _, want := range []string{"debug cache lookup failed", "component=debug", "key=roblox:user:1", "store unavailable"} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("log = %q, want it to contain %q", line, want)
+		}
+	}
+}
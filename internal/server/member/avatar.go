@@ -0,0 +1,353 @@
+package member
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+)
+
+const (
+	defaultAvatarSize   = "420x420"
+	maxAvatarImageBytes = 4 << 20
+	// userAvatarSize is the size of the avatarUrl embedded in user payloads.
+	userAvatarSize = "48x48"
+)
+
+// avatarSizes lists the sizes accepted by Roblox's avatar-bust thumbnails.
+var avatarSizes = map[string]struct{}{
+	"48x48":   {},
+	"50x50":   {},
+	"60x60":   {},
+	"75x75":   {},
+	"100x100": {},
+	"150x150": {},
+	"180x180": {},
+	"352x352": {},
+	"420x420": {},
+}
+
+var errAvatarNotFound = errors.New("avatar image not available")
+
+// parseAvatarSizes parses a comma-separated list of avatar sizes. The result
+// is deduplicated and ordered by width so equivalent lists share a cache
+// entry. An empty list yields nil; an unknown size is an error.
+func parseAvatarSizes(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var sizes []string
+	for _, size := range strings.Split(raw, ",") {
+		size = strings.TrimSpace(size)
+		if _, ok := avatarSizes[size]; !ok {
+			return nil, fmt.Errorf("invalid avatar size %q", size)
+		}
+		if !slices.Contains(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	slices.SortFunc(sizes, func(a, b string) int {
+		return cmp.Compare(avatarWidth(a), avatarWidth(b))
+	})
+	return sizes, nil
+}
+
+// avatarWidth returns the width of a size from avatarSizes.
+func avatarWidth(size string) int {
+	width, _, _ := strings.Cut(size, "x")
+	n, _ := strconv.Atoi(width)
+	return n
+}
+
+// fetchUserAvatarURL fetches the avatar URL embedded in user payloads.
+func (h *Handler) fetchUserAvatarURL(ctx context.Context, userID string) (string, error) {
+	if h.avatarBatch != nil {
+		return h.avatarBatch.lookup(ctx, userID, userAvatarSize)
+	}
+
+	params := url.Values{
+		"userIds":    {userID},
+		"size":       {userAvatarSize},
+		"format":     {"Png"},
+		"isCircular": {"false"},
+	}
+
+	var avatarResp struct {
+		Data []struct {
+			ImageURL string `json:"imageUrl"`
+		} `json:"data"`
+	}
+
+	if err := h.fetchJSON(ctx, thumbnailsService, "/v1/users/avatar-bust", params, &avatarResp); err != nil {
+		return "", err
+	}
+	return firstAvatarURL(avatarResp.Data), nil
+}
+
+// thumbnailBatchRequest is one entry of a thumbnails /v1/batch request.
+type thumbnailBatchRequest struct {
+	RequestID  string `json:"requestId"`
+	TargetID   int64  `json:"targetId"`
+	Type       string `json:"type"`
+	Size       string `json:"size"`
+	Format     string `json:"format"`
+	IsCircular bool   `json:"isCircular"`
+}
+
+// fetchAvatarURLs fetches a user's avatar URL in each of sizes with a single
+// thumbnails batch request. Every size is present in the result; sizes
+// Roblox could not render map to "".
+func (h *Handler) fetchAvatarURLs(ctx context.Context, userID string, sizes []string) (map[string]string, error) {
+	targetID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return nil, errInvalidUserID
+	}
+
+	batch := make([]thumbnailBatchRequest, len(sizes))
+	for i, size := range sizes {
+		batch[i] = thumbnailBatchRequest{
+			RequestID: userID + ":" + size,
+			TargetID:  targetID,
+			Type:      "AvatarBust",
+			Size:      size,
+			Format:    "Png",
+		}
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+
+	var batchResp struct {
+		Data []struct {
+			RequestID string `json:"requestId"`
+			ImageURL  string `json:"imageUrl"`
+		} `json:"data"`
+	}
+	if err := h.fetchPost(ctx, thumbnailsService, "/v1/batch", body, &batchResp); err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string]string, len(sizes))
+	for _, size := range sizes {
+		urls[size] = ""
+	}
+	for _, entry := range batchResp.Data {
+		_, size, ok := strings.Cut(entry.RequestID, ":")
+		if _, requested := urls[size]; ok && requested {
+			urls[size] = entry.ImageURL
+		}
+	}
+	return urls, nil
+}
+
+func (h *Handler) lookupAvatarURL(ctx context.Context, userID string) (string, error) {
+	return h.lookupAvatarURLSize(ctx, userID, defaultAvatarSize)
+}
+
+func (h *Handler) lookupAvatarURLSize(ctx context.Context, userID, size string) (string, error) {
+	result, err := h.lookupAvatar(ctx, userID, size)
+	if err != nil {
+		return "", err
+	}
+
+	var body struct {
+		URL string `json:"url"`
+	}
+
+	if err := json.Unmarshal(result.payload, &body); err != nil {
+		return "", err
+	}
+
+	return body.URL, nil
+}
+
+// lookupAvatar serves the cached avatar URL payload, {"url": ...}, for a user.
+func (h *Handler) lookupAvatar(ctx context.Context, userID, size string) (cachedPayload, error) {
+	key := h.avatarCacheKey(userID, size)
+	ttl := h.popularity.observe(key, h.config().CacheTTL)
+	return h.readThroughEntry(ctx, opAvatar, key, ttl, h.avatarFetcher(userID, size))
+}
+
+// avatarFetcher loads the avatar URL payload for a user. When a previous
+// response is cached its validators are sent upstream, and a 304 keeps the
+// cached payload rather than replacing it, extending its lifetime. Without
+// validators to send, the lookup joins an avatar batch when batching is on;
+// batched responses carry no per-user validators.
+func (h *Handler) avatarFetcher(userID, size string) entryFetcher {
+	return func(ctx context.Context, prior *cache.Entry) (cache.Entry, error) {
+		if h.avatarBatch != nil && !hasValidators(prior) {
+			avatarURL, err := h.avatarBatch.lookup(ctx, userID, size)
+			if err != nil {
+				return cache.Entry{}, err
+			}
+			return avatarEntry(avatarURL, "", "")
+		}
+
+		params := url.Values{
+			"userIds":    {userID},
+			"size":       {size},
+			"format":     {"Png"},
+			"isCircular": {"false"},
+		}
+
+		res, err := h.fetchConditional(ctx, thumbnailsService, "/v1/users/avatar-bust", params, prior)
+		if err != nil {
+			return cache.Entry{}, err
+		}
+		if res.notModified {
+			return *prior, nil
+		}
+
+		var avatarResp struct {
+			Data []struct {
+				ImageURL string `json:"imageUrl"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(res.body, &avatarResp); err != nil {
+			return cache.Entry{}, err
+		}
+
+		return avatarEntry(firstAvatarURL(avatarResp.Data), res.etag, res.lastModified)
+	}
+}
+
+// hasValidators reports whether prior can be revalidated conditionally.
+func hasValidators(prior *cache.Entry) bool {
+	return prior != nil && (prior.ETag != "" || prior.LastModified != "")
+}
+
+// avatarEntry builds the cached avatar URL payload, {"url": ...}.
+func avatarEntry(avatarURL, etag, lastModified string) (cache.Entry, error) {
+	payload, err := json.Marshal(struct {
+		URL string `json:"url"`
+	}{URL: avatarURL})
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	return cache.Entry{
+		Payload:      payload,
+		ContentType:  contentTypeJSON,
+		ETag:         etag,
+		LastModified: lastModified,
+	}, nil
+}
+
+// handleAvatarImage serves a user's avatar image. With binary caching enabled the
+// PNG bytes are cached and served directly; otherwise the client is redirected
+// to Roblox's CDN.
+func (h *Handler) handleAvatarImage(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	userID := strings.TrimSpace(q.Get("userId"))
+	if !isNumeric(userID) {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidUserID, "Invalid or missing userId")
+		return
+	}
+
+	size := strings.TrimSpace(q.Get("size"))
+	if size == "" {
+		size = defaultAvatarSize
+	}
+	if _, ok := avatarSizes[size]; !ok {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "Invalid avatar size")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.lookupTimeout(thumbnailsService))
+	defer cancel()
+
+	if !h.config().AvatarImageCaching {
+		imageURL, err := h.lookupAvatarURLSize(ctx, userID, size)
+		if err != nil {
+			h.respondLookupError(w, err)
+			return
+		}
+		if imageURL == "" {
+			h.respondError(w, http.StatusNotFound, errAvatarNotFound)
+			return
+		}
+		http.Redirect(w, r, imageURL, http.StatusFound)
+		return
+	}
+
+	key := h.avatarImageCacheKey(userID, size)
+	result, err := h.readThroughEntry(ctx, opImage, key, h.config().AvatarImageTTL, func(ctx context.Context, _ *cache.Entry) (cache.Entry, error) {
+		return h.fetchAvatarImage(ctx, userID, size)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errAvatarNotFound):
+			h.respondError(w, http.StatusNotFound, err)
+		case errors.Is(err, errFetchOverloaded), errors.Is(err, errThumbnailsSaturated), errors.Is(err, proxy.ErrDraining), errors.Is(err, proxy.ErrUpstreamSaturated):
+			h.respondError(w, http.StatusServiceUnavailable, err)
+		default:
+			h.respondError(w, http.StatusBadGateway, err)
+		}
+		return
+	}
+
+	w.Header().Set(headerContentType, result.contentType)
+	w.Header().Set(headerAccessControlAllowOrigin, corsAllowOrigin)
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(h.config().AvatarImageTTL.Seconds())))
+	setCacheHeaders(w.Header(), result)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(result.payload)
+}
+
+func (h *Handler) fetchAvatarImage(ctx context.Context, userID, size string) (cache.Entry, error) {
+	imageURL, err := h.lookupAvatarURLSize(ctx, userID, size)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	if imageURL == "" {
+		return cache.Entry{}, errAvatarNotFound
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	req.Header.Set("User-Agent", h.forwarder.UserAgent)
+
+	if !h.forwarder.Tracker.Begin() {
+		return cache.Entry{}, proxy.ErrDraining
+	}
+	defer h.forwarder.Tracker.Done()
+
+	resp, err := h.forwarder.Client.Do(req)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return cache.Entry{}, fmt.Errorf("avatar image request failed: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAvatarImageBytes+1))
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	if len(body) > maxAvatarImageBytes {
+		return cache.Entry{}, fmt.Errorf("avatar image exceeds %d bytes", maxAvatarImageBytes)
+	}
+
+	contentType := resp.Header.Get(headerContentType)
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+
+	return cache.Entry{Payload: body, ContentType: contentType}, nil
+}
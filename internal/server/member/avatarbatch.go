@@ -0,0 +1,148 @@
+package member
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/tracing"
+)
+
+// maxAvatarBatch is the most user IDs Roblox accepts in one avatar-bust call.
+const maxAvatarBatch = 100
+
+// avatarBatcher merges concurrent single-user avatar-bust lookups of one size
+// that arrive within window into a single upstream call, so enriching a page
+// of search results costs one thumbnails request instead of one per user.
+type avatarBatcher struct {
+	window time.Duration
+	logger *slog.Logger
+	// fetch loads the avatar URLs of userIDs in size, keyed by user ID.
+	fetch func(ctx context.Context, size string, userIDs []string) (map[int64]string, error)
+
+	mu sync.Mutex
+	// pending is the batch still collecting user IDs for each size.
+	pending map[string]*avatarBatch
+}
+
+type avatarBatch struct {
+	// ctx is the context of the first caller without its cancellation, so
+	// the fetch keeps that request's ID and trace but outlives it.
+	ctx     context.Context
+	size    string
+	userIDs []string
+	// links point at the spans of the other callers that joined the batch.
+	links []trace.Link
+	// waiters holds the URL channels of every caller, by user ID.
+	waiters map[string][]chan string
+	timer   *time.Timer
+}
+
+func newAvatarBatcher(window time.Duration, logger *slog.Logger, fetch func(ctx context.Context, size string, userIDs []string) (map[int64]string, error)) *avatarBatcher {
+	return &avatarBatcher{window: window, logger: logger, fetch: fetch, pending: make(map[string]*avatarBatch)}
+}
+
+// lookup returns the avatar URL of userID, a numeric user ID, in size once the
+// batch it joins has been fetched. A user Roblox returns no thumbnail for, and
+// every user of a failed batch, maps to "". The only error is ctx's.
+func (b *avatarBatcher) lookup(ctx context.Context, userID, size string) (string, error) {
+	ch := make(chan string, 1)
+
+	b.mu.Lock()
+	batch := b.pending[size]
+	if batch == nil {
+		batch = &avatarBatch{ctx: context.WithoutCancel(ctx), size: size, waiters: make(map[string][]chan string)}
+		b.pending[size] = batch
+		batch.timer = time.AfterFunc(b.window, func() { b.expire(batch) })
+	} else if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		batch.links = append(batch.links, trace.Link{SpanContext: sc})
+	}
+	if _, ok := batch.waiters[userID]; !ok {
+		batch.userIDs = append(batch.userIDs, userID)
+	}
+	batch.waiters[userID] = append(batch.waiters[userID], ch)
+	full := len(batch.userIDs) >= maxAvatarBatch
+	if full {
+		// Later lookups start a new batch rather than overfilling this one.
+		delete(b.pending, size)
+		batch.timer.Stop()
+	}
+	b.mu.Unlock()
+
+	if full {
+		go b.run(batch)
+	}
+
+	select {
+	case avatarURL := <-ch:
+		return avatarURL, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// expire sends batch once its window has passed, unless it already filled up.
+func (b *avatarBatcher) expire(batch *avatarBatch) {
+	b.mu.Lock()
+	if b.pending[batch.size] != batch {
+		b.mu.Unlock()
+		return
+	}
+	delete(b.pending, batch.size)
+	b.mu.Unlock()
+
+	b.run(batch)
+}
+
+// run fetches batch and hands each waiter its URL. A failed batch degrades
+// every entry in it to an empty URL, logged once for the whole batch.
+func (b *avatarBatcher) run(batch *avatarBatch) {
+	ctx, span := tracing.Tracer().Start(batch.ctx, "avatar.batch",
+		trace.WithLinks(batch.links...),
+		trace.WithAttributes(attribute.String("size", batch.size), attribute.Int("users", len(batch.userIDs))))
+	urls, err := b.fetch(ctx, batch.size, batch.userIDs)
+	tracing.EndSpan(span, err)
+	if err != nil {
+		b.logger.WarnContext(ctx, "avatar batch failed, returning empty avatar URLs", slog.String("size", batch.size), slog.Int("users", len(batch.userIDs)), slog.String("error", err.Error()))
+	}
+	for userID, waiters := range batch.waiters {
+		id, _ := strconv.ParseInt(userID, 10, 64)
+		for _, ch := range waiters {
+			ch <- urls[id]
+		}
+	}
+}
+
+// fetchAvatarBatch fetches the avatar-bust URLs of userIDs in size with a
+// single call, keyed by user ID.
+func (h *Handler) fetchAvatarBatch(ctx context.Context, size string, userIDs []string) (map[int64]string, error) {
+	params := url.Values{
+		"userIds":    {strings.Join(userIDs, ",")},
+		"size":       {size},
+		"format":     {"Png"},
+		"isCircular": {"false"},
+	}
+
+	var avatarResp struct {
+		Data []struct {
+			TargetID int64  `json:"targetId"`
+			ImageURL string `json:"imageUrl"`
+		} `json:"data"`
+	}
+	if err := h.fetchJSON(ctx, thumbnailsService, "/v1/users/avatar-bust", params, &avatarResp); err != nil {
+		return nil, err
+	}
+
+	urls := make(map[int64]string, len(avatarResp.Data))
+	for _, entry := range avatarResp.Data {
+		urls[entry.TargetID] = entry.ImageURL
+	}
+	return urls, nil
+}
@@ -0,0 +1,191 @@
+package member
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
+)
+
+func TestBatchedAvatarFetchesKeepConditionalRefreshes(t *testing.T) {
+	var mu sync.Mutex
+	var conditional []string
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		if etag := r.Header.Get("If-None-Match"); etag != "" {
+			mu.Lock()
+			conditional = append(conditional, r.URL.Query().Get("userIds"))
+			mu.Unlock()
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		robloxAPI(w, r)
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{"PROXY_AVATAR_BATCH_WINDOW": "20ms"})
+	ctx := context.Background()
+
+	prior := &cache.Entry{Payload: []byte(`{"url":"https://tr.rbxcdn.com/old.png"}`), ETag: `"v1"`}
+	entry, err := h.avatarFetcher("1", defaultAvatarSize)(ctx, prior)
+	if err != nil {
+		t.Fatalf("conditional refresh: %v", err)
+	}
+	if string(entry.Payload) != string(prior.Payload) || entry.ETag != prior.ETag {
+		t.Fatalf("conditional refresh returned %+v, want the prior entry kept", entry)
+	}
+	mu.Lock()
+	if len(conditional) != 1 || conditional[0] != "1" {
+		t.Fatalf("conditional requests = %q, want one for user 1 alone", conditional)
+	}
+	mu.Unlock()
+
+	var wg sync.WaitGroup
+	entries := make([]cache.Entry, 2)
+	for i, userID := range []string{"2", "3"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if entries[i], err = h.avatarFetcher(userID, defaultAvatarSize)(ctx, nil); err != nil {
+				t.Errorf("batched fetch of %s: %v", userID, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := upstream.count(fakeAvatarPath); n != 2 {
+		t.Fatalf("avatar-bust calls = %d, want 2: one conditional, one batch", n)
+	}
+	for i, userID := range []string{"2", "3"} {
+		if want := `{"url":"https://tr.rbxcdn.com/` + userID + `.png"}`; string(entries[i].Payload) != want {
+			t.Errorf("user %s payload = %s, want %s", userID, entries[i].Payload, want)
+		}
+	}
+}
+
+func TestFailedAvatarBatchDegradesToEmptyURLs(t *testing.T) {
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == fakeAvatarPath {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		robloxAPI(w, r)
+	})
+	logs := newLogRecorder()
+	// Strict mode would fail a user lookup whose avatar fetch errors.
+	h, _ := newTestHandlerWithLogger(t, upstream.URL, map[string]string{
+		"PROXY_AVATAR_BATCH_WINDOW": "20ms",
+		"PROXY_USER_AVATAR_FAILURE": "strict",
+	}, slog.New(logs))
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, 2)
+	for i, userID := range []string{"1", "2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recs[i] = get(h, "/?userId="+userID)
+		}()
+	}
+	wg.Wait()
+
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("lookup %d: status = %d, want 200: %s", i, rec.Code, body(t, rec))
+		}
+		var payload userPayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("lookup %d: decode: %v", i, err)
+		}
+		if payload.AvatarURL != "" || payload.Name == "" {
+			t.Fatalf("lookup %d: payload = %+v, want the user with an empty avatar URL", i, payload)
+		}
+	}
+	if n := upstream.count(fakeAvatarPath); n != 1 {
+		t.Fatalf("avatar-bust calls = %d, want one batch", n)
+	}
+	if n := len(logs.find("avatar batch failed, returning empty avatar URLs")); n != 1 {
+		t.Fatalf("batch failure logged %d times, want once", n)
+	}
+}
+
+func TestAvatarBatchKeepsCallerRequestAndTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()), sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	var mu sync.Mutex
+	var requestIDs []string
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == fakeAvatarPath {
+			mu.Lock()
+			requestIDs = append(requestIDs, r.Header.Get(reqmeta.HeaderRequestID))
+			mu.Unlock()
+		}
+		robloxAPI(w, r)
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{"PROXY_AVATAR_BATCH_WINDOW": "50ms"})
+	tracer := provider.Tracer("test")
+
+	// The first caller gives up before the batch is sent; the batch still
+	// runs under its request ID and trace.
+	firstCtx, info := reqmeta.NewContext(context.Background())
+	info.SetRequestID("first-request")
+	firstCtx, firstSpan := tracer.Start(firstCtx, "first")
+	firstCtx, cancelFirst := context.WithCancel(firstCtx)
+	secondCtx, secondSpan := tracer.Start(context.Background(), "second")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.avatarBatch.lookup(firstCtx, "1", defaultAvatarSize)
+		done <- err
+	}()
+	waitFor(t, "the first caller to open a batch", func() bool {
+		h.avatarBatch.mu.Lock()
+		defer h.avatarBatch.mu.Unlock()
+		return h.avatarBatch.pending[defaultAvatarSize] != nil
+	})
+	cancelFirst()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first lookup error = %v, want context.Canceled", err)
+	}
+	avatarURL, err := h.avatarBatch.lookup(secondCtx, "2", defaultAvatarSize)
+	if err != nil || avatarURL != "https://tr.rbxcdn.com/2.png" {
+		t.Fatalf("second lookup = %q, %v", avatarURL, err)
+	}
+	firstSpan.End()
+	secondSpan.End()
+
+	mu.Lock()
+	if len(requestIDs) != 1 || requestIDs[0] != "first-request" {
+		t.Fatalf("avatar-bust request IDs = %q, want the first caller's", requestIDs)
+	}
+	mu.Unlock()
+
+	var batch sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "avatar.batch" {
+			batch = span
+		}
+	}
+	if batch == nil {
+		t.Fatal("no avatar.batch span was recorded")
+	}
+	if batch.Parent().SpanID() != firstSpan.SpanContext().SpanID() {
+		t.Fatalf("batch parent = %s, want the first caller's span", batch.Parent().SpanID())
+	}
+	if links := batch.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != secondSpan.SpanContext().SpanID() {
+		t.Fatalf("batch links = %v, want the second caller's span", links)
+	}
+}
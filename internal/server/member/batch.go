@@ -0,0 +1,213 @@
+package member
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+)
+
+const (
+	batchPath = "/batch"
+	// maxBatchBodyBytes caps the size of a batch request body.
+	maxBatchBodyBytes = 1 << 20
+)
+
+// batchOp is a single operation in a batch request.
+type batchOp struct {
+	Op     string `json:"op"`
+	UserID string `json:"userId"`
+	// Query, Limit and EmbedAvatars apply to "search".
+	Query        string `json:"query"`
+	Limit        *int   `json:"limit"`
+	EmbedAvatars *bool  `json:"embedAvatars"`
+	// Size applies to "avatar".
+	Size string `json:"size"`
+}
+
+// batchKey marks the context of a batch operation, whose cache-miss fetches
+// are bounded by BatchFetchConcurrency.
+type batchKey struct{}
+
+func inBatch(ctx context.Context) bool {
+	batch, _ := ctx.Value(batchKey{}).(bool)
+	return batch
+}
+
+// batchResult is the outcome of one operation. Result carries the payload the
+// equivalent single request would have returned; failures set Code and Error
+// as in the error envelope.
+type batchResult struct {
+	Status int             `json:"status"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Stale  bool            `json:"stale,omitempty"`
+	Code   apierror.Code   `json:"code,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// handleBatch serves POST /batch: a JSON array of user, search and avatar
+// operations answered by an array of results in the same order. Operations run
+// concurrently through the same caches as the single-item endpoints, and one
+// failing does not fail the others. The response carries a batch ETag, and a
+// request whose If-None-Match matches it is answered 304 without a body.
+func (h *Handler) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "batch requests must use POST")
+		return
+	}
+
+	var ops []batchOp
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes)).Decode(&ops); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.CodePayloadTooLarge, "batch body too large")
+			return
+		}
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeBadRequest, "batch body must be a JSON array of operations")
+		return
+	}
+	if maxOps := h.config().BatchMaxOperations; len(ops) > maxOps {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, fmt.Sprintf("batch exceeds %d operations", maxOps))
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), batchKey{}, true)
+	results := make([]batchResult, len(ops))
+	var g errgroup.Group
+	g.SetLimit(h.config().BatchConcurrency)
+	for i, op := range ops {
+		g.Go(func() error {
+			results[i] = h.runBatchOp(ctx, op)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	etag, err := batchETag(results)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set(headerAccessControlAllowOrigin, corsAllowOrigin)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.respondJSON(w, http.StatusOK, body)
+}
+
+// batchETag returns a weak ETag over results that ignores their order, so
+// polling the same operations in any order yields the same tag. It is weak
+// because equal sets in a different order are not byte-identical bodies.
+func batchETag(results []batchResult) (string, error) {
+	entries := make([][]byte, len(results))
+	for i, res := range results {
+		entry, err := json.Marshal(res)
+		if err != nil {
+			return "", err
+		}
+		entries[i] = entry
+	}
+	slices.SortFunc(entries, bytes.Compare)
+
+	sum := sha256.Sum256(bytes.Join(entries, []byte{'\n'}))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match value matches etag, comparing
+// weakly as RFC 9110 requires for If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for candidate := range strings.SplitSeq(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// runBatchOp validates and performs a single batch operation.
+func (h *Handler) runBatchOp(ctx context.Context, op batchOp) batchResult {
+	switch strings.ToLower(op.Op) {
+	case "user":
+		userID := strings.TrimSpace(op.UserID)
+		if !isNumeric(userID) {
+			return batchInvalid(apierror.CodeInvalidUserID, "Invalid or missing userId")
+		}
+		ctx, cancel := context.WithTimeout(ctx, h.lookupTimeout(usersService, thumbnailsService))
+		defer cancel()
+		return batchLookup(h.lookupUser(ctx, userID, nil))
+
+	case "search":
+		needle := normalizeSearch(op.Query)
+		if utf8.RuneCountInString(needle) < 3 {
+			return batchResult{Status: http.StatusOK, Result: json.RawMessage(`[]`)}
+		}
+		var rawLimit, rawEmbed string
+		if op.Limit != nil {
+			rawLimit = strconv.Itoa(*op.Limit)
+		}
+		if op.EmbedAvatars != nil {
+			rawEmbed = strconv.FormatBool(*op.EmbedAvatars)
+		}
+		limit, err := h.searchLimit(rawLimit)
+		if err != nil {
+			return batchInvalid(apierror.CodeInvalidParameter, "Invalid limit")
+		}
+		embedAvatars, _ := h.searchEmbedAvatars(rawEmbed)
+		ctx, cancel := context.WithTimeout(ctx, h.lookupTimeout(searchService, thumbnailsService))
+		defer cancel()
+		return batchLookup(h.searchUsers(ctx, needle, limit, embedAvatars))
+
+	case "avatar":
+		userID := strings.TrimSpace(op.UserID)
+		if !isNumeric(userID) {
+			return batchInvalid(apierror.CodeInvalidUserID, "Invalid or missing userId")
+		}
+		size := strings.TrimSpace(op.Size)
+		if size == "" {
+			size = defaultAvatarSize
+		}
+		if _, ok := avatarSizes[size]; !ok {
+			return batchInvalid(apierror.CodeInvalidParameter, "Invalid avatar size")
+		}
+		ctx, cancel := context.WithTimeout(ctx, h.lookupTimeout(thumbnailsService))
+		defer cancel()
+		return batchLookup(h.lookupAvatar(ctx, userID, size))
+
+	default:
+		return batchInvalid(apierror.CodeInvalidParameter, fmt.Sprintf("unknown op %q: want user, search or avatar", op.Op))
+	}
+}
+
+func batchInvalid(code apierror.Code, message string) batchResult {
+	return batchResult{Status: http.StatusBadRequest, Code: code, Error: message}
+}
+
+func batchLookup(result cachedPayload, err error) batchResult {
+	if err != nil {
+		status := lookupErrorStatus(err)
+		return batchResult{Status: status, Code: lookupErrorCode(status, err), Error: err.Error()}
+	}
+	return batchResult{Status: http.StatusOK, Result: result.payload, Stale: result.stale}
+}
@@ -0,0 +1,154 @@
+package member
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// postBatch serves a POST /batch of ops through h and decodes its results.
+func postBatch(t *testing.T, h http.Handler, ops string) []batchResult {
+	t.Helper()
+	rec := serve(h, httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(ops)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("batch status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	return postBatchBody(t, rec)
+}
+
+func TestBatchFetchesRespectConcurrencyBound(t *testing.T) {
+	const bound = 2
+	var inFlight, peak atomic.Int64
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		if r.URL.Path == fakeUserPath+"404" {
+			http.NotFound(w, r)
+			return
+		}
+		robloxAPI(w, r)
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_BATCH_CONCURRENCY":       "8",
+		"PROXY_BATCH_FETCH_CONCURRENCY": "2",
+	})
+
+	results := postBatch(t, h, `[
+		{"op":"user","userId":"1"},
+		{"op":"user","userId":"2"},
+		{"op":"user","userId":"not-a-number"},
+		{"op":"user","userId":"3"},
+		{"op":"user","userId":"404"},
+		{"op":"user","userId":"5"},
+		{"op":"user","userId":"6"},
+		{"op":"user","userId":"7"}
+	]`)
+
+	if p := peak.Load(); p > bound {
+		t.Fatalf("peak upstream calls in flight = %d, want at most %d", p, bound)
+	}
+	wantIDs := []int64{1, 2, 0, 3, 0, 5, 6, 7}
+	if len(results) != len(wantIDs) {
+		t.Fatalf("got %d results, want %d", len(results), len(wantIDs))
+	}
+	for i, id := range wantIDs {
+		res := results[i]
+		switch {
+		case i == 2:
+			if res.Status != http.StatusBadRequest {
+				t.Errorf("result %d: status = %d, want 400 for the invalid userId", i, res.Status)
+			}
+		case i == 4:
+			// A failed user fetch fails only its own entry, with the status
+			// the single lookup would answer.
+			if res.Status != http.StatusInternalServerError || res.Error == "" {
+				t.Errorf("result %d: status = %d error %q, want a 500 for the failed fetch", i, res.Status, res.Error)
+			}
+		default:
+			var payload userPayload
+			if err := json.Unmarshal(res.Result, &payload); err != nil || res.Status != http.StatusOK || payload.ID != id {
+				t.Errorf("result %d: status %d payload %s, want user %d", i, res.Status, res.Result, id)
+			}
+		}
+	}
+}
+
+func TestBatchETag(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandler(t, upstream.URL, nil)
+
+	post := func(ops, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, batchPath, strings.NewReader(ops))
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(h, req)
+	}
+	const (
+		forward  = `[{"op":"user","userId":"1"},{"op":"avatar","userId":"2"}]`
+		reversed = `[{"op":"avatar","userId":"2"},{"op":"user","userId":"1"}]`
+		other    = `[{"op":"user","userId":"3"}]`
+	)
+
+	first := post(forward, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("status = %d ETag = %q, want 200 with an ETag", first.Code, etag)
+	}
+	if got := post(reversed, "").Header().Get("ETag"); got != etag {
+		t.Fatalf("reordered batch ETag = %q, want %q", got, etag)
+	}
+	if got := post(other, "").Header().Get("ETag"); got == etag {
+		t.Fatalf("a different batch shares ETag %q", got)
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"matching", etag, http.StatusNotModified},
+		{"matching in a list", `"stale", ` + etag, http.StatusNotModified},
+		{"matching strongly", strings.TrimPrefix(etag, "W/"), http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"not matching", `W/"0123456789abcdef"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(reversed, tt.ifNoneMatch)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if got := rec.Header().Get("ETag"); got != etag {
+				t.Fatalf("ETag = %q, want %q", got, etag)
+			}
+			if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+				t.Fatalf("304 carried a body: %s", rec.Body)
+			}
+			if tt.want == http.StatusOK && len(postBatchBody(t, rec)) != 2 {
+				t.Fatalf("200 body = %s, want both results", rec.Body)
+			}
+		})
+	}
+}
+
+// postBatchBody decodes the results of a recorded batch response.
+func postBatchBody(t *testing.T, rec *httptest.ResponseRecorder) []batchResult {
+	t.Helper()
+	var results []batchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("decode batch results: %v", err)
+	}
+	return results
+}
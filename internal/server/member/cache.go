@@ -0,0 +1,510 @@
+package member
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/util"
+)
+
+// operation identifies the kind of cacheable lookup being served.
+type operation string
+
+const (
+	opUser   operation = "user"
+	opSearch operation = "search"
+	opAvatar operation = "avatar"
+	opImage  operation = "avatarimg"
+)
+
+// Cache outcomes reported in the per-request cache event.
+const (
+	outcomeHit     = "hit"
+	outcomeRefresh = "refresh"
+	outcomeMiss    = "miss"
+	outcomeStale   = "stale"
+	outcomeError   = "error"
+)
+
+// errFetchOverloaded is returned when a miss cannot be fetched because the
+// global limit on distinct concurrent fetches has been reached.
+var errFetchOverloaded = errors.New("too many concurrent upstream fetches")
+
+// cachedPayload is the outcome of a read-through cache lookup.
+type cachedPayload struct {
+	payload     []byte
+	contentType string
+	// stale is set when an expired entry was served because the upstream fetch failed.
+	stale bool
+	// outcome is how the lookup was served, one of the cache outcomes, and
+	// storedAt when the served entry was cached. storedAt is zero for a
+	// fresh fetch.
+	outcome  string
+	storedAt time.Time
+}
+
+// cacheEvent accumulates the details of a single read-through lookup so they
+// can be logged as one structured event.
+type cacheEvent struct {
+	op       operation
+	key      string
+	outcome  string
+	upstream time.Duration
+	size     int
+}
+
+// entryFetcher loads a fresh value for a cache key from upstream. prior is the
+// entry currently cached for the key, if any, which fetchers may use to make a
+// conditional request; returning prior unchanged re-stores it as fresh.
+type entryFetcher func(ctx context.Context, prior *cache.Entry) (cache.Entry, error)
+
+// readThroughCache serves a JSON payload from the cache, fetching and storing it
+// on a miss. The TTL is the default cache TTL, or a popularity-weighted one when
+// configured.
+func (h *Handler) readThroughCache(ctx context.Context, op operation, key string, fetch func(context.Context) ([]byte, error)) (cachedPayload, error) {
+	ttl := h.popularity.observe(key, h.config().CacheTTL)
+	return h.readThroughEntry(ctx, op, key, ttl, jsonFetcher(fetch))
+}
+
+// jsonFetcher adapts a JSON payload fetch into an entryFetcher.
+func jsonFetcher(fetch func(context.Context) ([]byte, error)) entryFetcher {
+	return func(ctx context.Context, _ *cache.Entry) (cache.Entry, error) {
+		payload, err := fetch(ctx)
+		if err != nil {
+			return cache.Entry{}, err
+		}
+		return cache.Entry{Payload: payload, ContentType: contentTypeJSON}, nil
+	}
+}
+
+// cacheBypassKey marks a context whose lookups skip the cache read.
+type cacheBypassKey struct{}
+
+// withCacheBypass makes read-through lookups under ctx fetch from upstream
+// even when a fresh entry is cached. The result is still stored, so later
+// requests are served the refreshed entry.
+func withCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey{}).(bool)
+	return bypass
+}
+
+// readCache reads key from the cache, reporting a miss when ctx bypasses the
+// cache read.
+func (h *Handler) readCache(ctx context.Context, key string) (cache.Entry, bool, error) {
+	if cacheBypassed(ctx) {
+		return cache.Entry{}, false, nil
+	}
+	return h.cache.Get(ctx, key)
+}
+
+// readThroughEntry serves an entry from the cache, fetching and storing it with
+// ttl on a miss or when ctx bypasses the cache read.
+func (h *Handler) readThroughEntry(ctx context.Context, op operation, key string, ttl time.Duration, fetch entryFetcher) (result cachedPayload, err error) {
+	ev := cacheEvent{op: op, key: key}
+	defer func() {
+		ev.size = len(result.payload)
+		result.outcome = ev.outcome
+		h.logCacheEvent(ctx, ev, err)
+		reqmeta.FromContext(ctx).SetCacheResult(ev.outcome)
+	}()
+
+	var expired *cache.Entry
+	// tooOld is set when expired is past MaxServableAge and must not be
+	// served even if the refetch fails.
+	var tooOld bool
+	if entry, ok, err := h.readCache(ctx, key); err != nil {
+		if !h.config().CacheFailOpen {
+			ev.outcome = outcomeError
+			return cachedPayload{}, err
+		}
+		// Fail open: an unavailable cache should cost latency, not requests.
+		// The breaker already logged when it opened.
+		if !errors.Is(err, cache.ErrCircuitOpen) {
+			h.logger.WarnContext(ctx, "cache read failed, fetching from upstream", slog.String("key", key), slog.String("error", err.Error()))
+		}
+	} else if ok {
+		age := time.Since(entry.StoredAt)
+		if maxAge := h.config().MaxServableAge; maxAge > 0 && age > maxAge {
+			// Background refreshes have been failing for too long for the
+			// entry to be trusted; refetch as if it were missing.
+			h.maxAgeMisses.Add(1)
+			tooOld = true
+		} else if !entry.Expired(time.Now()) {
+			ev.outcome = outcomeHit
+			if age > h.config().BackgroundRefreshAfter {
+				ev.outcome = outcomeRefresh
+				h.launchRefresh(ctx, op, key, ttl, fetch, &entry)
+			}
+			return cachedPayload{payload: entry.Payload, contentType: entry.ContentType, storedAt: entry.StoredAt}, nil
+		}
+		expired = &entry
+	}
+
+	start := time.Now()
+	var led bool
+	res, err, _ := h.flights.group(op, phaseFetch).Do(key, func() (any, error) {
+		led = true
+		if h.batchFetchSem != nil && inBatch(ctx) {
+			// Batch fetches queue rather than shed: the batch is still
+			// answered, only more slowly.
+			if err := h.batchFetchSem.Acquire(ctx, 1); err != nil {
+				return nil, err
+			}
+			defer h.batchFetchSem.Release(1)
+		}
+		if h.fetchSem != nil {
+			if !h.fetchSem.TryAcquire(1) {
+				return nil, errFetchOverloaded
+			}
+			defer h.fetchSem.Release(1)
+		}
+
+		entry, err := fetch(ctx, expired)
+		if err != nil {
+			return nil, err
+		}
+		if !h.cacheable(ctx, key, entry) {
+			return entry, nil
+		}
+		if err := h.storeWithTTL(key, entry, ttl); err != nil {
+			h.logger.WarnContext(ctx, "cache store failed", slog.String("key", key), slog.String("error", err.Error()))
+		}
+		return entry, nil
+	})
+	ev.upstream = time.Since(start)
+	h.flights.record(op, led)
+	if err != nil {
+		if errors.Is(err, errNotCacheable) {
+			ev.outcome = outcomeMiss
+			return cachedPayload{}, err
+		}
+		if tooOld {
+			ev.outcome = outcomeError
+			return cachedPayload{}, err
+		}
+		if expired != nil && (errors.Is(err, errFetchOverloaded) || errors.Is(err, errThumbnailsSaturated) || errors.Is(err, proxy.ErrUpstreamSaturated)) {
+			ev.outcome = outcomeStale
+			return cachedPayload{payload: expired.Payload, contentType: expired.ContentType, stale: true, storedAt: expired.StoredAt}, nil
+		}
+		if expired != nil && time.Since(expired.ExpiresAt) <= h.config().StaleIfErrorWindow {
+			ev.outcome = outcomeStale
+			h.logger.WarnContext(ctx, "serving stale entry after fetch error", slog.String("key", key), slog.String("error", err.Error()))
+			return cachedPayload{payload: expired.Payload, contentType: expired.ContentType, stale: true, storedAt: expired.StoredAt}, nil
+		}
+		ev.outcome = outcomeError
+		return cachedPayload{}, err
+	}
+
+	ev.outcome = outcomeMiss
+	entry := res.(cache.Entry)
+	return cachedPayload{payload: entry.Payload, contentType: entry.ContentType}, nil
+}
+
+func (h *Handler) logCacheEvent(ctx context.Context, ev cacheEvent, err error) {
+	attrs := []slog.Attr{
+		slog.String("category", string(ev.op)),
+		slog.String("key", ev.key),
+		slog.String("outcome", ev.outcome),
+		slog.Int("size", ev.size),
+	}
+	if ev.upstream > 0 {
+		attrs = append(attrs, slog.Duration("upstream_duration", ev.upstream))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	h.logger.LogAttrs(ctx, h.config().CacheLogLevel, "cache lookup", attrs...)
+}
+
+func (h *Handler) launchRefresh(ctx context.Context, op operation, key string, ttl time.Duration, fetch entryFetcher, prior *cache.Entry) {
+	if !h.allowRefresh(ctx, key) {
+		return
+	}
+	if !h.acquireRefreshSlot(ctx, key) {
+		return
+	}
+	h.runBackground(ctx, func(ctx context.Context) {
+		defer h.releaseRefreshSlot()
+		h.refresh(ctx, op, key, ttl, fetch, prior)
+	})
+}
+
+// launchPrefetch populates key in the background unless it is already cached
+// and fresh.
+func (h *Handler) launchPrefetch(ctx context.Context, op operation, key string, ttl time.Duration, fetch entryFetcher) {
+	if !h.allowRefresh(ctx, key) {
+		return
+	}
+	if !h.acquireRefreshSlot(ctx, key) {
+		return
+	}
+	h.runBackground(ctx, func(ctx context.Context) {
+		defer h.releaseRefreshSlot()
+		var prior *cache.Entry
+		if entry, ok, err := h.cache.Get(ctx, key); err == nil && ok {
+			if !entry.Expired(time.Now()) {
+				return
+			}
+			prior = &entry
+		}
+		h.refresh(ctx, op, key, ttl, fetch, prior)
+	})
+}
+
+// allowRefresh reports whether a background fetch may start under the global
+// refresh rate. Refreshes over the rate are dropped; the entry keeps being
+// served and a later hit will try again.
+func (h *Handler) allowRefresh(ctx context.Context, key string) bool {
+	if h.config().DisableBackgroundRefresh {
+		return false
+	}
+	if h.refreshBucket == nil {
+		return true
+	}
+	if ok, _ := h.refreshBucket.Allow(time.Now()); !ok {
+		h.logger.DebugContext(ctx, "background refresh dropped by rate limit", slog.String("key", key))
+		return false
+	}
+	return true
+}
+
+// acquireRefreshSlot reserves one of the MaxBackgroundRefreshes slots. When
+// all are taken the refresh is dropped and the cached entry keeps being served.
+func (h *Handler) acquireRefreshSlot(ctx context.Context, key string) bool {
+	if h.refreshSem != nil && !h.refreshSem.TryAcquire(1) {
+		h.logger.DebugContext(ctx, "background refresh dropped, too many in flight", slog.String("key", key))
+		return false
+	}
+	h.refreshesInFlight.Add(1)
+	return true
+}
+
+func (h *Handler) releaseRefreshSlot() {
+	h.refreshesInFlight.Add(-1)
+	if h.refreshSem != nil {
+		h.refreshSem.Release(1)
+	}
+}
+
+// RefreshesInFlight reports the number of background refreshes running.
+func (h *Handler) RefreshesInFlight() int64 {
+	return h.refreshesInFlight.Load()
+}
+
+// runBackground runs fn on its own goroutine with a context detached from the
+// triggering request, bounded by the longest upstream timeout and cancelled by
+// Shutdown. The request ID of parent is carried over so background logs stay
+// correlated.
+func (h *Handler) runBackground(parent context.Context, fn func(ctx context.Context)) {
+	requestID := reqmeta.FromContext(parent).RequestID()
+
+	h.backgroundMu.Lock()
+	tracked := !h.backgroundDone
+	if tracked {
+		h.backgroundWG.Add(1)
+	}
+	h.backgroundMu.Unlock()
+
+	go func() {
+		if tracked {
+			defer h.backgroundWG.Done()
+		}
+		// After Shutdown the context is already cancelled, so fn only
+		// releases what its caller reserved.
+		ctx, cancel := context.WithTimeout(h.background, h.lookupTimeout(usersService, searchService, thumbnailsService))
+		defer cancel()
+		ctx, info := reqmeta.NewContext(ctx)
+		info.SetRequestID(requestID)
+		fn(ctx)
+	}()
+}
+
+// Shutdown cancels background refreshes and prefetches and waits, until ctx
+// is done, for them to return.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.backgroundMu.Lock()
+	h.backgroundDone = true
+	h.backgroundMu.Unlock()
+	h.stopBackground()
+
+	done := make(chan struct{})
+	go func() {
+		h.backgroundWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("background refreshes still running: %w", ctx.Err())
+	}
+}
+
+func (h *Handler) refresh(ctx context.Context, op operation, key string, ttl time.Duration, fetch entryFetcher, prior *cache.Entry) {
+	_, err, _ := h.flights.group(op, phaseRefresh).Do(key, func() (any, error) {
+		entry, err := fetch(ctx, prior)
+		if err != nil {
+			return nil, err
+		}
+		if !h.cacheable(ctx, key, entry) {
+			return entry, nil
+		}
+		if err := h.storeWithTTL(key, entry, ttl); err != nil {
+			h.logger.WarnContext(ctx, "refresh cache store failed", slog.String("key", key), slog.String("error", err.Error()))
+		}
+		return entry, nil
+	})
+
+	if err != nil {
+		h.logger.DebugContext(ctx, "background refresh failed", slog.String("key", key), slog.String("error", err.Error()))
+	}
+}
+
+// cacheable reports whether entry may be stored. JSON entries must hold valid
+// JSON: Roblox sometimes answers 200 with an HTML maintenance page, which is
+// still returned to the caller but never cached.
+func (h *Handler) cacheable(ctx context.Context, key string, entry cache.Entry) bool {
+	if entry.ContentType != "" && entry.ContentType != contentTypeJSON {
+		return true
+	}
+	if json.Valid(entry.Payload) {
+		return true
+	}
+	h.logger.WarnContext(ctx, "upstream returned non-JSON payload, skipping cache",
+		slog.String("key", key),
+		slog.String("upstream", reqmeta.FromContext(ctx).UpstreamHost()),
+		slog.Int("size", len(entry.Payload)),
+	)
+	return false
+}
+
+func (h *Handler) storeWithTTL(key string, entry cache.Entry, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return h.cache.SetEntry(ctx, key, entry, h.jitterTTL(key, ttl))
+}
+
+// jitterTTL spreads ttl by up to ±CacheTTLJitter so entries written in a burst
+// do not all expire together. In hash mode the offset is derived from the key,
+// so a key always gets the same TTL; otherwise it is random per store.
+func (h *Handler) jitterTTL(key string, ttl time.Duration) time.Duration {
+	if h.config().CacheTTLJitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+
+	var unit float64 // in [-1, 1]
+	if h.config().CacheTTLJitterMode == config.JitterModeHash {
+		unit = float64(util.Hash(key))/math.MaxUint32*2 - 1
+	} else {
+		unit = rand.Float64()*2 - 1
+	}
+	return time.Duration(float64(ttl) * (1 + h.config().CacheTTLJitter*unit))
+}
+
+var errInvalidUserID = errors.New("user id must be numeric")
+
+// InvalidationKeys maps a logical cache entry onto the keys that hold it.
+// kind is "user" or "avatar" with a numeric user ID, or "search" with a query.
+// User invalidation covers the payload without avatarUrls; entries for
+// explicit size sets are left to expire.
+// Avatar invalidation covers every size, both URL and image entries; search
+// invalidation covers the unlimited and default-limit result sets, with and
+// without embedded avatars.
+func (h *Handler) InvalidationKeys(kind, id string) ([]string, error) {
+	switch kind {
+	case "user":
+		if !isNumeric(id) {
+			return nil, errInvalidUserID
+		}
+		return []string{h.userCacheKey(id)}, nil
+	case "avatar":
+		if !isNumeric(id) {
+			return nil, errInvalidUserID
+		}
+		keys := make([]string, 0, 2*len(avatarSizes))
+		for size := range avatarSizes {
+			keys = append(keys, h.avatarCacheKey(id, size), h.avatarImageCacheKey(id, size))
+		}
+		return keys, nil
+	case "search":
+		needle := normalizeSearch(id)
+		if needle == "" {
+			return nil, errors.New("search query is required")
+		}
+		var keys []string
+		for _, embed := range []bool{true, false} {
+			keys = append(keys, h.searchResultKey(needle, 0, embed))
+			if limit := h.config().SearchMaxResults; limit > 0 {
+				keys = append(keys, h.searchResultKey(needle, limit, embed))
+			}
+		}
+		return keys, nil
+	default:
+		return nil, fmt.Errorf("unknown cache entry kind %q", kind)
+	}
+}
+
+// cacheKey builds the key for an entry of kind, prefixed with CacheKeyPrefix
+// so deployments sharing a store keep separate key spaces. Every key the
+// handler stores must be built here.
+func (h *Handler) cacheKey(kind, id string) string {
+	return h.config().CacheKeyPrefix + kind + ":" + id
+}
+
+func (h *Handler) userCacheKey(userID string) string {
+	return h.cacheKey("user", userID)
+}
+
+// userSizesCacheKey extends the user key with the requested avatar sizes, so
+// each size set has its own entry.
+func (h *Handler) userSizesCacheKey(userID string, sizes []string) string {
+	key := h.userCacheKey(userID)
+	if len(sizes) > 0 {
+		key += "|sizes=" + strings.Join(sizes, ",")
+	}
+	return key
+}
+
+func (h *Handler) searchCacheKey(query string) string {
+	return h.cacheKey("search", query)
+}
+
+// searchResultKey extends the search key with the options that change the
+// response, so differently shaped results never share an entry.
+func (h *Handler) searchResultKey(query string, limit int, embedAvatars bool) string {
+	key := h.searchCacheKey(query)
+	if limit > 0 {
+		key += "|limit=" + strconv.Itoa(limit)
+	}
+	if !embedAvatars {
+		key += "|avatars=false"
+	}
+	return key
+}
+
+func (h *Handler) avatarCacheKey(userID, size string) string {
+	if size == defaultAvatarSize {
+		return h.cacheKey("avatar", userID)
+	}
+	return h.cacheKey("avatar", userID+":"+size)
+}
+
+func (h *Handler) avatarImageCacheKey(userID, size string) string {
+	return h.cacheKey("avatarimg", userID+":"+size)
+}
@@ -0,0 +1,379 @@
+package member
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+)
+
+const cacheTestPayload = `{"id":1}`
+
+func fetchPayload(context.Context, *cache.Entry) (cache.Entry, error) {
+	return cache.Entry{Payload: []byte(cacheTestPayload), ContentType: contentTypeJSON}, nil
+}
+
+func fetchFailure(context.Context, *cache.Entry) (cache.Entry, error) {
+	return cache.Entry{}, errors.New("upstream unavailable")
+}
+
+// lookupEvent runs one read-through lookup of key and returns the cache event
+// it logged.
+func lookupEvent(t *testing.T, h *Handler, logs *logRecorder, ttl time.Duration, fetch entryFetcher) loggedRecord {
+	t.Helper()
+	before := len(logs.find("cache lookup"))
+	_, _ = h.readThroughEntry(context.Background(), opUser, "roblox:user:1", ttl, fetch)
+	events := logs.find("cache lookup")
+	if len(events) != before+1 {
+		t.Fatalf("lookup logged %d cache events, want 1", len(events)-before)
+	}
+	return events[len(events)-1]
+}
+
+// checkEvent asserts the fields of a logged cache event. The upstream
+// duration is only logged when the lookup went upstream, and the error only
+// when it failed.
+func checkEvent(t *testing.T, ev loggedRecord, outcome string, size int, upstream, failed bool) {
+	t.Helper()
+	want := map[string]string{"category": string(opUser), "key": "roblox:user:1", "outcome": outcome}
+	for field, value := range want {
+		if got := ev.attrs[field].String(); got != value {
+			t.Errorf("%s = %q, want %q", field, got, value)
+		}
+	}
+	if got := ev.attrs["size"].Int64(); got != int64(size) {
+		t.Errorf("size = %d, want %d", got, size)
+	}
+	if _, ok := ev.attrs["upstream_duration"]; ok != upstream {
+		t.Errorf("upstream_duration logged = %v, want %v", ok, upstream)
+	}
+	if _, ok := ev.attrs["error"]; ok != failed {
+		t.Errorf("error logged = %v, want %v", ok, failed)
+	}
+}
+
+func newLoggedHandler(t *testing.T, env map[string]string) (*Handler, *logRecorder) {
+	t.Helper()
+	logs := newLogRecorder()
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandlerWithLogger(t, upstream.URL, env, slog.New(logs))
+	return h, logs
+}
+
+func TestCacheEventFields(t *testing.T) {
+	t.Run("miss then hit", func(t *testing.T) {
+		h, logs := newLoggedHandler(t, nil)
+		checkEvent(t, lookupEvent(t, h, logs, time.Minute, fetchPayload), outcomeMiss, len(cacheTestPayload), true, false)
+		checkEvent(t, lookupEvent(t, h, logs, time.Minute, fetchFailure), outcomeHit, len(cacheTestPayload), false, false)
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		h, logs := newLoggedHandler(t, map[string]string{"PROXY_BACKGROUND_REFRESH_AFTER": "1ms"})
+		lookupEvent(t, h, logs, time.Minute, fetchPayload)
+		time.Sleep(5 * time.Millisecond)
+		checkEvent(t, lookupEvent(t, h, logs, time.Minute, fetchPayload), outcomeRefresh, len(cacheTestPayload), false, false)
+	})
+
+	t.Run("stale", func(t *testing.T) {
+		h, logs := newLoggedHandler(t, map[string]string{"PROXY_STALE_IF_ERROR_WINDOW": "1m"})
+		lookupEvent(t, h, logs, time.Millisecond, fetchPayload)
+		time.Sleep(5 * time.Millisecond)
+		checkEvent(t, lookupEvent(t, h, logs, time.Minute, fetchFailure), outcomeStale, len(cacheTestPayload), true, false)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		h, logs := newLoggedHandler(t, nil)
+		checkEvent(t, lookupEvent(t, h, logs, time.Minute, fetchFailure), outcomeError, 0, true, true)
+	})
+}
+
+// avatarSizeCounter answers like robloxAPI while counting avatar-bust
+// requests by size.
+type avatarSizeCounter struct {
+	mu    sync.Mutex
+	sizes map[string]int
+}
+
+func (c *avatarSizeCounter) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == fakeAvatarPath {
+		c.mu.Lock()
+		c.sizes[r.URL.Query().Get("size")]++
+		c.mu.Unlock()
+	}
+	robloxAPI(w, r)
+}
+
+func (c *avatarSizeCounter) count(size string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sizes[size]
+}
+
+func TestUserLookupPrefetchesDefaultAvatar(t *testing.T) {
+	avatars := &avatarSizeCounter{sizes: make(map[string]int)}
+	upstream := newFakeRoblox(t, avatars.serve)
+	h, store := newTestHandler(t, upstream.URL, map[string]string{"PROXY_PREFETCH_AVATARS": "true"})
+
+	if rec := get(h, "/?userId=1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	key := h.avatarCacheKey("1", defaultAvatarSize)
+	var entry cache.Entry
+	waitFor(t, "the prefetched avatar to be cached", func() bool {
+		var ok bool
+		entry, ok, _ = store.Get(context.Background(), key)
+		return ok
+	})
+	if !strings.Contains(string(entry.Payload), "https://tr.rbxcdn.com/1.png") {
+		t.Fatalf("cached avatar = %s, want the user's image URL", entry.Payload)
+	}
+
+	// A fresh avatar is not fetched again by later lookups.
+	if rec := get(h, "/?userId=1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	_ = h.Shutdown(context.Background())
+	if n := avatars.count(defaultAvatarSize); n != 1 {
+		t.Fatalf("%s avatar fetches = %d, want 1", defaultAvatarSize, n)
+	}
+}
+
+func TestUserLookupSkipsAvatarPrefetchByDefault(t *testing.T) {
+	avatars := &avatarSizeCounter{sizes: make(map[string]int)}
+	upstream := newFakeRoblox(t, avatars.serve)
+	h, _ := newTestHandler(t, upstream.URL, nil)
+
+	if rec := get(h, "/?userId=1"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	_ = h.Shutdown(context.Background())
+	if n := avatars.count(defaultAvatarSize); n != 0 {
+		t.Fatalf("%s avatar fetches = %d, want 0", defaultAvatarSize, n)
+	}
+}
+
+func TestFetchLimitShedsExcessMisses(t *testing.T) {
+	const limit = 2
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, store := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_MAX_CONCURRENT_FETCHES": strconv.Itoa(limit),
+		"PROXY_STALE_IF_ERROR_WINDOW":  "1m",
+	})
+	ctx := context.Background()
+
+	var inFlight, peak atomic.Int64
+	release := make(chan struct{})
+	blocking := func(context.Context, *cache.Entry) (cache.Entry, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		return fetchPayload(ctx, nil)
+	}
+
+	// Fill every fetch slot with distinct misses held upstream.
+	var wg sync.WaitGroup
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := h.readThroughEntry(ctx, opUser, "held:"+strconv.Itoa(i), time.Minute, blocking); err != nil {
+				t.Errorf("held fetch %d: %v", i, err)
+			}
+		}()
+	}
+	waitFor(t, "the fetch slots to fill", func() bool { return inFlight.Load() == limit })
+
+	// Further distinct misses are shed rather than queued.
+	for i := 0; i < 8; i++ {
+		_, err := h.readThroughEntry(ctx, opUser, "shed:"+strconv.Itoa(i), time.Minute, blocking)
+		if !errors.Is(err, errFetchOverloaded) {
+			t.Fatalf("miss %d error = %v, want errFetchOverloaded", i, err)
+		}
+	}
+
+	// A miss with an expired entry serves it stale instead.
+	if err := store.SetEntry(ctx, "expired", cache.Entry{Payload: []byte(cacheTestPayload), ContentType: contentTypeJSON}, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	result, err := h.readThroughEntry(ctx, opUser, "expired", time.Minute, blocking)
+	if err != nil || !result.stale || string(result.payload) != cacheTestPayload {
+		t.Fatalf("expired lookup = %+v, %v; want the stale entry", result, err)
+	}
+
+	// Clients are told to retry later.
+	rec := get(h, "/?userId=7")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("user lookup status = %d, want 503", rec.Code)
+	}
+	if n := upstream.count(fakeUserPath + "7"); n != 0 {
+		t.Fatalf("shed lookup reached the upstream %d times, want 0", n)
+	}
+
+	close(release)
+	wg.Wait()
+	if p := peak.Load(); p != limit {
+		t.Fatalf("peak concurrent fetches = %d, want %d", p, limit)
+	}
+}
+
+func TestRawFetchIsCachedAndServedByteForByte(t *testing.T) {
+	// Formatting, key order, escapes and integers beyond float64 precision
+	// would all change in a round trip through a Go struct or map.
+	const raw = "{ \"z\": 1,\n  \"id\": 9007199254740993, \"name\": \"caf\\u00e9\", \"a\": [ ] }"
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		_, _ = io.WriteString(w, raw)
+	})
+	h, store := newTestHandler(t, upstream.URL, nil)
+	ctx := context.Background()
+	params := url.Values{"universeIds": {"1"}}
+
+	for i := 0; i < 2; i++ {
+		result, err := h.readThroughCache(ctx, opProxy, "raw", h.rawFetcher("games", "/v1/games", params))
+		if err != nil {
+			t.Fatalf("lookup %d: %v", i, err)
+		}
+		rec := httptest.NewRecorder()
+		h.respondCachedJSON(rec, result)
+		if got := rec.Body.String(); got != raw {
+			t.Fatalf("lookup %d served %q, want %q", i, got, raw)
+		}
+	}
+	if n := upstream.total(); n != 1 {
+		t.Fatalf("upstream saw %d requests, want 1", n)
+	}
+	entry, ok, err := store.Get(ctx, "raw")
+	if err != nil || !ok || string(entry.Payload) != raw {
+		t.Fatalf("cached entry = %q (ok=%v err=%v), want %q", entry.Payload, ok, err, raw)
+	}
+}
+
+func TestRawFetchRejectsInvalidJSON(t *testing.T) {
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "<html>maintenance</html>")
+	})
+	h, store := newTestHandler(t, upstream.URL, nil)
+	ctx := context.Background()
+
+	_, err := h.readThroughCache(ctx, opProxy, "raw", h.rawFetcher("games", "/v1/games", nil))
+	if !errors.Is(err, errInvalidUpstreamJSON) {
+		t.Fatalf("lookup error = %v, want errInvalidUpstreamJSON", err)
+	}
+	if _, ok, _ := store.Get(ctx, "raw"); ok {
+		t.Fatal("invalid JSON was cached")
+	}
+}
+
+func TestRefreshRateHoldsAcrossKeys(t *testing.T) {
+	const (
+		keys  = 20
+		burst = 3
+	)
+	upstream := newFakeRoblox(t, robloxAPI)
+	// The bucket refills too slowly to matter, so only the burst is spent.
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_BACKGROUND_REFRESH_AFTER": "1ms",
+		"PROXY_REFRESH_RATE_LIMIT":       "0.001",
+		"PROXY_REFRESH_BURST":            strconv.Itoa(burst),
+	})
+	ctx := context.Background()
+
+	var fetches atomic.Int64
+	fetch := func(ctx context.Context, prior *cache.Entry) (cache.Entry, error) {
+		fetches.Add(1)
+		return fetchPayload(ctx, prior)
+	}
+	for i := 0; i < keys; i++ {
+		if _, err := h.readThroughEntry(ctx, opUser, "key:"+strconv.Itoa(i), time.Minute, fetch); err != nil {
+			t.Fatalf("seed %d: %v", i, err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// Every key is now due a refresh, but together they share one bucket.
+	refreshes := 0
+	for i := 0; i < keys; i++ {
+		result, err := h.readThroughEntry(ctx, opUser, "key:"+strconv.Itoa(i), time.Minute, fetch)
+		if err != nil {
+			t.Fatalf("lookup %d: %v", i, err)
+		}
+		if result.outcome == outcomeRefresh {
+			refreshes++
+		}
+	}
+	if err := h.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if refreshes != keys {
+		t.Fatalf("%d lookups were due a refresh, want %d", refreshes, keys)
+	}
+	if n := fetches.Load() - keys; n != burst {
+		t.Fatalf("background refreshes = %d, want %d", n, burst)
+	}
+}
+
+func TestCacheKeysCarryConfiguredPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		env    map[string]string
+		prefix string
+	}{
+		{"default", nil, "roblox:"},
+		{"custom", map[string]string{"PROXY_CACHE_KEY_PREFIX": "tenant-a:"}, "tenant-a:"},
+		{"empty", map[string]string{"PROXY_CACHE_KEY_PREFIX": ""}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := newFakeRoblox(t, robloxAPI)
+			h, store := newTestHandler(t, upstream.URL, tc.env)
+
+			for _, key := range []string{
+				h.userCacheKey("1"),
+				h.searchCacheKey("bob"),
+				h.avatarCacheKey("1", defaultAvatarSize),
+				h.avatarImageCacheKey("1", "150x150"),
+				h.proxyCacheKey("/users/v1/users/1", ""),
+			} {
+				rest, ok := strings.CutPrefix(key, tc.prefix)
+				if !ok {
+					t.Fatalf("key %q lacks prefix %q", key, tc.prefix)
+				}
+				if kind, id, ok := strings.Cut(rest, ":"); !ok || kind == "" || id == "" {
+					t.Fatalf("key %q is not <prefix><kind>:<id>", key)
+				}
+			}
+
+			// The entry is stored under the prefixed key and served from it.
+			for range 2 {
+				if rec := get(h, "/?userId=1"); rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200", rec.Code)
+				}
+			}
+			if _, ok, _ := store.Get(context.Background(), tc.prefix+"user:1"); !ok {
+				t.Fatalf("no entry under %q", tc.prefix+"user:1")
+			}
+			if n := upstream.count(fakeUserPath + "1"); n != 1 {
+				t.Fatalf("user fetches = %d, want 1", n)
+			}
+		})
+	}
+}
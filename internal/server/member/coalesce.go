@@ -0,0 +1,215 @@
+package member
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+)
+
+// coalesceVaryHeaders are the request headers that can change an upstream
+// response. Requests only share a response when they agree on all of them.
+var coalesceVaryHeaders = []string{"Accept", "Accept-Encoding", "Accept-Language", "Authorization", "Cookie"}
+
+// coalescer merges identical concurrent generic proxy GETs into one upstream
+// request. Unlike singleflight, the leader streams the response to its own
+// client as it arrives and only buffers a copy for the waiters, and it can
+// release the waiters early when the response turns out not to be shareable.
+type coalescer struct {
+	mu     sync.Mutex
+	calls  map[string]*coalescedCall
+	shared *expvar.Int
+}
+
+// coalescedCall is an upstream request with waiters. resp is nil when the
+// leader could not capture the response, in which case every waiter forwards
+// its own request.
+type coalescedCall struct {
+	done chan struct{}
+	once sync.Once
+	resp *capturedResponse
+}
+
+type capturedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		calls:  make(map[string]*coalescedCall),
+		shared: metrics.Counter("proxy_requests_coalesced"),
+	}
+}
+
+// join returns the call in flight for key, creating it when there is none.
+// leader reports whether the caller created it and must finish it.
+func (c *coalescer) join(key string) (call *coalescedCall, leader bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if call, ok := c.calls[key]; ok {
+		return call, false
+	}
+	call = &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	return call, true
+}
+
+// finish hands resp to the waiters of call and stops new requests joining it.
+// Only the first finish of a call has any effect.
+func (c *coalescer) finish(key string, call *coalescedCall, resp *capturedResponse) {
+	call.once.Do(func() {
+		c.mu.Lock()
+		if c.calls[key] == call {
+			delete(c.calls, key)
+		}
+		c.mu.Unlock()
+		call.resp = resp
+		close(call.done)
+	})
+}
+
+// coalescable reports whether r may share its response with identical
+// concurrent requests: a bodiless GET for a path that does not stream, with no
+// target forced by an honoured X-Proxy-Shard.
+func (h *Handler) coalescable(r *http.Request) bool {
+	return h.config().CoalesceMaxBytes > 0 &&
+		r.Method == http.MethodGet &&
+		(r.Body == nil || r.Body == http.NoBody) &&
+		h.shardOverride(r) == "" &&
+		!h.forwarder.Streams(r.URL.Path)
+}
+
+// coalesceKey identifies the requests that may share a response: the same
+// path and query as sent, and the same values of coalesceVaryHeaders.
+// Credentials are only hashed into the key.
+func coalesceKey(r *http.Request) string {
+	var vary strings.Builder
+	for _, name := range coalesceVaryHeaders {
+		vary.WriteString(strings.Join(r.Header.Values(name), ","))
+		vary.WriteByte('\n')
+	}
+	digest := sha256.Sum256([]byte(vary.String()))
+	return r.URL.Path + "?" + r.URL.RawQuery + "#" + hex.EncodeToString(digest[:])
+}
+
+// handleCoalescedProxy forwards r, or waits for an identical request already
+// in flight and replays its response.
+func (h *Handler) handleCoalescedProxy(w http.ResponseWriter, r *http.Request) {
+	key := coalesceKey(r)
+	call, leader := h.coalescer.join(key)
+	if !leader {
+		select {
+		case <-call.done:
+		case <-r.Context().Done():
+			return
+		}
+		if call.resp == nil {
+			_ = h.forward(w, r)
+			return
+		}
+		h.coalescer.shared.Add(1)
+		call.resp.replay(w)
+		return
+	}
+
+	// Waiters are released even if forwarding panics; finish is a no-op once
+	// the call has been finished below.
+	defer h.coalescer.finish(key, call, nil)
+
+	capture := &captureWriter{
+		ResponseWriter: w,
+		limit:          h.config().CoalesceMaxBytes,
+		abandon:        func() { h.coalescer.finish(key, call, nil) },
+	}
+	err := h.forward(capture, r)
+
+	// Only a complete response can be shared: a failure once the upstream
+	// body had started leaves the capture truncated.
+	var rtErr *proxy.RoundTripError
+	if r.Context().Err() != nil || (err != nil && !errors.As(err, &rtErr) && !errors.Is(err, errNoUpstreamTarget)) {
+		h.coalescer.finish(key, call, nil)
+		return
+	}
+	h.coalescer.finish(key, call, capture.response())
+}
+
+// replay writes the captured response to w. Headers w already carries, such
+// as its own request ID, are kept; cookies are never replayed.
+func (c *capturedResponse) replay(w http.ResponseWriter) {
+	for name, values := range relayHeaders(c.header) {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(c.status)
+	_, _ = w.Write(c.body)
+}
+
+// captureWriter passes a response through to the client while keeping a copy
+// of up to limit bytes. Once the response outgrows limit, or is an event
+// stream, capturing stops and abandon is called so waiters need not wait for
+// the rest of it.
+type captureWriter struct {
+	http.ResponseWriter
+	limit   int
+	abandon func()
+
+	status    int
+	header    http.Header
+	body      bytes.Buffer
+	abandoned bool
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	if c.status == 0 && status >= http.StatusOK {
+		c.status = status
+		c.header = c.ResponseWriter.Header().Clone()
+		if proxy.IsEventStream(c.header.Get(headerContentType)) {
+			c.stop()
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.abandoned {
+		if c.body.Len()+len(p) > c.limit {
+			c.stop()
+		} else {
+			c.body.Write(p)
+		}
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the client's writer.
+func (c *captureWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
+
+func (c *captureWriter) stop() {
+	if !c.abandoned {
+		c.abandoned = true
+		c.body = bytes.Buffer{}
+		c.abandon()
+	}
+}
+
+// response returns the captured response, or nil when capturing stopped or
+// nothing was written.
+func (c *captureWriter) response() *capturedResponse {
+	if c.abandoned || c.status == 0 {
+		return nil
+	}
+	return &capturedResponse{status: c.status, header: c.header, body: c.body.Bytes()}
+}
@@ -0,0 +1,23 @@
+package member
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestShardHeaderDisablesCoalescingOnlyWithDebugEndpoints(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	req := httptest.NewRequest(http.MethodGet, "/games/v1/games?universeIds=1", nil)
+	req.Header.Set(headerProxyShard, "0")
+
+	h, _ := newTestHandler(t, upstream.URL, nil)
+	if !h.coalescable(req) {
+		t.Fatal("an ignored shard header opted the request out of coalescing")
+	}
+
+	debug, _ := newTestHandler(t, upstream.URL, map[string]string{"PROXY_DEBUG_ENDPOINTS": "true", "PROXY_ADMIN_TOKEN": "secret"})
+	if debug.coalescable(req) {
+		t.Fatal("a request forced onto a shard was coalesced")
+	}
+}
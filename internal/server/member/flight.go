@@ -0,0 +1,68 @@
+package member
+
+import (
+	"expvar"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+)
+
+// flightPhase distinguishes request-path fetches from background refreshes so
+// the two are deduplicated independently.
+type flightPhase int
+
+const (
+	phaseFetch flightPhase = iota
+	phaseRefresh
+)
+
+type flightNamespace struct {
+	op    operation
+	phase flightPhase
+}
+
+// flightGroups keeps a dedicated singleflight group per operation and phase, so
+// identical keys in different namespaces never share a call.
+type flightGroups struct {
+	mu     sync.Mutex
+	groups map[flightNamespace]*singleflight.Group
+
+	// leads and joins count, per operation, the request-path fetches that
+	// called upstream and those that shared a fetch already in flight.
+	leads *expvar.Map
+	joins *expvar.Map
+}
+
+func newFlightGroups() flightGroups {
+	return flightGroups{
+		leads: metrics.LabeledCounter("singleflight_leads"),
+		joins: metrics.LabeledCounter("singleflight_joins"),
+	}
+}
+
+// record counts a request-path fetch of op that led its call, or joined one.
+func (f *flightGroups) record(op operation, led bool) {
+	if led {
+		f.leads.Add(string(op), 1)
+	} else {
+		f.joins.Add(string(op), 1)
+	}
+}
+
+func (f *flightGroups) group(op operation, phase flightPhase) *singleflight.Group {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ns := flightNamespace{op: op, phase: phase}
+	if g, ok := f.groups[ns]; ok {
+		return g
+	}
+	if f.groups == nil {
+		f.groups = make(map[flightNamespace]*singleflight.Group)
+	}
+	g := &singleflight.Group{}
+	f.groups[ns] = g
+	return g
+}
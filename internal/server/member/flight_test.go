@@ -0,0 +1,68 @@
+package member
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentUserLookupsShareOneUpstreamCall(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, fakeUserPath) {
+			<-release
+		}
+		robloxAPI(w, r)
+	})
+	h, _ := newTestHandler(t, upstream.URL, nil)
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, 2)
+	lookup := func(i int) {
+		defer wg.Done()
+		recs[i] = get(h, "/?userId=1")
+	}
+
+	wg.Add(1)
+	go lookup(0)
+	waitFor(t, "the first lookup to reach the upstream", func() bool { return upstream.count(fakeUserPath+"1") == 1 })
+	wg.Add(1)
+	go lookup(1)
+	// The second lookup must join the call still blocked upstream.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := upstream.count(fakeUserPath + "1"); n != 1 {
+		t.Fatalf("upstream user calls = %d, want 1", n)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusOK {
+			t.Fatalf("lookup %d status = %d, want 200", i, rec.Code)
+		}
+	}
+	if a, b := body(t, recs[0]), body(t, recs[1]); a != b {
+		t.Fatalf("lookups returned different payloads:\n%s\n%s", a, b)
+	}
+	if joins, ok := h.flights.joins.Get(string(opUser)).(*expvar.Int); !ok || joins.Value() != 1 {
+		t.Fatalf("singleflight joins for %q = %v, want 1", opUser, h.flights.joins.Get(string(opUser)))
+	}
+}
+
+func TestFlightGroupsAreScopedPerNamespace(t *testing.T) {
+	f := newFlightGroups()
+
+	if f.group(opUser, phaseFetch) != f.group(opUser, phaseFetch) {
+		t.Fatal("the same namespace returned different groups")
+	}
+	if f.group(opUser, phaseFetch) == f.group(opSearch, phaseFetch) {
+		t.Fatal("different operations share a group")
+	}
+	if f.group(opUser, phaseFetch) == f.group(opUser, phaseRefresh) {
+		t.Fatal("fetches and refreshes of one operation share a group")
+	}
+}
@@ -1,21 +1,36 @@
 package member
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
-	"golang.org/x/sync/singleflight"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/semaphore"
 
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/ratelimit"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/reqmeta"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/tracing"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/util"
 )
@@ -25,157 +40,662 @@ const (
 	headerAccessControlAllowOrigin = "Access-Control-Allow-Origin"
 	headerContentType              = "Content-Type"
 	contentTypeJSON                = "application/json"
-	userAgent                      = "RobloxProxyCluster/1.0"
+	hashRingReplicas               = 128
+	avatarImagePath                = "/avatar-image"
+	usersService                   = "users"
+	searchService                  = "apis"
+	// headerProxyShard forces a request onto a target index when debug
+	// endpoints are enabled.
+	headerProxyShard = "X-Proxy-Shard"
+	// headerCacheControl with no-cache, like a nocache=1 query parameter,
+	// bypasses the cache read of a lookup when debug endpoints are enabled.
+	headerCacheControl = "Cache-Control"
+	// headerXCache reports whether a cached lookup was a HIT, STALE or MISS.
+	headerXCache = "X-Cache"
 )
 
 var (
 	errBadPath          = errors.New("unable to determine Roblox upstream from path")
 	errNoUpstreamTarget = errors.New("no upstream target available")
+	errWriteNotAllowed  = errors.New("write methods are not allowed for this Roblox service")
+	errInvalidShard     = errors.New("invalid X-Proxy-Shard")
+	// errInvalidUpstreamJSON is returned when a raw upstream body fails JSON validation.
+	errInvalidUpstreamJSON = errors.New("upstream returned invalid JSON")
+	// errInvalidUserPayload is returned when a combined user payload is
+	// missing fields required by UserPayloadValidation.
+	errInvalidUserPayload = errors.New("upstream user payload failed validation")
+	// errUpstreamResponseTooLarge is returned when a lookup's upstream body
+	// exceeds MaxUpstreamResponseBytes.
+	errUpstreamResponseTooLarge = errors.New("upstream response exceeds size limit")
 )
 
 // Handler routes member traffic either to cached endpoints or Roblox directly.
 type Handler struct {
-	cfg       config.Config
+	// cfg holds the current configuration; Reload swaps it.
+	cfg       atomic.Pointer[config.Config]
 	logger    *slog.Logger
 	cache     cache.Store
 	forwarder *proxy.Forwarder
-	targets   []upstream.MemberTarget
-	sgroup    singleflight.Group
+	// targets is the current member target set; Reload swaps it.
+	targets  atomic.Pointer[targetSet]
+	flights  flightGroups
+	reserved map[string]struct{}
+	writable map[string]struct{}
+	// allowedMethods are the request methods served at all, and allowHeader
+	// lists them for 405 responses.
+	allowedMethods map[string]struct{}
+	allowHeader    string
+	health         *upstream.HealthChecker
+	// fetchSem bounds distinct cache-miss fetches in flight. Nil means unbounded.
+	fetchSem *semaphore.Weighted
+	// batchFetchSem bounds the cache-miss fetches of batch operations in
+	// flight. Nil means unbounded.
+	batchFetchSem *semaphore.Weighted
+	// thumbnails throttles calls to the thumbnails service. Nil means unbounded.
+	thumbnails *thumbnailLimiter
+	// refreshBucket paces background refreshes across all keys. Nil means unpaced.
+	refreshBucket *ratelimit.Bucket
+	// popularity weights JSON cache TTLs by access frequency. Nil means fixed TTLs.
+	popularity *popularityTTL
+	// warmupIDs are user IDs preloaded into the cache by Warmup.
+	warmupIDs []string
+	// refreshSem bounds concurrent background refreshes. Nil means unbounded.
+	refreshSem        *semaphore.Weighted
+	refreshesInFlight atomic.Int64
+	// background is the context refreshes and prefetches run under, cancelled
+	// by Shutdown; backgroundWG tracks them. backgroundMu orders new work
+	// against Shutdown waiting for it.
+	background     context.Context
+	stopBackground context.CancelFunc
+	backgroundMu   sync.Mutex
+	backgroundWG   sync.WaitGroup
+	backgroundDone bool
+	// coalescer merges identical concurrent generic proxy GETs.
+	coalescer *coalescer
+	// maxAgeMisses counts cached entries refetched for exceeding
+	// MaxServableAge.
+	maxAgeMisses *expvar.Int
+	// replay serves or records internal upstream fetches. Nil calls Roblox.
+	replay *replayStore
+	// avatarBatch merges concurrent avatar-bust lookups. Nil fetches each
+	// user's avatar on its own.
+	avatarBatch *avatarBatcher
+	// fetchLatency times each fetchFrom attempt.
+	fetchLatency *metrics.Histogram
 }
 
 // New constructs a member handler.
-func New(cfg config.Config, logger *slog.Logger, cacheStore cache.Store, client *http.Client) (*Handler, error) {
-	targets, err := upstream.ParseMemberTargets(cfg.MemberClusters)
+func New(cfg config.Config, logger *slog.Logger, cacheStore cache.Store, client *http.Client, tracker *proxy.Tracker) (*Handler, error) {
+	set, err := newTargetSet(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Handler{
-		cfg:    cfg,
+	reserved := make(map[string]struct{}, len(cfg.ReservedPaths))
+	for _, p := range cfg.ReservedPaths {
+		reserved[p] = struct{}{}
+	}
+
+	writable := make(map[string]struct{}, len(cfg.WriteAllowedSubdomains))
+	for _, d := range cfg.WriteAllowedSubdomains {
+		writable[d] = struct{}{}
+	}
+
+	allowedMethods := make(map[string]struct{}, len(cfg.AllowedMethods))
+	for _, m := range cfg.AllowedMethods {
+		allowedMethods[m] = struct{}{}
+	}
+
+	var health *upstream.HealthChecker
+	if cfg.HealthChecksEnabled {
+		checks, err := healthChecks(cfg, set)
+		if err != nil {
+			return nil, err
+		}
+		health = upstream.NewHealthChecker(client, logger, checks)
+	}
+
+	var warmupIDs []string
+	if cfg.WarmupFile != "" {
+		warmupIDs, err = loadWarmupIDs(cfg.WarmupFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var refreshBucket *ratelimit.Bucket
+	if cfg.RefreshRateLimit > 0 {
+		refreshBucket = ratelimit.NewBucket(cfg.RefreshRateLimit, cfg.RefreshBurst)
+	}
+
+	var refreshSem *semaphore.Weighted
+	if cfg.MaxBackgroundRefreshes > 0 {
+		refreshSem = semaphore.NewWeighted(int64(cfg.MaxBackgroundRefreshes))
+	}
+
+	var fetchSem *semaphore.Weighted
+	if cfg.MaxConcurrentFetches > 0 {
+		fetchSem = semaphore.NewWeighted(int64(cfg.MaxConcurrentFetches))
+	}
+
+	var batchFetchSem *semaphore.Weighted
+	if cfg.BatchFetchConcurrency > 0 {
+		batchFetchSem = semaphore.NewWeighted(int64(cfg.BatchFetchConcurrency))
+	}
+
+	limiter := proxy.NewUpstreamLimiter(cfg.MaxConcurrentUpstream, cfg.UpstreamQueueTimeout)
+	metrics.Gauge("upstream_requests_in_flight", limiter.InFlight)
+
+	h := &Handler{
 		logger: logger.With(slog.String("component", "member-handler")),
 		cache:  cacheStore,
 		forwarder: &proxy.Forwarder{
-			Client:            client,
-			Logger:            logger,
-			RequestTimeout:    cfg.RequestTimeout,
-			DiscordWebhookURL: cfg.DiscordWebhookURL,
+			Client:               client,
+			Logger:               logger,
+			RequestTimeout:       cfg.RequestTimeout,
+			DiscordWebhookURL:    cfg.DiscordWebhookURL,
+			MaxRequestBodyBytes:  cfg.MaxRequestBodyBytes,
+			Tracker:              tracker,
+			StripRequestHeaders:  stripRequestHeaders(cfg),
+			AddRequestHeaders:    cfg.AddRequestHeaders,
+			StripResponseHeaders: cfg.StripResponseHeaders,
+			Limiter:              limiter,
+			Buffers:              proxy.NewBufferPool(cfg.CopyBufferBytes),
+			FlushBytes:           cfg.StreamFlushBytes,
+			ServiceTimeouts:      cfg.ServiceTimeouts,
+			UserAgent:            cfg.UserAgent,
+			ServiceUserAgents:    cfg.ServiceUserAgents,
+			StreamingPaths:       cfg.StreamingPaths,
+			ForwardedHeaders:     cfg.ForwardedHeaders,
+			RobloxAuth:           robloxAuth(cfg),
+			Shadow:               proxy.NewShadow(cfg, client, logger),
 		},
-		targets: targets,
-	}, nil
+		reserved:       reserved,
+		writable:       writable,
+		allowedMethods: allowedMethods,
+		allowHeader:    strings.Join(cfg.AllowedMethods, ", "),
+		health:         health,
+		fetchSem:       fetchSem,
+		batchFetchSem:  batchFetchSem,
+		thumbnails:     newThumbnailLimiter(cfg),
+		refreshBucket:  refreshBucket,
+		popularity:     newPopularityTTL(cfg),
+		warmupIDs:      warmupIDs,
+		refreshSem:     refreshSem,
+		coalescer:      newCoalescer(),
+		flights:        newFlightGroups(),
+		replay:         newReplayStore(cfg),
+		maxAgeMisses:   metrics.Counter("cache_max_age_misses"),
+		fetchLatency:   metrics.NewHistogram("upstream_fetch_duration_seconds", metrics.LatencyBuckets),
+	}
+	h.background, h.stopBackground = context.WithCancel(context.Background())
+	if cfg.AvatarBatchWindow > 0 {
+		// A batch outlives the request that started it, so it is fetched
+		// until the handler shuts down rather than until that request ends.
+		h.avatarBatch = newAvatarBatcher(cfg.AvatarBatchWindow, h.logger, func(ctx context.Context, size string, userIDs []string) (map[int64]string, error) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			stop := context.AfterFunc(h.background, cancel)
+			defer stop()
+			return h.fetchAvatarBatch(ctx, size, userIDs)
+		})
+	}
+	h.cfg.Store(&cfg)
+	h.targets.Store(set)
+	metrics.Gauge("member_refreshes_in_flight", h.RefreshesInFlight)
+	if h.replay != nil {
+		h.logger.Warn("upstream replay is enabled; lookups are not served from Roblox", slog.String("mode", cfg.UpstreamReplayMode), slog.String("dir", cfg.UpstreamReplayDir))
+	}
+
+	return h, nil
+}
+
+// robloxAuth returns the session cookie authenticator, or nil unless it has
+// been explicitly enabled.
+func robloxAuth(cfg config.Config) *proxy.RobloxAuth {
+	if !cfg.RobloxAuthEnabled {
+		return nil
+	}
+	return proxy.NewRobloxAuth(cfg.RobloxCookie, cfg.RobloxBaseDomain, cfg.RobloxAuthPaths)
 }
 
 // ServeHTTP implements http.Handler.
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.allowedMethods[r.Method]; !ok {
+		w.Header().Set("Allow", h.allowHeader)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if r.URL.Path == avatarImagePath {
+		if requireGet(w, r) {
+			h.handleAvatarImage(w, r)
+		}
+		return
+	}
+
+	if r.URL.Path == batchPath {
+		h.handleBatch(w, r)
+		return
+	}
+
 	q := r.URL.Query()
 
-	if userID := strings.TrimSpace(q.Get("userId")); userID != "" {
-		h.handleUserLookup(w, r, userID)
+	if userID := strings.TrimSpace(q.Get(paramUserID)); userID != "" {
+		if requireGet(w, r) {
+			h.handleUserLookup(w, r, userID)
+		}
 		return
 	}
 
-	if search := strings.TrimSpace(q.Get("search")); search != "" {
-		h.handleSearch(w, r, search)
+	if search := strings.TrimSpace(q.Get(paramSearch)); search != "" {
+		if requireGet(w, r) {
+			h.handleSearch(w, r, search)
+		}
+		return
+	}
+
+	if _, ok := h.reserved[r.URL.Path]; ok {
+		h.handleReserved(w, r)
 		return
 	}
 
 	h.handleProxy(w, r)
 }
 
+// Route names of the lookups selected by query parameter rather than path.
+const (
+	RouteUserLookup = "user-lookup"
+	RouteUserSearch = "user-search"
+)
+
+// Route names the route ServeHTTP serves r under, for per-route settings such
+// as rate limits: RouteUserLookup or RouteUserSearch for the lookups, and
+// otherwise the lowercased first path segment.
+func Route(r *http.Request) string {
+	if r.URL.Path != avatarImagePath && r.URL.Path != batchPath {
+		q := r.URL.Query()
+		if strings.TrimSpace(q.Get(paramUserID)) != "" {
+			return RouteUserLookup
+		}
+		if strings.TrimSpace(q.Get(paramSearch)) != "" {
+			return RouteUserSearch
+		}
+	}
+	segment, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	return strings.ToLower(segment)
+}
+
+// requireGet answers 405 to anything but a GET, for the lookup endpoints, and
+// reports whether r may be served.
+func requireGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodGet)
+	apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "method not allowed")
+	return false
+}
+
 func (h *Handler) handleProxy(w http.ResponseWriter, r *http.Request) {
-	target, err := h.pickTargetURL(r)
-	if err != nil {
-		h.respondError(w, http.StatusBadGateway, err)
+	if service := robloxSubdomain(r.URL.Path); !h.knownService(service) {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, fmt.Sprintf("unknown Roblox service %q", service))
+		return
+	}
+
+	if !isReadMethod(r.Method) && !h.writeAllowed(r.URL.Path) {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		h.respondError(w, http.StatusMethodNotAllowed, errWriteNotAllowed)
+		return
+	}
+
+	h.forwarder.Mirror(r.Context(), r.Method, r.URL.Path, r.URL.RawQuery, r.Header)
+
+	if r.Method == http.MethodGet && (r.Body == nil || r.Body == http.NoBody) && h.shardOverride(r) == "" {
+		if ttl, ok := h.proxyCacheTTL(r.URL.Path); ok {
+			h.handleCachedProxy(w, r, ttl)
+			return
+		}
+	}
+
+	if h.coalescable(r) {
+		h.handleCoalescedProxy(w, r)
 		return
 	}
 
-	if err := h.forwarder.Do(w, r, target); err != nil {
-		h.logger.Error("proxy request failed", slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+	_ = h.forward(w, r)
+}
+
+// forward relays r to its member targets, following the fallback chain while
+// nothing has been written to the client. It always responds, and returns the
+// error of the last failed attempt, if any.
+func (h *Handler) forward(w http.ResponseWriter, r *http.Request) error {
+	routes, err := h.pickTargetURLs(r)
+	if err != nil {
+		if errors.Is(err, errInvalidShard) {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, err.Error())
+			return err
+		}
 		h.respondError(w, http.StatusBadGateway, err)
+		return err
+	}
+	// A request body is consumed by the first attempt, so only bodiless
+	// requests can fall back to another target.
+	if r.Body != nil && r.Body != http.NoBody {
+		routes = routes[:1]
 	}
+
+	for attempt, rt := range routes {
+		err = h.forwarder.DoVia(rt.client, w, r, rt.url, rt.headers)
+		if err == nil {
+			h.logServedBy(r.Context(), attempt, rt)
+			return nil
+		}
+		if errors.Is(err, proxy.ErrRequestBodyTooLarge) {
+			h.respondError(w, http.StatusRequestEntityTooLarge, err)
+			return err
+		}
+		if errors.Is(err, proxy.ErrDraining) || errors.Is(err, proxy.ErrUpstreamSaturated) {
+			h.respondError(w, http.StatusServiceUnavailable, err)
+			return err
+		}
+		if r.Context().Err() != nil {
+			break
+		}
+		h.reportTargetFailure(rt.index, err)
+
+		// Once the upstream has answered, the response is already streaming
+		// to the client and cannot be retried.
+		var rtErr *proxy.RoundTripError
+		if !errors.As(err, &rtErr) {
+			break
+		}
+		if attempt+1 < len(routes) {
+			h.logger.WarnContext(r.Context(), "member target failed, trying fallback", slog.String("target", rt.target.String()), slog.String("error", err.Error()))
+		}
+	}
+
+	h.logger.ErrorContext(r.Context(), "proxy request failed", slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+	h.respondError(w, http.StatusBadGateway, err)
+	return err
+}
+
+// logServedBy records the target that answered a request after its
+// predecessors in the fallback chain failed.
+func (h *Handler) logServedBy(ctx context.Context, attempt int, rt route) {
+	if attempt == 0 {
+		return
+	}
+	h.logger.InfoContext(ctx, "request served by fallback target", slog.String("target", rt.target.String()), slog.Int("attempt", attempt+1))
 }
 
 func (h *Handler) handleUserLookup(w http.ResponseWriter, r *http.Request, userID string) {
 	if !isNumeric(userID) {
-		h.respondJSON(w, http.StatusBadRequest, []byte(`{"error":"Invalid or missing userId"}`))
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidUserID, "Invalid or missing userId")
+		return
+	}
+
+	sizes, err := parseAvatarSizes(r.URL.Query().Get(paramSizes))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "Invalid avatar size")
+		return
+	}
+
+	fields, err := parseUserFields(r.URL.Query().Get(paramFields))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "Invalid fields: "+err.Error())
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.RequestTimeout)
+	ctx, cancel := context.WithTimeout(h.lookupContext(r), h.lookupTimeout(usersService, thumbnailsService))
 	defer cancel()
 
-	payload, err := h.readThroughCache(ctx, h.userCacheKey(userID), func(ctx context.Context) ([]byte, error) {
-		return h.fetchUserPayload(ctx, userID)
-	})
+	result, err := h.lookupUser(ctx, userID, sizes)
 	if err != nil {
-		h.logger.Error("user lookup failed", slog.String("userId", userID), slog.String("error", err.Error()))
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.respondLookupError(w, err)
 		return
 	}
 
-	h.respondCachedJSON(w, payload)
+	// The full payload is cached, so every field selection shares one entry.
+	if fields != nil {
+		if result.payload, err = filterUserPayload(result.payload, fields); err != nil {
+			h.respondError(w, http.StatusBadGateway, err)
+			return
+		}
+	}
+
+	h.respondCachedJSON(w, result)
+}
+
+// lookupUser serves the cached user payload for userID, which must be numeric.
+// sizes, as returned by parseAvatarSizes, adds an avatarUrls map to the
+// payload; each distinct set is cached separately.
+func (h *Handler) lookupUser(ctx context.Context, userID string, sizes []string) (cachedPayload, error) {
+	result, err := h.readThroughCache(ctx, opUser, h.userSizesCacheKey(userID, sizes), func(ctx context.Context) ([]byte, error) {
+		return h.fetchUserPayload(ctx, userID, sizes)
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "user lookup failed", slog.String("userId", userID), slog.String("error", err.Error()))
+		return cachedPayload{}, err
+	}
+
+	if h.config().PrefetchAvatars {
+		h.launchPrefetch(ctx, opAvatar, h.avatarCacheKey(userID, defaultAvatarSize), h.config().CacheTTL, h.avatarFetcher(userID, defaultAvatarSize))
+	}
+	return result, nil
 }
 
 func (h *Handler) handleSearch(w http.ResponseWriter, r *http.Request, search string) {
-	needle := strings.TrimSpace(search)
-	if len(needle) < 3 {
+	needle := normalizeSearch(search)
+	if utf8.RuneCountInString(needle) < 3 {
 		h.respondJSON(w, http.StatusBadRequest, []byte(`[]`))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), h.cfg.RequestTimeout)
+	limit, err := h.searchLimit(r.URL.Query().Get(paramLimit))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "Invalid limit")
+		return
+	}
+
+	embedAvatars, err := h.searchEmbedAvatars(r.URL.Query().Get(paramEmbedAvatars))
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidParameter, "Invalid embedAvatars")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(h.lookupContext(r), h.lookupTimeout(searchService, thumbnailsService))
 	defer cancel()
 
-	key := h.searchCacheKey(strings.ToLower(needle))
-	payload, err := h.readThroughCache(ctx, key, func(ctx context.Context) ([]byte, error) {
-		return h.fetchSearchPayload(ctx, needle)
-	})
+	result, err := h.searchUsers(ctx, needle, limit, embedAvatars)
 	if err != nil {
-		h.logger.Error("search failed", slog.String("query", needle), slog.String("error", err.Error()))
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.respondLookupError(w, err)
 		return
 	}
 
-	h.respondCachedJSON(w, payload)
+	h.respondCachedJSON(w, result)
+}
+
+// searchUsers serves the cached search results for a normalized query.
+func (h *Handler) searchUsers(ctx context.Context, needle string, limit int, embedAvatars bool) (cachedPayload, error) {
+	key := h.searchResultKey(needle, limit, embedAvatars)
+	result, err := h.readThroughCache(ctx, opSearch, key, func(ctx context.Context) ([]byte, error) {
+		return h.fetchSearchPayload(ctx, needle, limit, embedAvatars)
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "search failed", slog.String("query", needle), slog.String("error", err.Error()))
+		return cachedPayload{}, err
+	}
+	return result, nil
+}
+
+// lookupContext returns the context a cached lookup for r runs under. A
+// nocache=1 query parameter or a Cache-Control: no-cache header forces a fresh
+// fetch, but only while debug endpoints are enabled; otherwise any client could
+// defeat the cache. The bypass skips the cache read, not the write, so the
+// fetched result still refreshes the entry for later requests.
+func (h *Handler) lookupContext(r *http.Request) context.Context {
+	if !h.config().DebugEndpoints || !bypassRequested(r) {
+		return r.Context()
+	}
+	return withCacheBypass(r.Context())
+}
+
+func bypassRequested(r *http.Request) bool {
+	if v, _ := strconv.ParseBool(r.URL.Query().Get(paramNoCache)); v {
+		return true
+	}
+	for _, value := range r.Header.Values(headerCacheControl) {
+		for directive := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupTimeout bounds a cached lookup that calls each of services in turn by
+// the longest of their upstream timeouts.
+func (h *Handler) lookupTimeout(services ...string) time.Duration {
+	var timeout time.Duration
+	for _, service := range services {
+		timeout = max(timeout, h.forwarder.TimeoutFor(service))
+	}
+	return timeout
+}
+
+// stripRequestHeaders lists the client headers the forwarder drops: the
+// configured ones plus headerProxyShard, which is meant for this member alone.
+func stripRequestHeaders(cfg config.Config) []string {
+	return append(slices.Clone(cfg.StripRequestHeaders), headerProxyShard)
+}
+
+// route is the upstream selected for a request.
+type route struct {
+	url    *url.URL
+	target upstream.MemberTarget
+	// index is the target's position in the target set it was chosen from.
+	index int
+	// client sends requests on this route.
+	client *http.Client
+	// headers are extra headers rendered from the target's templates.
+	headers http.Header
+}
+
+// pickTargetURLs routes a proxied request. The client's raw query is passed
+// through byte-for-byte, since some Roblox endpoints sign their parameters in
+// order; only internally built requests are canonicalized.
+func (h *Handler) pickTargetURLs(r *http.Request) ([]route, error) {
+	if shard := h.shardOverride(r); shard != "" {
+		return h.shardTarget(shard, r.URL.Path, r.URL.RawQuery)
+	}
+	return h.chooseTargets(r.URL.Path, r.URL.RawQuery)
+}
+
+// shardOverride returns the target index an X-Proxy-Shard header forces r
+// onto, or "" when there is none. The header is ignored unless debug
+// endpoints are enabled.
+func (h *Handler) shardOverride(r *http.Request) string {
+	if !h.config().DebugEndpoints {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(headerProxyShard))
+}
+
+// shardTarget routes a request to the target index named by an X-Proxy-Shard
+// header, bypassing the hash ring and the fallback chain.
+func (h *Handler) shardTarget(shard, path, rawQuery string) ([]route, error) {
+	set := h.targets.Load()
+	idx, err := strconv.Atoi(shard)
+	if err != nil || idx < 0 || idx >= len(set.targets) {
+		return nil, fmt.Errorf("%w: want 0-%d", errInvalidShard, len(set.targets)-1)
+	}
+	rt, err := h.buildRoute(set, idx, routingKey(path, rawQuery), path, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	return []route{rt}, nil
 }
 
-func (h *Handler) pickTargetURL(r *http.Request) (*url.URL, error) {
-	return h.chooseTarget(r.URL.Path, r.URL.RawQuery)
+// routingKey is the hash ring key for a request. It uses the canonical query
+// so that requests differing only in parameter order land on the same target
+// and cache shard; the upstream request still carries the query as given.
+func routingKey(path, rawQuery string) string {
+	if canonical := util.CanonicalQuery(rawQuery); canonical != "" {
+		return path + "?" + canonical
+	}
+	return path
 }
 
-func (h *Handler) chooseTarget(path, rawQuery string) (*url.URL, error) {
-	if len(h.targets) == 0 {
+// chooseTargets returns the target owning the request on the hash ring
+// followed by up to MemberFallbacks others, in the deterministic order the
+// ring yields them, to try if the ones before fail.
+func (h *Handler) chooseTargets(path, rawQuery string) ([]route, error) {
+	set := h.targets.Load()
+	if len(set.targets) == 0 {
 		return nil, errNoUpstreamTarget
 	}
 
-	key := path
-	if rawQuery != "" {
-		key += "?" + rawQuery
+	key := routingKey(path, rawQuery)
+	indexes := set.ring.Sequence(key, 1+h.config().MemberFallbacks)
+	if len(indexes) == 0 {
+		return nil, errNoUpstreamTarget
 	}
 
-	idx := util.ConsistentIndex(key, len(h.targets))
-	target := h.targets[idx]
+	routes := make([]route, 0, len(indexes))
+	for _, idx := range indexes {
+		rt, err := h.buildRoute(set, idx, key, path, rawQuery)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, rt)
+	}
+	return routes, nil
+}
+
+// buildRoute resolves the upstream URL and headers for sending the request to
+// the target at idx in set. key is the request's routing key. rawQuery is set
+// on the URL verbatim and must not be re-encoded.
+func (h *Handler) buildRoute(set *targetSet, idx int, key, path, rawQuery string) (route, error) {
+	target := set.targets[idx]
 
+	rt := route{target: target, index: idx, client: h.forwarder.Client}
+	if c := set.clients[idx]; c != nil {
+		rt.client = c
+	}
 	switch target.Kind {
-	case upstream.MemberTargetDirect:
-		host, rewritten, err := resolveRobloxTarget(path)
+	case upstream.MemberTargetDirect, upstream.MemberTargetSocks5:
+		u, err := set.directURL(path, rawQuery)
 		if err != nil {
-			return nil, err
+			return route{}, err
 		}
-		return &url.URL{
-			Scheme:   "https",
-			Host:     host,
-			Path:     rewritten,
-			RawQuery: rawQuery,
-		}, nil
+		rt.url = u
 	case upstream.MemberTargetStatic:
 		rel := &url.URL{Path: path, RawQuery: rawQuery}
-		return target.Base.ResolveReference(rel), nil
+		rt.url = target.Base.ResolveReference(rel)
 	default:
-		return nil, errNoUpstreamTarget
+		return route{}, errNoUpstreamTarget
 	}
+
+	headers, err := target.RenderHeaders(upstream.HeaderContext{
+		Path:   path,
+		Query:  rawQuery,
+		Hash:   util.Hash(key),
+		Target: target.String(),
+	})
+	if err != nil {
+		return route{}, err
+	}
+	rt.headers = headers
+
+	return rt, nil
 }
 
-func (h *Handler) fetchUserPayload(ctx context.Context, userID string) ([]byte, error) {
+// fetchUserPayload combines a user's profile with their 48x48 avatar URL. When
+// sizes is non-empty every requested size is fetched in the same thumbnails
+// batch call and returned in avatarUrls, keyed by size.
+func (h *Handler) fetchUserPayload(ctx context.Context, userID string, sizes []string) ([]byte, error) {
 	var userResp struct {
 		Description string `json:"description"`
 		Created     string `json:"created"`
@@ -185,147 +705,315 @@ func (h *Handler) fetchUserPayload(ctx context.Context, userID string) ([]byte,
 		DisplayName string `json:"displayName"`
 	}
 
-	if err := h.fetchJSON(ctx, "users", "/v1/users/"+userID, nil, &userResp); err != nil {
+	raw, err := h.fetchRaw(ctx, usersService, "/v1/users/"+userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &userResp); err != nil {
 		return nil, err
 	}
 
-	params := url.Values{
-		"userIds":    {userID},
-		"size":       {"48x48"},
-		"format":     {"Png"},
-		"isCircular": {"false"},
+	var avatarURL string
+	var avatarURLs map[string]string
+	if len(sizes) == 0 {
+		avatarURL, err = h.fetchUserAvatarURL(ctx, userID)
+	} else {
+		embedded := slices.Contains(sizes, userAvatarSize)
+		fetch := sizes
+		if !embedded {
+			fetch = append([]string{userAvatarSize}, sizes...)
+		}
+		avatarURLs, err = h.fetchAvatarURLs(ctx, userID, fetch)
+		avatarURL = avatarURLs[userAvatarSize]
+		if !embedded {
+			delete(avatarURLs, userAvatarSize)
+		}
+	}
+	// A saturated thumbnails limiter, or in lenient mode any avatar failure,
+	// degrades to an empty avatar rather than failing the whole lookup. The
+	// degraded payload is cached like any other.
+	avatarFailed := err != nil
+	if avatarFailed {
+		if !errors.Is(err, errThumbnailsSaturated) && h.config().UserAvatarFailure == config.AvatarFailureStrict {
+			return nil, err
+		}
+		h.logger.WarnContext(ctx, "avatar fetch failed, returning user without avatar", slog.String("userId", userID), slog.String("error", err.Error()))
+		avatarURL = ""
+		if len(sizes) > 0 {
+			avatarURLs = make(map[string]string, len(sizes))
+			for _, size := range sizes {
+				avatarURLs[size] = ""
+			}
+		}
 	}
 
-	var avatarResp struct {
-		Data []struct {
-			ImageURL string `json:"imageUrl"`
-		} `json:"data"`
+	combined := userPayload{
+		Description:       userResp.Description,
+		Created:           userResp.Created,
+		IsBanned:          userResp.IsBanned,
+		ID:                userResp.ID,
+		Name:              userResp.Name,
+		DisplayName:       userResp.DisplayName,
+		AvatarURL:         avatarURL,
+		AvatarURLs:        avatarURLs,
+		AvatarUnavailable: avatarFailed,
 	}
 
-	if err := h.fetchJSON(ctx, "thumbnails", "/v1/users/avatar-bust", params, &avatarResp); err != nil {
+	if err := combined.validate(h.config().UserPayloadValidation); err != nil {
+		h.logger.WarnContext(ctx, "user payload failed validation", slog.String("userId", userID), slog.String("error", err.Error()))
+		h.logger.DebugContext(ctx, "upstream user response", slog.String("userId", userID), slog.String("body", truncateForLog(raw)))
 		return nil, err
 	}
 
-	combined := struct {
-		Description string `json:"description"`
-		Created     string `json:"created"`
-		IsBanned    bool   `json:"isBanned"`
-		ID          int64  `json:"id"`
-		Name        string `json:"name"`
-		DisplayName string `json:"displayName"`
-		AvatarURL   string `json:"avatarUrl"`
-	}{
-		Description: userResp.Description,
-		Created:     userResp.Created,
-		IsBanned:    userResp.IsBanned,
-		ID:          userResp.ID,
-		Name:        userResp.Name,
-		DisplayName: userResp.DisplayName,
-		AvatarURL:   firstAvatarURL(avatarResp.Data),
+	return json.Marshal(combined)
+}
+
+// userPayload is the combined user lookup response.
+type userPayload struct {
+	Description string            `json:"description"`
+	Created     string            `json:"created"`
+	IsBanned    bool              `json:"isBanned"`
+	ID          int64             `json:"id"`
+	Name        string            `json:"name"`
+	DisplayName string            `json:"displayName"`
+	AvatarURL   string            `json:"avatarUrl"`
+	AvatarURLs  map[string]string `json:"avatarUrls,omitempty"`
+	// AvatarUnavailable marks a payload whose avatar could not be fetched.
+	AvatarUnavailable bool `json:"avatarUnavailable,omitempty"`
+}
+
+// validate checks that the fields mode requires are present, so a change to
+// Roblox's response shape fails the lookup instead of caching an empty user.
+func (p userPayload) validate(mode string) error {
+	if mode == config.UserValidationOff {
+		return nil
+	}
+	var missing []string
+	if p.ID == 0 {
+		missing = append(missing, "id")
+	}
+	if p.Name == "" {
+		missing = append(missing, "name")
 	}
+	if mode == config.UserValidationStrict {
+		if p.DisplayName == "" {
+			missing = append(missing, "displayName")
+		}
+		if p.Created == "" {
+			missing = append(missing, "created")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: missing %s", errInvalidUserPayload, strings.Join(missing, ", "))
+	}
+	return nil
+}
 
-	return json.Marshal(combined)
+// maxLoggedBodyBytes caps upstream bodies written to debug logs.
+const maxLoggedBodyBytes = 2048
+
+// truncateForLog returns body as a string of at most maxLoggedBodyBytes.
+func truncateForLog(body []byte) string {
+	if len(body) > maxLoggedBodyBytes {
+		return string(body[:maxLoggedBodyBytes]) + "..."
+	}
+	return string(body)
 }
 
-func (h *Handler) fetchSearchPayload(ctx context.Context, query string) ([]byte, error) {
-	params := url.Values{
-		"verticalType":    {"user"},
-		"searchQuery":     {query},
-		"globalSessionId": {"TridentBot"},
-		"sessionId":       {"TridentBot"},
+// searchLimit parses the optional limit parameter, capped at
+// SearchMaxResults when pagination is enabled. Zero means no limit: the first
+// page is returned as-is.
+func (h *Handler) searchLimit(raw string) (int, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return h.config().SearchMaxResults, nil
 	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if h.config().SearchMaxResults > 0 {
+		limit = min(limit, h.config().SearchMaxResults)
+	}
+	return limit, nil
+}
 
-	var searchResp struct {
-		SearchResults []struct {
-			Contents []struct {
-				ContentID int64  `json:"contentId"`
-				Username  string `json:"username"`
-			} `json:"contents"`
-		} `json:"searchResults"`
+// searchEmbedAvatars parses the optional embedAvatars parameter, defaulting to
+// SearchEmbedAvatars.
+func (h *Handler) searchEmbedAvatars(raw string) (bool, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return h.config().SearchEmbedAvatars, nil
 	}
+	return strconv.ParseBool(raw)
+}
+
+// searchContent is a single user match from omni-search.
+type searchContent struct {
+	ContentID int64  `json:"contentId"`
+	Username  string `json:"username"`
+}
 
-	if err := h.fetchJSON(ctx, "apis", "/search-api/omni-search", params, &searchResp); err != nil {
+func (h *Handler) fetchSearchPayload(ctx context.Context, query string, limit int, embedAvatars bool) ([]byte, error) {
+	contents, err := h.fetchSearchContents(ctx, query, limit)
+	if err != nil {
 		return nil, err
 	}
-
-	results := searchResp.SearchResults
-	if len(results) == 0 || len(results[0].Contents) == 0 {
+	if len(contents) == 0 {
 		return json.Marshal([]any{})
 	}
 
-	contents := results[0].Contents
+	if !embedAvatars {
+		bare := make([]struct {
+			PlayerID string `json:"playerId"`
+			Name     string `json:"name"`
+		}, len(contents))
+		for i, entry := range contents {
+			bare[i].PlayerID = strconv.FormatInt(entry.ContentID, 10)
+			bare[i].Name = entry.Username
+		}
+		return json.Marshal(bare)
+	}
+
 	final := make([]struct {
 		PlayerID  string `json:"playerId"`
 		Name      string `json:"name"`
 		AvatarURL string `json:"avatarUrl"`
 	}, len(contents))
 
+	// Resolve avatars with a bounded pool of workers. Each worker writes only
+	// its own index, so result order is preserved, and a failed lookup blanks
+	// just that entry's URL.
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, h.config().SearchAvatarConcurrency)
 	for i, entry := range contents {
 		userID := fmt.Sprintf("%d", entry.ContentID)
-		avatar, err := h.lookupAvatarURL(ctx, userID)
-		if err != nil {
-			h.logger.Warn("avatar lookup failed", slog.String("userId", userID), slog.String("error", err.Error()))
-		}
-		final[i] = struct {
-			PlayerID  string `json:"playerId"`
-			Name      string `json:"name"`
-			AvatarURL string `json:"avatarUrl"`
-		}{
-			PlayerID:  userID,
-			Name:      entry.Username,
-			AvatarURL: avatar,
-		}
+		final[i].PlayerID = userID
+		final[i].Name = entry.Username
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			avatar, err := h.lookupAvatarURL(ctx, userID)
+			if err != nil {
+				h.logger.WarnContext(ctx, "avatar lookup failed", slog.String("userId", userID), slog.String("error", err.Error()))
+			}
+			final[i].AvatarURL = avatar
+		}()
 	}
+	wg.Wait()
 
 	return json.Marshal(final)
 }
 
-func (h *Handler) lookupAvatarURL(ctx context.Context, userID string) (string, error) {
-	key := h.avatarCacheKey(userID)
-	payload, err := h.readThroughCache(ctx, key, func(ctx context.Context) ([]byte, error) {
-		return h.fetchAvatarPayload(ctx, userID)
-	})
-	if err != nil {
-		return "", err
-	}
+// fetchSearchContents collects user matches for query. With a positive limit
+// it follows omni-search's nextPageToken cursor until limit matches are found,
+// the results run out, or SearchMaxPages pages have been fetched. Without a
+// limit only the first page is read.
+func (h *Handler) fetchSearchContents(ctx context.Context, query string, limit int) ([]searchContent, error) {
+	var (
+		contents  []searchContent
+		pageToken string
+	)
+	for page := 0; page < max(h.config().SearchMaxPages, 1); page++ {
+		params := url.Values{
+			"verticalType":    {"user"},
+			"searchQuery":     {query},
+			"globalSessionId": {"TridentBot"},
+			"sessionId":       {"TridentBot"},
+		}
+		if pageToken != "" {
+			params.Set("pageToken", pageToken)
+		}
 
-	var body struct {
-		URL string `json:"url"`
-	}
+		var searchResp struct {
+			SearchResults []struct {
+				Contents []searchContent `json:"contents"`
+			} `json:"searchResults"`
+			NextPageToken string `json:"nextPageToken"`
+		}
 
-	if err := json.Unmarshal(payload, &body); err != nil {
-		return "", err
-	}
+		if err := h.fetchJSON(ctx, searchService, "/search-api/omni-search", params, &searchResp); err != nil {
+			if page > 0 {
+				// Keep what earlier pages returned rather than failing the search.
+				h.logger.WarnContext(ctx, "search pagination stopped early", slog.String("query", query), slog.Int("page", page), slog.String("error", err.Error()))
+				break
+			}
+			return nil, err
+		}
 
-	return body.URL, nil
-}
+		if len(searchResp.SearchResults) > 0 {
+			contents = append(contents, searchResp.SearchResults[0].Contents...)
+		}
 
-func (h *Handler) fetchAvatarPayload(ctx context.Context, userID string) ([]byte, error) {
-	params := url.Values{
-		"userIds":    {userID},
-		"size":       {"420x420"},
-		"format":     {"Png"},
-		"isCircular": {"false"},
+		if limit <= 0 || len(contents) >= limit || searchResp.NextPageToken == "" {
+			break
+		}
+		pageToken = searchResp.NextPageToken
 	}
 
-	var avatarResp struct {
-		Data []struct {
-			ImageURL string `json:"imageUrl"`
-		} `json:"data"`
+	if limit > 0 && len(contents) > limit {
+		contents = contents[:limit]
 	}
+	return contents, nil
+}
 
-	if err := h.fetchJSON(ctx, "thumbnails", "/v1/users/avatar-bust", params, &avatarResp); err != nil {
+func (h *Handler) fetchJSON(ctx context.Context, service, path string, params url.Values, dest any) error {
+	body, err := h.fetchRaw(ctx, service, path, params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, dest)
+}
+
+// fetchRaw returns the upstream response body as-is, for endpoints that are
+// cached but not transformed. With ValidateRawJSON set the body must be valid
+// JSON.
+func (h *Handler) fetchRaw(ctx context.Context, service, path string, params url.Values) ([]byte, error) {
+	res, err := h.fetchConditional(ctx, service, path, params, nil)
+	if err != nil {
 		return nil, err
 	}
+	return res.body, nil
+}
 
-	payload := struct {
-		URL string `json:"url"`
-	}{URL: firstAvatarURL(avatarResp.Data)}
+// fetchResult is the outcome of a possibly conditional upstream fetch.
+type fetchResult struct {
+	body []byte
+	// etag and lastModified are the response's validators.
+	etag         string
+	lastModified string
+	// notModified is set when the upstream answered 304 to a conditional
+	// request; body is then empty.
+	notModified bool
+}
 
-	return json.Marshal(payload)
+// fetchConditional performs an upstream GET. When prior carries validators
+// they are sent as If-None-Match and If-Modified-Since, and a 304 is reported
+// through fetchResult.notModified instead of as an error. Targets that cannot
+// be reached, or answer 429 or 5xx, are followed by the fallback chain. params
+// are encoded in sorted order, which keeps cache keys and upstream requests
+// for equivalent lookups identical.
+func (h *Handler) fetchConditional(ctx context.Context, service, path string, params url.Values, prior *cache.Entry) (fetchResult, error) {
+	return h.fetchUpstream(ctx, http.MethodGet, service, path, params, nil, prior)
 }
 
-func (h *Handler) fetchJSON(ctx context.Context, service, path string, params url.Values, dest any) error {
+// fetchPost sends body as a JSON POST to an upstream endpoint that reads but
+// does not modify, such as the thumbnails batch API, and decodes the response
+// into dest. It is routed and retried like fetchConditional.
+func (h *Handler) fetchPost(ctx context.Context, service, path string, body []byte, dest any) error {
+	res, err := h.fetchUpstream(ctx, http.MethodPost, service, path, nil, body, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(res.body, dest)
+}
+
+// fetchUpstream implements fetchConditional and fetchPost.
+func (h *Handler) fetchUpstream(ctx context.Context, method, service, path string, params url.Values, body []byte, prior *cache.Entry) (fetchResult, error) {
 	service = strings.Trim(service, "/")
 	basePath := "/" + service
 	if path != "" {
@@ -337,103 +1025,224 @@ func (h *Handler) fetchJSON(ctx context.Context, service, path string, params ur
 		rawQuery = params.Encode()
 	}
 
-	target, err := h.chooseTarget(basePath, rawQuery)
+	if h.replay.replaying() {
+		data, err := h.replay.load(method, service, basePath, rawQuery, body)
+		if errors.Is(err, errReplayMiss) {
+			h.logger.WarnContext(ctx, "no recorded upstream response", slog.String("file", h.replay.file(method, service, basePath, rawQuery, body)))
+		}
+		return fetchResult{body: data}, err
+	}
+
+	routes, err := h.chooseTargets(basePath, rawQuery)
 	if err != nil {
-		return err
+		return fetchResult{}, err
 	}
+	h.forwarder.Mirror(ctx, method, basePath, rawQuery, http.Header{"Accept": {contentTypeJSON}, "User-Agent": {h.forwarder.UserAgentFor(service)}})
 
-	h.logger.Info("fetching JSON", slog.String("service", service), slog.String("path", basePath), slog.String("query", rawQuery), slog.String("target", target.String()))
+	if service == thumbnailsService {
+		release, err := h.thumbnails.acquire()
+		if err != nil {
+			return fetchResult{}, err
+		}
+		defer release()
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
-	if err != nil {
-		return err
+	for attempt, rt := range routes {
+		var res fetchResult
+		res, err = h.fetchFrom(ctx, rt, method, service, basePath, rawQuery, body, prior)
+		if err == nil {
+			h.logServedBy(ctx, attempt, rt)
+			if h.replay.recording() && !res.notModified {
+				if err := h.replay.save(method, service, basePath, rawQuery, body, res.body); err != nil {
+					h.logger.WarnContext(ctx, "recording upstream response failed", slog.String("path", basePath), slog.String("error", err.Error()))
+				}
+			}
+			return res, nil
+		}
+		if ctx.Err() != nil || !fallbackWorthy(err) {
+			break
+		}
+		if attempt+1 < len(routes) {
+			h.logger.WarnContext(ctx, "member target failed, trying fallback", slog.String("target", rt.target.String()), slog.String("error", err.Error()))
+		}
 	}
+	return fetchResult{}, err
+}
 
-	req.Header.Set("User-Agent", userAgent)
-	req.Header.Set("Accept", contentTypeJSON)
+// fallbackWorthy reports whether a fetch failing with err may succeed against
+// another target.
+func fallbackWorthy(err error) bool {
+	if errors.Is(err, proxy.ErrDraining) || errors.Is(err, proxy.ErrUpstreamSaturated) {
+		return false
+	}
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.statusCode == http.StatusTooManyRequests || statusErr.statusCode >= 500
+	}
+	var resp *proxiedResponse
+	if errors.As(err, &resp) {
+		return resp.status == http.StatusTooManyRequests || resp.status >= 500
+	}
+	return !errors.Is(err, errInvalidUpstreamJSON) && !errors.Is(err, errUpstreamResponseTooLarge) && !errors.Is(err, errProxyResponseTooLarge)
+}
+
+// prepareFetch sets the headers of an internally built upstream request to rt
+// for service: the target's templated headers, the service's user agent,
+// accept unless empty, the configured header rules, Roblox auth, request
+// correlation and, when prior has validators, conditional headers.
+func (h *Handler) prepareFetch(ctx context.Context, req *http.Request, rt route, service, accept string, prior *cache.Entry) {
+	for k, vv := range rt.headers {
+		req.Header[k] = vv
+	}
+	req.Header.Set("User-Agent", h.forwarder.UserAgentFor(service))
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if prior != nil {
+		if prior.ETag != "" {
+			req.Header.Set("If-None-Match", prior.ETag)
+		}
+		if prior.LastModified != "" {
+			req.Header.Set("If-Modified-Since", prior.LastModified)
+		}
+	}
+	h.forwarder.ApplyRequestHeaderRules(req.Header)
+	if h.forwarder.RobloxAuth.Applies(rt.url) {
+		h.forwarder.RobloxAuth.Apply(req.Header)
+	}
+	if id := reqmeta.FromContext(ctx).RequestID(); id != "" {
+		req.Header.Set(reqmeta.HeaderRequestID, id)
+	}
+	tracing.Inject(ctx, req.Header)
+}
+
+// fetchFrom performs a single attempt of fetchUpstream against rt.
+func (h *Handler) fetchFrom(ctx context.Context, rt route, method, service, basePath, rawQuery string, body []byte, prior *cache.Entry) (res fetchResult, err error) {
+	target := rt.url
 
-	resp, err := h.forwarder.Client.Do(req)
+	ctx, cancel := context.WithTimeout(ctx, h.forwarder.TimeoutFor(service))
+	defer cancel()
+
+	start := time.Now()
+	ctx, span := tracing.Tracer().Start(ctx, "roblox.fetch", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(attribute.String("roblox.service", service), attribute.String("server.address", target.Host))
+	defer func() {
+		h.observeFetch(ctx, time.Since(start))
+		tracing.EndSpan(span, err)
+	}()
+
+	reqmeta.FromContext(ctx).SetUpstreamHost(target.Host)
+	h.logger.InfoContext(ctx, "fetching JSON", slog.String("service", service), slog.String("path", basePath), slog.String("query", rawQuery), slog.String("target", target.String()))
+
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reqBody)
 	if err != nil {
-		return err
+		return fetchResult{}, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 429 {
-		config.SendDiscordWebhook(h.cfg.DiscordWebhookURL, fmt.Sprintf("Received 429 from upstream: %s", target.String()))
+	h.prepareFetch(ctx, req, rt, service, contentTypeJSON, prior)
+	if body != nil {
+		req.Header.Set(headerContentType, contentTypeJSON)
 	}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("roblox request failed: %s", resp.Status)
+	if !h.forwarder.Tracker.Begin() {
+		return fetchResult{}, proxy.ErrDraining
 	}
+	defer h.forwarder.Tracker.Done()
 
-	return json.NewDecoder(resp.Body).Decode(dest)
-}
+	release, err := h.forwarder.Limiter.Acquire(ctx)
+	if err != nil {
+		return fetchResult{}, err
+	}
+	defer release()
 
-func (h *Handler) readThroughCache(ctx context.Context, key string, fetch func(context.Context) ([]byte, error)) ([]byte, error) {
-	if entry, ok, err := h.cache.Get(ctx, key); err != nil {
-		return nil, err
-	} else if ok {
-		age := time.Since(entry.StoredAt)
-		if age > h.cfg.BackgroundRefreshAfter {
-			h.launchRefresh(key, fetch)
+	resp, err := rt.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.reportTargetFailure(rt.index, err)
 		}
-		return entry.Payload, nil
+		return fetchResult{}, err
 	}
+	defer resp.Body.Close()
 
-	res, err, _ := h.sgroup.Do(key, func() (any, error) {
-		payload, err := fetch(ctx)
-		if err != nil {
-			return nil, err
-		}
-		if err := h.storeWithTTL(key, payload); err != nil {
-			h.logger.Warn("cache store failed", slog.String("key", key), slog.String("error", err.Error()))
-		}
-		return payload, nil
-	})
-	if err != nil {
-		return nil, err
+	if resp.StatusCode == 429 {
+		config.SendDiscordWebhook(h.config().DiscordWebhookURL, fmt.Sprintf("Received 429 from upstream: %s", target.String()))
 	}
 
-	return res.([]byte), nil
-}
+	res.etag = resp.Header.Get("ETag")
+	res.lastModified = resp.Header.Get("Last-Modified")
 
-func (h *Handler) launchRefresh(key string, fetch func(context.Context) ([]byte, error)) {
-	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), h.cfg.RequestTimeout)
-		defer cancel()
+	if resp.StatusCode == http.StatusNotModified && prior != nil {
+		res.notModified = true
+		return res, nil
+	}
 
-		res, err, _ := h.sgroup.Do(key+":refresh", func() (any, error) {
-			payload, err := fetch(ctx)
-			if err != nil {
-				return nil, err
-			}
-			if err := h.storeWithTTL(key, payload); err != nil {
-				h.logger.Warn("refresh cache store failed", slog.String("key", key), slog.String("error", err.Error()))
-			}
-			return payload, nil
-		})
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fetchResult{}, newUpstreamStatusError(resp)
+	}
 
-		if err != nil {
-			h.logger.Debug("background refresh failed", slog.String("key", key), slog.String("error", err.Error()))
-			return
-		}
+	limit := h.config().MaxUpstreamResponseBytes
+	res.body, err = io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return fetchResult{}, err
+	}
+	if int64(len(res.body)) > limit {
+		return fetchResult{}, fmt.Errorf("%w of %d bytes", errUpstreamResponseTooLarge, limit)
+	}
+	if h.config().ValidateRawJSON && !json.Valid(res.body) {
+		return fetchResult{}, errInvalidUpstreamJSON
+	}
+	return res, nil
+}
 
-		_ = res
-	}()
+// observeFetch records the latency of a fetch attempt, linked to the fetch
+// span in ctx when exemplars are enabled.
+func (h *Handler) observeFetch(ctx context.Context, elapsed time.Duration) {
+	if h.config().MetricsExemplars {
+		h.fetchLatency.ObserveExemplar(elapsed.Seconds(), tracing.TraceID(ctx))
+		return
+	}
+	h.fetchLatency.Observe(elapsed.Seconds())
 }
 
-func (h *Handler) storeWithTTL(key string, payload []byte) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	return h.cache.Set(ctx, key, payload, h.cfg.CacheTTL)
+// rawFetcher adapts fetchRaw into a fetch function for readThroughCache, so the
+// upstream body is cached and served byte-for-byte.
+func (h *Handler) rawFetcher(service, path string, params url.Values) func(context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		return h.fetchRaw(ctx, service, path, params)
+	}
 }
 
-func (h *Handler) respondCachedJSON(w http.ResponseWriter, payload []byte) {
+func (h *Handler) respondCachedJSON(w http.ResponseWriter, result cachedPayload) {
 	w.Header().Set(headerContentType, contentTypeJSON)
 	w.Header().Set(headerAccessControlAllowOrigin, corsAllowOrigin)
 	w.Header().Set("Cache-Control", "max-age=18000")
+	setCacheHeaders(w.Header(), result)
 	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(payload)
+	_, _ = w.Write(result.payload)
+}
+
+// setCacheHeaders describes how result was served. X-Cache is HIT for a fresh
+// cached entry, STALE for one due a refresh or served past expiry, and MISS
+// for a fresh fetch; Age is the served entry's age in seconds.
+func setCacheHeaders(header http.Header, result cachedPayload) {
+	switch result.outcome {
+	case outcomeHit:
+		header.Set(headerXCache, "HIT")
+	case outcomeRefresh, outcomeStale:
+		header.Set(headerXCache, "STALE")
+	default:
+		header.Set(headerXCache, "MISS")
+	}
+	if !result.storedAt.IsZero() {
+		header.Set("Age", strconv.Itoa(max(0, int(time.Since(result.storedAt).Seconds()))))
+	}
+	if result.stale {
+		header.Set("Warning", `110 - "Response is Stale"`)
+	}
 }
 
 func (h *Handler) respondJSON(w http.ResponseWriter, status int, payload []byte) {
@@ -444,27 +1253,29 @@ func (h *Handler) respondJSON(w http.ResponseWriter, status int, payload []byte)
 }
 
 func (h *Handler) respondError(w http.ResponseWriter, status int, err error) {
-	msg := fmt.Sprintf(`{"error":"%s"}`, sanitizeError(err))
-	h.respondJSON(w, status, []byte(msg))
+	apierror.WriteError(w, status, err)
 }
 
-func (h *Handler) userCacheKey(userID string) string {
-	return "roblox:user:" + userID
-}
-
-func (h *Handler) searchCacheKey(query string) string {
-	return "roblox:search:" + query
-}
-
-func (h *Handler) avatarCacheKey(userID string) string {
-	return "roblox:avatar:" + userID
+// lookupErrorStatus maps a failed cached lookup to an HTTP status.
+func lookupErrorStatus(err error) int {
+	if _, ok := rateLimited(err); ok {
+		return http.StatusTooManyRequests
+	}
+	switch {
+	case errors.Is(err, errFetchOverloaded), errors.Is(err, errThumbnailsSaturated), errors.Is(err, proxy.ErrDraining), errors.Is(err, proxy.ErrUpstreamSaturated):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errInvalidUserPayload), errors.Is(err, errUpstreamResponseTooLarge):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
-func sanitizeError(err error) string {
-	if err == nil {
-		return ""
-	}
-	return strings.ReplaceAll(err.Error(), "\"", "'")
+// normalizeSearch trims the query, collapses internal whitespace runs to single
+// spaces, and lowercases it, so equivalent queries share a cache entry and send
+// the same upstream request.
+func normalizeSearch(q string) string {
+	return strings.ToLower(strings.Join(strings.Fields(q), " "))
 }
 
 func isNumeric(v string) bool {
@@ -488,13 +1299,48 @@ func firstAvatarURL(data []struct {
 	return data[0].ImageURL
 }
 
-func resolveRobloxTarget(path string) (host string, rewrittenPath string, err error) {
+// knownService reports whether service is a Roblox subdomain requests may be
+// proxied to. Every service is known when RobloxServices is empty.
+func (h *Handler) knownService(service string) bool {
+	services := h.config().RobloxServices
+	return len(services) == 0 || slices.Contains(services, strings.ToLower(service))
+}
+
+// writeAllowed reports whether write methods may be proxied to the Roblox
+// subdomain addressed by path.
+func (h *Handler) writeAllowed(path string) bool {
+	_, ok := h.writable[strings.ToLower(robloxSubdomain(path))]
+	return ok
+}
+
+func isReadMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// robloxSubdomain returns the first path segment, which names the Roblox
+// service a proxied request is addressed to.
+func robloxSubdomain(path string) string {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	return segments[0]
+}
+
+func resolveRobloxTarget(path, baseDomain string) (host string, rewrittenPath string, err error) {
 	segments := strings.Split(path, "/")
 	if len(segments) < 2 || segments[1] == "" {
 		return "", "", errBadPath
 	}
 
+	// The segment becomes a label of the upstream host, so it must not be
+	// able to name a host outside baseDomain.
 	domain := segments[1]
+	if !upstream.ValidServiceName(domain) {
+		return "", "", errBadPath
+	}
 	remaining := strings.Join(segments[2:], "/")
 	if remaining == "" {
 		remaining = "/"
@@ -502,5 +1348,5 @@ func resolveRobloxTarget(path string) (host string, rewrittenPath string, err er
 		remaining = "/" + remaining
 	}
 
-	return domain + ".roblox.com", remaining, nil
+	return domain + "." + baseDomain, remaining, nil
 }
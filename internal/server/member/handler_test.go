@@ -0,0 +1,237 @@
+package member
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/util"
+)
+
+func TestProxyAnswers413ForOversizedBody(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_MAX_REQUEST_BODY_BYTES":   "16",
+		"PROXY_WRITE_ALLOWED_SUBDOMAINS": "games",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/games/v1/games", strings.NewReader(strings.Repeat("x", 17)))
+	rec := serve(h, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", rec.Code)
+	}
+	if n := upstream.total(); n != 0 {
+		t.Fatalf("upstream saw %d requests, want 0", n)
+	}
+}
+
+func TestProxyRelaysWritesToAllowedSubdomains(t *testing.T) {
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.Header().Set("X-Echo-Method", r.Method)
+		_, _ = w.Write(b)
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{"PROXY_WRITE_ALLOWED_SUBDOMAINS": "games"})
+
+	const payload = `{"universeIds":[1,2]}`
+	req := httptest.NewRequest(http.MethodPost, "/games/v1/games/multiget-playability-status", strings.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec := serve(h, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Echo-Method"); got != http.MethodPost {
+		t.Fatalf("upstream method = %q, want POST", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Fatalf("upstream Content-Type = %q, want it preserved", got)
+	}
+	if got := rec.Body.String(); got != payload {
+		t.Fatalf("upstream body = %q, want %q", got, payload)
+	}
+}
+
+func TestProxyRejectsWritesToOtherSubdomains(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{"PROXY_WRITE_ALLOWED_SUBDOMAINS": "games"})
+
+	req := httptest.NewRequest(http.MethodPost, "/users/v1/usernames/users", strings.NewReader(`{"usernames":["bob"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := serve(h, req)
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("status = %d, want 405", rec.Code)
+	}
+	if got := rec.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Fatalf("Allow = %q, want the read methods", got)
+	}
+	if n := upstream.total(); n != 0 {
+		t.Fatalf("upstream saw %d requests, want 0", n)
+	}
+}
+
+func TestTemplatedHeadersReachTheirTarget(t *testing.T) {
+	type received struct{ member, route, uri string }
+	var (
+		mu  sync.Mutex
+		got = make(map[string][]received)
+	)
+	record := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			got[name] = append(got[name], received{r.Header.Get("X-Member"), r.Header.Get("X-Route"), r.URL.RequestURI()})
+			mu.Unlock()
+			writeJSON(w, map[string]any{})
+		}
+	}
+	a, b := newFakeRoblox(t, record("a")), newFakeRoblox(t, record("b"))
+	urls := map[string]string{"a": a.URL, "b": b.URL}
+
+	const route = "{{.Target}}|{{.Path}}|{{.Query}}|{{.Hash}}"
+	templates, err := json.Marshal(map[string]map[string]string{
+		a.URL: {"X-Member": "a", "X-Route": route},
+		b.URL: {"X-Member": "b", "X-Route": route},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := newTestHandler(t, a.URL, map[string]string{
+		"PROXY_MEMBER_CLUSTERS":         a.URL + "," + b.URL,
+		"PROXY_MEMBER_HEADER_TEMPLATES": string(templates),
+	})
+
+	const requests = 20
+	for i := 0; i < requests; i++ {
+		target := "/games/v1/games/" + strconv.Itoa(i) + "?b=2&a=1"
+		if rec := get(h, target); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d, want 200", target, rec.Code)
+		}
+	}
+
+	total := 0
+	for name, reqs := range got {
+		for _, req := range reqs {
+			total++
+			path, query, _ := strings.Cut(req.uri, "?")
+			want := urls[name] + "|" + path + "|" + query + "|" + strconv.FormatUint(uint64(util.Hash(routingKey(path, query))), 10)
+			if req.member != name || req.route != want {
+				t.Errorf("target %s got X-Member %q, X-Route %q; want %q, %q", name, req.member, req.route, name, want)
+			}
+		}
+	}
+	if total != requests || len(got["a"]) == 0 || len(got["b"]) == 0 {
+		t.Fatalf("targets received a=%d b=%d requests, want %d spread over both", len(got["a"]), len(got["b"]), requests)
+	}
+}
+
+// renamingRoblox answers like robloxAPI but names user 1 after the number of
+// times it has been fetched, so each fetch is told apart from a cached one.
+func renamingRoblox() http.HandlerFunc {
+	var mu sync.Mutex
+	fetches := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fakeUserPath+"1" {
+			robloxAPI(w, r)
+			return
+		}
+		mu.Lock()
+		fetches++
+		name := "fetch" + strconv.Itoa(fetches)
+		mu.Unlock()
+		writeJSON(w, map[string]any{"id": 1, "name": name, "displayName": name, "created": "2020-01-01T00:00:00Z"})
+	}
+}
+
+func TestNoCacheBypassesReadButStillWrites(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		target string
+		header http.Header
+	}{
+		{"query parameter", "/?userId=1&nocache=1", nil},
+		{"cache-control header", "/?userId=1", http.Header{"Cache-Control": {"max-age=0, no-cache"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			upstream := newFakeRoblox(t, renamingRoblox())
+			h, _ := newTestHandler(t, upstream.URL, map[string]string{
+				"PROXY_DEBUG_ENDPOINTS": "true",
+				"PROXY_ADMIN_TOKEN":     "secret",
+			})
+
+			if b := body(t, get(h, "/?userId=1")); !strings.Contains(b, "fetch1") {
+				t.Fatalf("first lookup = %s, want fetch1", b)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			for k, vv := range tc.header {
+				req.Header[k] = vv
+			}
+			rec := serve(h, req)
+			if b := body(t, rec); rec.Code != http.StatusOK || !strings.Contains(b, "fetch2") {
+				t.Fatalf("bypassing lookup = %d %s, want a fresh fetch2", rec.Code, b)
+			}
+
+			// The fresh result replaced the cached entry.
+			if b := body(t, get(h, "/?userId=1")); !strings.Contains(b, "fetch2") {
+				t.Fatalf("later lookup = %s, want the refreshed fetch2", b)
+			}
+			if n := upstream.count(fakeUserPath + "1"); n != 2 {
+				t.Fatalf("user fetches = %d, want 2", n)
+			}
+		})
+	}
+}
+
+func TestNoCacheIgnoredWithoutDebugEndpoints(t *testing.T) {
+	upstream := newFakeRoblox(t, renamingRoblox())
+	h, _ := newTestHandler(t, upstream.URL, nil)
+
+	get(h, "/?userId=1")
+	if b := body(t, get(h, "/?userId=1&nocache=1")); !strings.Contains(b, "fetch1") {
+		t.Fatalf("lookup = %s, want the cached fetch1", b)
+	}
+	if n := upstream.count(fakeUserPath + "1"); n != 1 {
+		t.Fatalf("user fetches = %d, want 1", n)
+	}
+}
+
+func TestShardHeaderIsNotForwarded(t *testing.T) {
+	var mu sync.Mutex
+	var forwarded []string
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		forwarded = append(forwarded, r.Header.Values(headerProxyShard)...)
+		forwarded = append(forwarded, r.Header.Values("X-Internal")...)
+		mu.Unlock()
+		writeJSON(w, map[string]any{"ok": true})
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_DEBUG_ENDPOINTS":       "true",
+		"PROXY_ADMIN_TOKEN":           "secret",
+		"PROXY_STRIP_REQUEST_HEADERS": "X-Internal",
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/games/v1/games?universeIds=1", nil)
+	req.Header.Set(headerProxyShard, "0")
+	req.Header.Set("X-Internal", "1")
+	if rec := serve(h, req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, body(t, rec))
+	}
+	if upstream.total() != 1 {
+		t.Fatalf("upstream requests = %d, want 1", upstream.total())
+	}
+	if len(forwarded) != 0 {
+		t.Fatalf("upstream received stripped headers: %q", forwarded)
+	}
+	if req.Header.Get(headerProxyShard) != "0" {
+		t.Fatal("routing removed the shard header from the client request")
+	}
+}
@@ -0,0 +1,90 @@
+package member
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
+)
+
+// Health returns the background checker for the member targets, or nil when
+// health checks are disabled.
+func (h *Handler) Health() *upstream.HealthChecker {
+	return h.health
+}
+
+// reportTargetFailure marks the target at idx unhealthy when err shows the
+// target could not be reached. Checks are built one per target, so indexes
+// line up.
+func (h *Handler) reportTargetFailure(idx int, err error) {
+	if h.health == nil || idx < 0 || idx >= h.health.Len() || !isConnectError(err) {
+		return
+	}
+	h.health.ReportFailure(idx, err)
+}
+
+// isConnectError reports whether err came from establishing a connection,
+// either directly or through a proxy, rather than from an established one.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	switch opErr.Op {
+	case "dial", "proxyconnect", "socks connect":
+		return true
+	default:
+		return false
+	}
+}
+
+// healthChecks builds a probe for every member target using the probe settings
+// configured for its kind.
+func healthChecks(cfg config.Config, set *targetSet) ([]upstream.HealthCheck, error) {
+	checks := make([]upstream.HealthCheck, 0, len(set.targets))
+	for i, t := range set.targets {
+		var (
+			probe  config.HealthProbe
+			target *url.URL
+		)
+
+		switch t.Kind {
+		case upstream.MemberTargetDirect, upstream.MemberTargetSocks5:
+			probe = cfg.HealthDirect
+			ref, err := url.Parse(probe.Path)
+			if err != nil {
+				return nil, fmt.Errorf("parse direct health path: %w", err)
+			}
+			target, err = set.directURL(ref.Path, ref.RawQuery)
+			if err != nil {
+				return nil, fmt.Errorf("resolve direct health path: %w", err)
+			}
+		case upstream.MemberTargetStatic:
+			probe = cfg.HealthStatic
+			ref, err := url.Parse(probe.Path)
+			if err != nil {
+				return nil, fmt.Errorf("parse static health path: %w", err)
+			}
+			target = t.Base.ResolveReference(ref)
+		default:
+			continue
+		}
+
+		checks = append(checks, upstream.HealthCheck{
+			Name:   t.String(),
+			Kind:   t.Kind.String(),
+			URL:    target,
+			Client: set.clients[i],
+			Probe: upstream.HealthProbe{
+				Method:         probe.Method,
+				ExpectedStatus: probe.ExpectedStatus,
+				Interval:       probe.Interval,
+				Timeout:        cfg.RequestTimeout,
+			},
+		})
+	}
+	return checks, nil
+}
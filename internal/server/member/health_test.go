@@ -0,0 +1,27 @@
+package member
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestStaticHealthChecksUseConfiguredProbe(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_HEALTH_CHECKS_ENABLED": "true",
+		"PROXY_HEALTH_STATIC_PATH":    "/ready?deep=1",
+		"PROXY_HEALTH_STATIC_METHOD":  "head",
+		"PROXY_HEALTH_STATIC_STATUS":  "204",
+	})
+
+	if h.Health() == nil || h.Health().Len() != 1 {
+		t.Fatal("want one health check for the static target")
+	}
+	check := h.Health().Check(0)
+	if want := upstream.URL + "/ready?deep=1"; check.URL.String() != want {
+		t.Fatalf("probe URL = %s, want %s", check.URL, want)
+	}
+	if check.Probe.Method != http.MethodHead || check.Probe.ExpectedStatus != http.StatusNoContent {
+		t.Fatalf("probe = %s expecting %d, want HEAD expecting 204", check.Probe.Method, check.Probe.ExpectedStatus)
+	}
+}
@@ -0,0 +1,238 @@
+package member
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache/memorystore"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/transport"
+)
+
+// fakeRoblox stands in for Roblox behind a static member target. Requests
+// arrive as /{service}/{path} and are counted by path before being answered
+// by handle.
+type fakeRoblox struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func newFakeRoblox(t *testing.T, handle http.HandlerFunc) *fakeRoblox {
+	t.Helper()
+	f := &fakeRoblox{hits: make(map[string]int)}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.hits[r.URL.Path]++
+		f.mu.Unlock()
+		handle(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// count returns how many requests reached path.
+func (f *fakeRoblox) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits[path]
+}
+
+// total returns how many requests reached the upstream.
+func (f *fakeRoblox) total() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.hits {
+		n += c
+	}
+	return n
+}
+
+// Upstream paths of the lookups exercised by the tests.
+const (
+	fakeUserPath   = "/users/v1/users/"
+	fakeAvatarPath = "/thumbnails/v1/users/avatar-bust"
+	fakeSearchPath = "/apis/search-api/omni-search"
+)
+
+// robloxAPI answers user, avatar-bust and search lookups the way Roblox
+// does, deriving every user from its numeric ID.
+func robloxAPI(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, fakeUserPath):
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, fakeUserPath), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]any{
+			"id":          id,
+			"name":        "user" + strconv.FormatInt(id, 10),
+			"displayName": "User " + strconv.FormatInt(id, 10),
+			"description": "",
+			"created":     "2020-01-01T00:00:00Z",
+			"isBanned":    false,
+		})
+	case r.URL.Path == fakeAvatarPath:
+		var data []map[string]any
+		for _, id := range strings.Split(r.URL.Query().Get("userIds"), ",") {
+			n, _ := strconv.ParseInt(id, 10, 64)
+			data = append(data, map[string]any{"targetId": n, "imageUrl": "https://tr.rbxcdn.com/" + id + ".png"})
+		}
+		writeJSON(w, map[string]any{"data": data})
+	case r.URL.Path == fakeSearchPath:
+		writeJSON(w, map[string]any{"searchResults": []map[string]any{{
+			"contents": []map[string]any{{"contentId": 5, "username": "bob"}},
+		}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// newTestHandler builds a member handler whose only target is upstream, backed
+// by an in-memory cache. env sets further PROXY_* variables before the config
+// is loaded, overriding the defaults chosen here.
+func newTestHandler(t *testing.T, upstream string, env map[string]string) (*Handler, *memorystore.Store) {
+	t.Helper()
+	return newTestHandlerWithLogger(t, upstream, env, slog.New(slog.DiscardHandler))
+}
+
+func newTestHandlerWithLogger(t *testing.T, upstream string, env map[string]string, logger *slog.Logger) (*Handler, *memorystore.Store) {
+	t.Helper()
+	defaults := map[string]string{
+		"PROXY_ROLE":                  "member",
+		"PROXY_MEMBER_CLUSTERS":       upstream,
+		"PROXY_IN_MEMORY_CACHE_SIZE":  "1000",
+		"PROXY_HEALTH_CHECKS_ENABLED": "false",
+	}
+	for k, v := range defaults {
+		t.Setenv(k, v)
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	store := memorystore.New(cfg)
+	h, err := New(cfg, logger, store, transport.NewHTTPClient(cfg), &proxy.Tracker{})
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = h.Shutdown(ctx)
+		_ = store.Close()
+	})
+	return h, store
+}
+
+// serve runs req through h and returns the recorded response.
+func serve(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// get serves a GET of target, a path with optional query, through h.
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	return serve(h, httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+// body returns the recorded response body as a string.
+func body(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	b, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	return string(b)
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// logRecorder is a slog.Handler keeping every record it handles, with the
+// attributes of the logger it was reached through.
+type logRecorder struct {
+	mu      *sync.Mutex
+	records *[]loggedRecord
+	attrs   []slog.Attr
+}
+
+// loggedRecord is a handled record with its attributes flattened by key.
+type loggedRecord struct {
+	level   slog.Level
+	message string
+	attrs   map[string]slog.Value
+}
+
+func newLogRecorder() *logRecorder {
+	return &logRecorder{mu: new(sync.Mutex), records: new([]loggedRecord)}
+}
+
+func (l *logRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (l *logRecorder) Handle(_ context.Context, r slog.Record) error {
+	rec := loggedRecord{level: r.Level, message: r.Message, attrs: make(map[string]slog.Value)}
+	for _, a := range l.attrs {
+		rec.attrs[a.Key] = a.Value
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		rec.attrs[a.Key] = a.Value
+		return true
+	})
+	l.mu.Lock()
+	*l.records = append(*l.records, rec)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *logRecorder) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logRecorder{mu: l.mu, records: l.records, attrs: append(slices.Clip(l.attrs), attrs...)}
+}
+
+func (l *logRecorder) WithGroup(string) slog.Handler { return l }
+
+// find returns the records logged with message.
+func (l *logRecorder) find(message string) []loggedRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var found []loggedRecord
+	for _, r := range *l.records {
+		if r.message == message {
+			found = append(found, r)
+		}
+	}
+	return found
+}
@@ -0,0 +1,87 @@
+package member
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// Query parameters of the typed lookups served at the root path.
+const (
+	paramSearch       = "search"
+	paramUserID       = "userId"
+	paramSizes        = "sizes"
+	paramFields       = "fields"
+	paramLimit        = "limit"
+	paramEmbedAvatars = "embedAvatars"
+	paramNoCache      = "nocache"
+)
+
+// supportedParams lists the query parameters the typed lookups accept. The
+// handlers read them through the same constants, so adding a parameter there
+// without listing it here stands out.
+var supportedParams = []string{paramSearch, paramUserID, paramSizes, paramFields, paramLimit, paramEmbedAvatars, paramNoCache}
+
+// handleReserved answers requests to paths that must never be proxied, such as
+// the root path. Query parameters other than the typed lookups are rejected so
+// callers learn about typos instead of getting a confusing upstream failure.
+func (h *Handler) handleReserved(w http.ResponseWriter, r *http.Request) {
+	if unknown := unsupportedParams(r); len(unknown) > 0 {
+		body, err := json.Marshal(struct {
+			Error     string   `json:"error"`
+			Supported []string `json:"supported"`
+		}{
+			Error:     fmt.Sprintf("unsupported query parameters: %s", strings.Join(unknown, ", ")),
+			Supported: supportedParams,
+		})
+		if err != nil {
+			h.respondError(w, http.StatusInternalServerError, err)
+			return
+		}
+		h.respondJSON(w, http.StatusBadRequest, body)
+		return
+	}
+
+	body, err := json.Marshal(struct {
+		Service   string   `json:"service"`
+		Role      string   `json:"role"`
+		Status    string   `json:"status"`
+		Targets   int      `json:"targets"`
+		Supported []string `json:"supported"`
+	}{
+		Service:   "roblox-proxy-cluster",
+		Role:      string(config.RoleMember),
+		Status:    "ok",
+		Targets:   len(h.targets.Load().targets),
+		Supported: supportedParams,
+	})
+	if err != nil {
+		h.respondError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.respondJSON(w, http.StatusOK, body)
+}
+
+func unsupportedParams(r *http.Request) []string {
+	var unknown []string
+	for name := range r.URL.Query() {
+		if !isSupportedParam(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func isSupportedParam(name string) bool {
+	for _, p := range supportedParams {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+package member
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestRootAnswersStatusDocument(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandler(t, upstream.URL, nil)
+
+	rec := get(h, "/")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var doc struct {
+		Service   string   `json:"service"`
+		Role      string   `json:"role"`
+		Status    string   `json:"status"`
+		Targets   int      `json:"targets"`
+		Supported []string `json:"supported"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	if doc.Role != "member" || doc.Status != "ok" || doc.Targets != 1 || !slices.Equal(doc.Supported, supportedParams) {
+		t.Fatalf("status document = %+v", doc)
+	}
+	if n := upstream.total(); n != 0 {
+		t.Fatalf("upstream saw %d requests, want 0", n)
+	}
+}
+
+func TestRootRejectsUnsupportedParameters(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, _ := newTestHandler(t, upstream.URL, nil)
+
+	rec := get(h, "/?userid=1&pageSize=10&limit=10")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	var doc struct {
+		Error     string   `json:"error"`
+		Supported []string `json:"supported"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decode %s: %v", rec.Body, err)
+	}
+	if want := "unsupported query parameters: pageSize, userid"; doc.Error != want {
+		t.Fatalf("error = %q, want %q", doc.Error, want)
+	}
+	if !slices.Equal(doc.Supported, supportedParams) {
+		t.Fatalf("supported = %q, want %q", doc.Supported, supportedParams)
+	}
+	if n := upstream.total(); n != 0 {
+		t.Fatalf("upstream saw %d requests, want 0", n)
+	}
+}
+
+func TestSupportedParamsCoverLookupOptions(t *testing.T) {
+	for _, name := range []string{"search", "userId", "sizes", "fields", "limit", "embedAvatars", "nocache"} {
+		if !isSupportedParam(name) {
+			t.Errorf("%s is read by a lookup but not listed as supported", name)
+		}
+	}
+}
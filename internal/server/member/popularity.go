@@ -0,0 +1,45 @@
+package member
+
+import (
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/util"
+)
+
+// popularitySketchWidth is the number of counters per row of the access
+// frequency sketch.
+const popularitySketchWidth = 1 << 16
+
+// popularityTTL scales cache TTLs with how often a key is requested, so hot
+// entries persist while one-off lookups expire quickly. A nil popularityTTL
+// leaves TTLs unchanged.
+type popularityTTL struct {
+	sketch    *util.FrequencySketch
+	min       time.Duration
+	max       time.Duration
+	threshold int
+}
+
+func newPopularityTTL(cfg config.Config) *popularityTTL {
+	if cfg.CacheTTLMin <= 0 || cfg.CacheTTLMax <= 0 {
+		return nil
+	}
+	return &popularityTTL{
+		sketch:    util.NewFrequencySketch(popularitySketchWidth),
+		min:       cfg.CacheTTLMin,
+		max:       cfg.CacheTTLMax,
+		threshold: cfg.PopularityThreshold,
+	}
+}
+
+// observe records an access to key and returns the TTL to store it with. The
+// TTL grows linearly from min to max as the key's recent access count
+// approaches the popularity threshold.
+func (p *popularityTTL) observe(key string, fallback time.Duration) time.Duration {
+	if p == nil {
+		return fallback
+	}
+	count := min(p.sketch.Add(key), p.threshold)
+	return p.min + time.Duration(float64(p.max-p.min)*float64(count)/float64(p.threshold))
+}
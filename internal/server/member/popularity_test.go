@@ -0,0 +1,70 @@
+package member
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+func TestPopularityTTLFavoursHotKeys(t *testing.T) {
+	p := newPopularityTTL(config.Config{CacheTTLMin: time.Minute, CacheTTLMax: time.Hour, PopularityThreshold: 4})
+
+	var hot time.Duration
+	for i := 0; i < 10; i++ {
+		hot = p.observe("roblox:user:1", 0)
+	}
+	cold := p.observe("roblox:user:2", 0)
+
+	if hot != time.Hour {
+		t.Fatalf("hot TTL = %s, want the maximum %s", hot, time.Hour)
+	}
+	if want := time.Minute + (time.Hour-time.Minute)/4; cold != want {
+		t.Fatalf("cold TTL = %s, want %s", cold, want)
+	}
+}
+
+func TestPopularityTTLDisabledKeepsFallback(t *testing.T) {
+	p := newPopularityTTL(config.Config{})
+	if p != nil {
+		t.Fatal("popularity TTLs enabled without bounds")
+	}
+	if ttl := p.observe("roblox:user:1", 5*time.Minute); ttl != 5*time.Minute {
+		t.Fatalf("TTL = %s, want the fallback", ttl)
+	}
+}
+
+func TestHotLookupsAreStoredLonger(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	h, store := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_CACHE_TTL_MIN":            "1m",
+		"PROXY_CACHE_TTL_MAX":            "1h",
+		"PROXY_POPULARITY_THRESHOLD":     "4",
+		"PROXY_CACHE_TTL_JITTER":         "0",
+		"PROXY_BACKGROUND_REFRESH_AFTER": "30s",
+	})
+	ctx := context.Background()
+
+	// The hot key was requested often before this miss; the cold key is new.
+	for i := 0; i < 10; i++ {
+		h.popularity.observe("hot", 0)
+	}
+	for _, key := range []string{"hot", "cold"} {
+		if _, err := h.readThroughCache(ctx, opUser, key, func(context.Context) ([]byte, error) { return []byte(`{}`), nil }); err != nil {
+			t.Fatalf("lookup %s: %v", key, err)
+		}
+	}
+
+	ttls := make(map[string]time.Duration)
+	for _, key := range []string{"hot", "cold"} {
+		entry, ok, err := store.Get(ctx, key)
+		if err != nil || !ok {
+			t.Fatalf("no entry for %s: ok=%v err=%v", key, ok, err)
+		}
+		ttls[key] = entry.ExpiresAt.Sub(entry.StoredAt)
+	}
+	if ttls["hot"] <= ttls["cold"] {
+		t.Fatalf("hot TTL %s is not longer than cold TTL %s", ttls["hot"], ttls["cold"])
+	}
+}
@@ -0,0 +1,202 @@
+package member
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+)
+
+// opProxy identifies generic proxy responses cached under PROXY_CACHEABLE_PATHS.
+const opProxy operation = "proxy"
+
+// maxCachedProxyBytes bounds the upstream body buffered for a cacheable proxy
+// request.
+const maxCachedProxyBytes = 4 << 20
+
+var (
+	// errNotCacheable marks upstream answers that must reach the client as
+	// they are rather than be replaced by a stale entry.
+	errNotCacheable = errors.New("upstream response is not cacheable")
+
+	errProxyResponseTooLarge = errors.New("upstream response too large to cache")
+)
+
+// proxiedResponse is an upstream answer to a cacheable proxy request that is
+// relayed without being cached: a non-2xx status, or a response that forbids
+// storing.
+type proxiedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (e *proxiedResponse) Error() string {
+	return fmt.Sprintf("upstream answered %d", e.status)
+}
+
+// Unwrap lets rate-limit and server errors fall back to a stale entry, like any
+// other failed fetch; everything else is authoritative.
+func (e *proxiedResponse) Unwrap() error {
+	if e.status == http.StatusTooManyRequests || e.status >= 500 {
+		return nil
+	}
+	return errNotCacheable
+}
+
+// proxyCacheTTL returns the TTL of the first cache rule matching path.
+func (h *Handler) proxyCacheTTL(path string) (time.Duration, bool) {
+	for _, rule := range h.config().CacheablePaths {
+		if rule.Matches(path) {
+			return rule.TTL, true
+		}
+	}
+	return 0, false
+}
+
+func (h *Handler) proxyCacheKey(path, rawQuery string) string {
+	return h.cacheKey("proxy", routingKey(path, rawQuery))
+}
+
+// handleCachedProxy serves a GET for a cacheable path through the read-through
+// cache. Only the body and its validators are cached; other upstream headers
+// are not replayed.
+func (h *Handler) handleCachedProxy(w http.ResponseWriter, r *http.Request, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.lookupTimeout(proxy.ServiceOf(r.URL.Path)))
+	defer cancel()
+
+	key := h.proxyCacheKey(r.URL.Path, r.URL.RawQuery)
+	result, err := h.readThroughEntry(ctx, opProxy, key, ttl, h.proxyFetcher(r.URL.Path, r.URL.RawQuery))
+	if err != nil {
+		var resp *proxiedResponse
+		if errors.As(err, &resp) {
+			for name, values := range resp.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(resp.status)
+			_, _ = w.Write(resp.body)
+			return
+		}
+		h.logger.ErrorContext(ctx, "cached proxy request failed", slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+		h.respondLookupError(w, err)
+		return
+	}
+
+	if result.contentType != "" {
+		w.Header().Set(headerContentType, result.contentType)
+	}
+	setCacheHeaders(w.Header(), result)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(result.payload)
+}
+
+// proxyFetcher loads a cacheable proxy path, following the fallback chain like
+// any other fetch. The query is forwarded as the client sent it.
+func (h *Handler) proxyFetcher(path, rawQuery string) entryFetcher {
+	return func(ctx context.Context, prior *cache.Entry) (cache.Entry, error) {
+		routes, err := h.chooseTargets(path, rawQuery)
+		if err != nil {
+			return cache.Entry{}, err
+		}
+		for attempt, rt := range routes {
+			var entry cache.Entry
+			entry, err = h.fetchProxied(ctx, rt, path, prior)
+			if err == nil {
+				h.logServedBy(ctx, attempt, rt)
+				return entry, nil
+			}
+			if ctx.Err() != nil || !fallbackWorthy(err) {
+				break
+			}
+		}
+		return cache.Entry{}, err
+	}
+}
+
+// fetchProxied performs a single GET of a cacheable proxy path against rt.
+func (h *Handler) fetchProxied(ctx context.Context, rt route, path string, prior *cache.Entry) (cache.Entry, error) {
+	service := proxy.ServiceOf(path)
+	ctx, cancel := context.WithTimeout(ctx, h.forwarder.TimeoutFor(service))
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rt.url.String(), nil)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	h.prepareFetch(ctx, req, rt, service, "", prior)
+
+	if !h.forwarder.Tracker.Begin() {
+		return cache.Entry{}, proxy.ErrDraining
+	}
+	defer h.forwarder.Tracker.Done()
+
+	release, err := h.forwarder.Limiter.Acquire(ctx)
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	defer release()
+
+	resp, err := rt.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			h.reportTargetFailure(rt.index, err)
+		}
+		return cache.Entry{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && prior != nil {
+		return *prior, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedProxyBytes+1))
+	if err != nil {
+		return cache.Entry{}, err
+	}
+	if len(body) > maxCachedProxyBytes {
+		return cache.Entry{}, errProxyResponseTooLarge
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || noStore(resp.Header.Get("Cache-Control")) {
+		header := relayHeaders(resp.Header)
+		h.forwarder.ApplyResponseHeaderRules(header)
+		return cache.Entry{}, &proxiedResponse{status: resp.StatusCode, header: header, body: body}
+	}
+
+	return cache.Entry{
+		Payload:      body,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// noStore reports whether a Cache-Control value forbids a shared cache from
+// storing the response.
+func noStore(cacheControl string) bool {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if strings.EqualFold(name, "no-store") || strings.EqualFold(name, "private") {
+			return true
+		}
+	}
+	return false
+}
+
+// relayHeaders copies the headers of an uncached response that may be shared
+// with every client waiting on the same fetch: hop-by-hop headers, cookies and
+// the length, which the server recomputes, are dropped.
+func relayHeaders(src http.Header) http.Header {
+	dst := src.Clone()
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Trailer", "Upgrade", "Content-Length", "Set-Cookie", "X-Csrf-Token"} {
+		dst.Del(name)
+	}
+	return dst
+}
@@ -0,0 +1,135 @@
+package member
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/util"
+)
+
+func TestCachedProxyStripsConfiguredResponseHeaders(t *testing.T) {
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Roblox-Machine-Id", "machine-1")
+		w.Header().Set("X-Roblox-Edge", "edge-1")
+		w.Header().Set("X-Upstream-Note", "kept")
+		if r.URL.Path == "/games/v1/private" {
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, map[string]any{"private": true})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_CACHEABLE_PATHS": "/games/**=1m",
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/games/v1/missing", http.StatusNotFound},
+		{"/games/v1/private", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := get(h, tt.path)
+		if rec.Code != tt.want {
+			t.Fatalf("%s: status = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+		for _, name := range []string{"Roblox-Machine-Id", "X-Roblox-Edge"} {
+			if v := rec.Header().Get(name); v != "" {
+				t.Errorf("%s: %s = %q relayed, want it stripped", tt.path, name, v)
+			}
+		}
+		if v := rec.Header().Get("X-Upstream-Note"); v != "kept" {
+			t.Errorf("%s: X-Upstream-Note = %q, want %q", tt.path, v, "kept")
+		}
+	}
+}
+
+func TestCachedProxyKeyAgreesWithUpstreamQuery(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		queries []string
+	)
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		mu.Unlock()
+		writeJSON(w, map[string]any{"query": r.URL.RawQuery})
+	})
+	h, store := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_CACHEABLE_PATHS": "/games/**=1m",
+	})
+	const path = "/games/v1/games"
+
+	// The same parameters in another order share an entry, and the upstream
+	// is sent the query of the request that missed, as given.
+	for _, query := range []string{"universeIds=2&sort=asc&universeIds=1", "sort=asc&universeIds=2&universeIds=1"} {
+		if rec := get(h, path+"?"+query); rec.Code != http.StatusOK {
+			t.Fatalf("?%s: status = %d, want 200", query, rec.Code)
+		}
+	}
+	// Reordering a repeated key changes the request, so it is its own entry.
+	if rec := get(h, path+"?universeIds=1&universeIds=2&sort=asc"); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	want := []string{"universeIds=2&sort=asc&universeIds=1", "universeIds=1&universeIds=2&sort=asc"}
+	if strings.Join(queries, " ") != strings.Join(want, " ") {
+		t.Fatalf("upstream queries = %q, want %q", queries, want)
+	}
+	for _, query := range queries {
+		key := h.proxyCacheKey(path, query)
+		if !strings.HasSuffix(key, path+"?"+util.CanonicalQuery(query)) {
+			t.Errorf("cache key %q does not end in the canonical upstream query %q", key, util.CanonicalQuery(query))
+		}
+		entry, ok, err := store.Get(context.Background(), key)
+		if err != nil || !ok {
+			t.Fatalf("no entry under %q: ok=%v err=%v", key, ok, err)
+		}
+		var cached struct{ Query string }
+		if err := json.Unmarshal(entry.Payload, &cached); err != nil || cached.Query != query {
+			t.Errorf("entry under %q = %s, want the response to ?%s", key, entry.Payload, query)
+		}
+	}
+}
+
+func TestShardHeaderBypassesCacheOnlyWithDebugEndpoints(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantFetch int
+	}{
+		{name: "debug endpoints off", env: nil, wantFetch: 1},
+		{name: "debug endpoints on", env: map[string]string{"PROXY_DEBUG_ENDPOINTS": "true", "PROXY_ADMIN_TOKEN": "secret"}, wantFetch: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, map[string]any{"ok": true})
+			})
+			env := map[string]string{"PROXY_CACHEABLE_PATHS": "/games/**=1m"}
+			maps.Copy(env, tt.env)
+			h, _ := newTestHandler(t, upstream.URL, env)
+			const path = "/games/v1/games?universeIds=1"
+
+			if rec := get(h, path); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set(headerProxyShard, "0")
+			if rec := serve(h, req); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rec.Code)
+			}
+			if n := upstream.total(); n != tt.wantFetch {
+				t.Fatalf("upstream requests = %d, want %d", n, tt.wantFetch)
+			}
+		})
+	}
+}
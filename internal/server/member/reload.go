@@ -0,0 +1,127 @@
+package member
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/transport"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/util"
+)
+
+// targetSet is a member target list with the state derived from it. It is
+// replaced as a whole so requests never see a ring built for other targets.
+type targetSet struct {
+	targets []upstream.MemberTarget
+	// clients holds, per target, a dedicated client for targets with their own
+	// transport, such as SOCKS5 proxies. Entries are nil for the shared client.
+	clients []*http.Client
+	ring    *util.HashRing
+	// direct renders the URLs of direct and SOCKS5 targets. Nil sends them
+	// to <service>.<baseDomain>.
+	direct     *upstream.DirectURLTemplate
+	baseDomain string
+}
+
+func newTargetSet(cfg config.Config) (*targetSet, error) {
+	targets, err := upstream.ParseMemberTargets(cfg.MemberClusters)
+	if err != nil {
+		return nil, err
+	}
+	if err := upstream.AttachHeaderTemplates(targets, cfg.MemberHeaderTemplates); err != nil {
+		return nil, err
+	}
+	var direct *upstream.DirectURLTemplate
+	if cfg.DirectTargetTemplate != "" {
+		if direct, err = upstream.ParseDirectURLTemplate(cfg.DirectTargetTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	nodes := make([]string, len(targets))
+	weights := make([]int, len(targets))
+	clients := make([]*http.Client, len(targets))
+	for i, t := range targets {
+		nodes[i] = t.String()
+		weights[i] = t.Weight
+		if t.Kind == upstream.MemberTargetSocks5 {
+			clients[i] = transport.NewProxiedHTTPClient(cfg, t.Base)
+		}
+	}
+
+	return &targetSet{
+		targets:    targets,
+		clients:    clients,
+		ring:       util.NewWeightedHashRing(nodes, weights, hashRingReplicas),
+		direct:     direct,
+		baseDomain: cfg.RobloxBaseDomain,
+	}, nil
+}
+
+// directURL returns the upstream URL of path and rawQuery for direct and
+// SOCKS5 targets. rawQuery is set verbatim and must not be re-encoded.
+func (s *targetSet) directURL(path, rawQuery string) (*url.URL, error) {
+	host, rewritten, err := resolveRobloxTarget(path, s.baseDomain)
+	if err != nil {
+		return nil, err
+	}
+	if s.direct == nil {
+		return &url.URL{Scheme: "https", Host: host, Path: rewritten, RawQuery: rawQuery}, nil
+	}
+	u, err := s.direct.Resolve(robloxSubdomain(path), rewritten, rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBadPath, err)
+	}
+	return u, nil
+}
+
+// config returns the configuration currently in effect.
+func (h *Handler) config() *config.Config {
+	return h.cfg.Load()
+}
+
+// Reload applies a configuration produced by config.Reload. Cache TTLs take
+// effect for entries stored from now on, and a changed target list replaces
+// the targets, hash ring and health checks together. Nothing is changed when
+// the new targets cannot be built.
+func (h *Handler) Reload(cfg config.Config) error {
+	current := h.config()
+	targetsChanged := !slices.Equal(current.MemberClusters, cfg.MemberClusters) ||
+		!maps.EqualFunc(current.MemberHeaderTemplates, cfg.MemberHeaderTemplates, maps.Equal) ||
+		current.DirectTargetTemplate != cfg.DirectTargetTemplate
+
+	var (
+		set    *targetSet
+		checks []upstream.HealthCheck
+		err    error
+	)
+	if targetsChanged {
+		set, err = newTargetSet(cfg)
+		if err != nil {
+			return err
+		}
+		if h.health != nil {
+			checks, err = healthChecks(cfg, set)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	h.cfg.Store(&cfg)
+	h.forwarder.SetRequestHeaderRules(stripRequestHeaders(cfg), cfg.AddRequestHeaders)
+	h.forwarder.SetResponseHeaderRules(cfg.StripResponseHeaders)
+	if targetsChanged {
+		h.targets.Store(set)
+		if h.health != nil {
+			h.health.Replace(checks)
+		}
+		h.logger.Info("member targets reloaded", slog.Int("targets", len(set.targets)))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package member
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// maxReplayNameBytes keeps recording file names under common filesystem
+// limits; longer names are replaced by their hash.
+const maxReplayNameBytes = 200
+
+// errReplayMiss is returned in replay mode for a fetch nothing was recorded for.
+var errReplayMiss = errors.New("no recorded upstream response")
+
+// replayStore serves internal upstream fetches from files under dir instead
+// of calling Roblox, or records successful responses there, for local
+// development and end-to-end tests. Generic proxy requests are unaffected.
+type replayStore struct {
+	mode string
+	dir  string
+}
+
+// newReplayStore returns the store for cfg, or nil when replay is off.
+func newReplayStore(cfg config.Config) *replayStore {
+	if cfg.UpstreamReplayMode == config.UpstreamReplayOff {
+		return nil
+	}
+	return &replayStore{mode: cfg.UpstreamReplayMode, dir: cfg.UpstreamReplayDir}
+}
+
+func (s *replayStore) replaying() bool { return s != nil && s.mode == config.UpstreamReplayReplay }
+
+func (s *replayStore) recording() bool { return s != nil && s.mode == config.UpstreamReplayRecord }
+
+// file returns where the response to a fetch is kept: a directory per service
+// holding one file per method, path and query. POST bodies are told apart by
+// their hash.
+func (s *replayStore) file(method, service, basePath, rawQuery string, body []byte) string {
+	rest := strings.TrimPrefix(basePath, "/"+service)
+	name := method + " " + rest
+	if rawQuery != "" {
+		name += "?" + rawQuery
+	}
+	if len(body) > 0 {
+		digest := sha256.Sum256(body)
+		name += " " + hex.EncodeToString(digest[:6])
+	}
+	name = url.PathEscape(name)
+	if len(name) > maxReplayNameBytes {
+		digest := sha256.Sum256([]byte(name))
+		name = hex.EncodeToString(digest[:16])
+	}
+	return filepath.Join(s.dir, url.PathEscape(service), name+".json")
+}
+
+// load returns the recorded response body for a fetch.
+func (s *replayStore) load(method, service, basePath, rawQuery string, body []byte) ([]byte, error) {
+	file := s.file(method, service, basePath, rawQuery, body)
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w for %s %s", errReplayMiss, method, basePath)
+	}
+	return data, err
+}
+
+// save records the response body of a successful fetch, replacing any earlier
+// recording.
+func (s *replayStore) save(method, service, basePath, rawQuery string, body, response []byte) error {
+	file := s.file(method, service, basePath, rawQuery, body)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".record-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(response); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
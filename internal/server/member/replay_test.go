@@ -0,0 +1,77 @@
+package member
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newReplayHandler builds a handler replaying the fixtures in
+// testdata/replay, with an upstream that fails the test if it is called.
+func newReplayHandler(t *testing.T) (*Handler, *fakeRoblox) {
+	t.Helper()
+	upstream := newFakeRoblox(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("replay mode called the upstream for %s", r.URL)
+		http.Error(w, "unexpected", http.StatusInternalServerError)
+	})
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_UPSTREAM_REPLAY_MODE":        "replay",
+		"PROXY_UPSTREAM_REPLAY_DIR":         "testdata/replay",
+		"PROXY_UPSTREAM_REPLAY_ACKNOWLEDGE": "not-production",
+	})
+	return h, upstream
+}
+
+func TestReplayServesRecordedUser(t *testing.T) {
+	h, upstream := newReplayHandler(t)
+
+	rec := get(h, "/?userId=1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, body(t, rec))
+	}
+	var user map[string]any
+	if err := json.Unmarshal([]byte(body(t, rec)), &user); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for field, want := range map[string]any{
+		"name":      "Roblox",
+		"created":   "2006-02-27T21:06:40.3Z",
+		"avatarUrl": "https://tr.rbxcdn.com/30DAY-AvatarBust-1-48x48.png",
+	} {
+		if user[field] != want {
+			t.Errorf("%s = %v, want %v", field, user[field], want)
+		}
+	}
+	if n := upstream.total(); n != 0 {
+		t.Fatalf("upstream requests = %d, want 0", n)
+	}
+}
+
+func TestReplayServesRecordedSearch(t *testing.T) {
+	h, _ := newReplayHandler(t)
+
+	rec := get(h, "/?search=bob")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, body(t, rec))
+	}
+	var results []map[string]any
+	if err := json.Unmarshal([]byte(body(t, rec)), &results); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(results) != 1 || results[0]["name"] != "bob" || results[0]["avatarUrl"] != "https://tr.rbxcdn.com/30DAY-AvatarBust-5-420x420.png" {
+		t.Fatalf("results = %v, want bob with the recorded avatar", results)
+	}
+}
+
+func TestReplayFailsUnrecordedLookups(t *testing.T) {
+	h, upstream := newReplayHandler(t)
+
+	rec := get(h, "/?userId=2")
+	if b := body(t, rec); rec.Code != http.StatusInternalServerError || !strings.Contains(b, "no recorded upstream response") {
+		t.Fatalf("unrecorded user = %d %s, want 500 naming the missing recording", rec.Code, b)
+	}
+	if n := upstream.total(); n != 0 {
+		t.Fatalf("upstream requests = %d, want 0", n)
+	}
+}
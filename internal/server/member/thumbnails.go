@@ -0,0 +1,64 @@
+package member
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/sync/semaphore"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/ratelimit"
+)
+
+// thumbnailsService is the Roblox service that hosts avatar thumbnails.
+const thumbnailsService = "thumbnails"
+
+// errThumbnailsSaturated is returned when the thumbnails limiter has no
+// capacity for another upstream call.
+var errThumbnailsSaturated = errors.New("thumbnails upstream limit reached")
+
+// thumbnailLimiter bounds concurrency and rate of calls to the thumbnails
+// service independently of other Roblox services. A nil limiter allows all
+// calls.
+type thumbnailLimiter struct {
+	sem    *semaphore.Weighted
+	bucket *ratelimit.Bucket
+}
+
+func newThumbnailLimiter(cfg config.Config) *thumbnailLimiter {
+	if cfg.ThumbnailConcurrency <= 0 && cfg.ThumbnailRatePerSecond <= 0 {
+		return nil
+	}
+	l := &thumbnailLimiter{}
+	if cfg.ThumbnailConcurrency > 0 {
+		l.sem = semaphore.NewWeighted(int64(cfg.ThumbnailConcurrency))
+	}
+	if cfg.ThumbnailRatePerSecond > 0 {
+		l.bucket = ratelimit.NewBucket(cfg.ThumbnailRatePerSecond, cfg.ThumbnailBurst)
+	}
+	return l
+}
+
+// acquire reserves capacity for one call without blocking. The returned
+// release func must be called when the call completes.
+func (l *thumbnailLimiter) acquire() (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.sem != nil && !l.sem.TryAcquire(1) {
+		return nil, errThumbnailsSaturated
+	}
+	if l.bucket != nil {
+		if ok, _ := l.bucket.Allow(time.Now()); !ok {
+			if l.sem != nil {
+				l.sem.Release(1)
+			}
+			return nil, errThumbnailsSaturated
+		}
+	}
+	return func() {
+		if l.sem != nil {
+			l.sem.Release(1)
+		}
+	}, nil
+}
@@ -0,0 +1,65 @@
+package member
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+func TestThumbnailLimiterBoundsConcurrency(t *testing.T) {
+	l := newThumbnailLimiter(config.Config{ThumbnailConcurrency: 1})
+
+	release, err := l.acquire()
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	if _, err := l.acquire(); !errors.Is(err, errThumbnailsSaturated) {
+		t.Fatalf("second acquire error = %v, want errThumbnailsSaturated", err)
+	}
+	release()
+	if _, err := l.acquire(); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+}
+
+func TestThumbnailLimitLeavesOtherServicesAlone(t *testing.T) {
+	upstream := newFakeRoblox(t, robloxAPI)
+	// A single token that never refills in the test: one thumbnails call.
+	h, _ := newTestHandler(t, upstream.URL, map[string]string{
+		"PROXY_THUMBNAIL_RATE_PER_SECOND": "0.001",
+		"PROXY_THUMBNAIL_BURST":           "1",
+	})
+
+	var avatars []string
+	for _, id := range []string{"1", "2", "3"} {
+		rec := get(h, "/?userId="+id)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("user %s status = %d, want 200", id, rec.Code)
+		}
+		var user struct {
+			AvatarURL string `json:"avatarUrl"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+			t.Fatalf("decode user %s: %v", id, err)
+		}
+		avatars = append(avatars, user.AvatarURL)
+	}
+
+	if n := upstream.count(fakeAvatarPath); n != 1 {
+		t.Fatalf("thumbnails calls = %d, want 1", n)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if n := upstream.count(fakeUserPath + id); n != 1 {
+			t.Fatalf("users calls for %s = %d, want 1", id, n)
+		}
+	}
+	if avatars[0] == "" || avatars[1] != "" || avatars[2] != "" {
+		t.Fatalf("avatar URLs = %q, want only the first user's", avatars)
+	}
+	if rec := get(h, "/?search=bob"); rec.Code != http.StatusOK {
+		t.Fatalf("search status = %d, want 200", rec.Code)
+	}
+}
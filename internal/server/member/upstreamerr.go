@@ -0,0 +1,120 @@
+package member
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+)
+
+// maxErrorBodyBytes bounds the upstream error body read for its Roblox error.
+const maxErrorBodyBytes = 16 << 10
+
+// upstreamStatusError reports a non-2xx response from Roblox. For rate-limit
+// responses it carries Roblox's back-off hints so they can be relayed.
+type upstreamStatusError struct {
+	status     string
+	statusCode int
+	// rateLimit holds Retry-After and X-RateLimit-* headers from the response.
+	rateLimit http.Header
+	// roblox is the first error in the response body, nil when the body was
+	// not a Roblox error.
+	roblox *apierror.UpstreamError
+}
+
+// robloxErrorBody is the shape of Roblox API error responses.
+type robloxErrorBody struct {
+	Errors []struct {
+		Code    json.RawMessage `json:"code"`
+		Message string          `json:"message"`
+	} `json:"errors"`
+}
+
+func newUpstreamStatusError(resp *http.Response) *upstreamStatusError {
+	err := &upstreamStatusError{status: resp.Status, statusCode: resp.StatusCode}
+	for name, values := range resp.Header {
+		if name == "Retry-After" || strings.HasPrefix(name, "X-Ratelimit-") {
+			if err.rateLimit == nil {
+				err.rateLimit = make(http.Header)
+			}
+			err.rateLimit[name] = values
+		}
+	}
+	err.roblox = parseRobloxError(resp.Body)
+	return err
+}
+
+// parseRobloxError returns the first error of a Roblox error body. Bodies of
+// any other shape, such as HTML from an edge proxy, yield nil.
+func parseRobloxError(body io.Reader) *apierror.UpstreamError {
+	raw, err := io.ReadAll(io.LimitReader(body, maxErrorBodyBytes))
+	if err != nil || len(raw) == 0 {
+		return nil
+	}
+	var parsed robloxErrorBody
+	if json.Unmarshal(raw, &parsed) != nil || len(parsed.Errors) == 0 {
+		return nil
+	}
+	first := parsed.Errors[0]
+	// Codes are numbers, but a string or missing code must not hide the message.
+	var code int
+	_ = json.Unmarshal(first.Code, &code)
+	message := strings.TrimSpace(first.Message)
+	if code == 0 && message == "" {
+		return nil
+	}
+	return &apierror.UpstreamError{Code: code, Message: message}
+}
+
+func (e *upstreamStatusError) Error() string {
+	msg := "roblox request failed: " + e.status
+	if e.roblox == nil {
+		return msg
+	}
+	if e.roblox.Message != "" {
+		msg += ": " + e.roblox.Message
+	}
+	if e.roblox.Code != 0 {
+		msg += fmt.Sprintf(" (code %d)", e.roblox.Code)
+	}
+	return msg
+}
+
+// UpstreamError exposes the Roblox error to apierror's structured responses.
+func (e *upstreamStatusError) UpstreamError() *apierror.UpstreamError {
+	return e.roblox
+}
+
+// rateLimited reports whether err is a 429 from Roblox and returns it if so.
+func rateLimited(err error) (*upstreamStatusError, bool) {
+	var statusErr *upstreamStatusError
+	if errors.As(err, &statusErr) && statusErr.statusCode == http.StatusTooManyRequests {
+		return statusErr, true
+	}
+	return nil, false
+}
+
+// respondLookupError writes the error for a failed cached lookup. A Roblox 429
+// is relayed as a 429 with its rate-limit headers so callers can back off.
+func (h *Handler) respondLookupError(w http.ResponseWriter, err error) {
+	if statusErr, ok := rateLimited(err); ok {
+		for name, values := range statusErr.rateLimit {
+			w.Header()[name] = values
+		}
+	}
+	apierror.SetRetryAfter(w, err)
+	status := lookupErrorStatus(err)
+	apierror.WriteCode(w, status, lookupErrorCode(status, err), err)
+}
+
+// lookupErrorCode classifies a failed cached lookup reported with status.
+func lookupErrorCode(status int, err error) apierror.Code {
+	if errors.Is(err, errFetchOverloaded) || errors.Is(err, errThumbnailsSaturated) {
+		return apierror.CodeUnavailable
+	}
+	return apierror.Classify(status, err)
+}
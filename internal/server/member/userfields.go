@@ -0,0 +1,59 @@
+package member
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// userPayloadFields are the top-level fields of userPayload, in the order
+// they are encoded.
+var userPayloadFields = []string{"description", "created", "isBanned", "id", "name", "displayName", "avatarUrl", "avatarUrls", "avatarUnavailable"}
+
+// parseUserFields parses the comma-separated fields a user lookup is limited
+// to. An empty list yields nil, for the whole payload; an unknown field is an
+// error.
+func parseUserFields(raw string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if !slices.Contains(userPayloadFields, field) {
+			return nil, fmt.Errorf("unknown user field %q", field)
+		}
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields, nil
+}
+
+// filterUserPayload returns payload with only the top-level fields listed,
+// kept in their usual order. Fields the payload omits stay omitted.
+func filterUserPayload(payload []byte, fields []string) ([]byte, error) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &all); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for _, field := range userPayloadFields {
+		value, ok := all[field]
+		if !ok || !slices.Contains(fields, field) {
+			continue
+		}
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		// Field names are plain identifiers and need no escaping.
+		out.WriteString(`"` + field + `":`)
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
@@ -0,0 +1,89 @@
+package member
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// warmupProgressEvery controls how often warmup progress is logged.
+const warmupProgressEvery = 500
+
+// loadWarmupIDs reads user IDs from path, one per line. Blank lines and lines
+// starting with # are ignored.
+func loadWarmupIDs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open warmup file: %w", err)
+	}
+	defer f.Close()
+
+	var ids []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" || strings.HasPrefix(id, "#") {
+			continue
+		}
+		if !isNumeric(id) {
+			return nil, fmt.Errorf("warmup file %s:%d: invalid userId %q", path, line, id)
+		}
+		ids = append(ids, id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read warmup file: %w", err)
+	}
+	return ids, nil
+}
+
+// Warmup seeds the user cache with the configured warmup IDs. Lookups go
+// through the read-through cache, so entries that are already fresh are skipped
+// and live traffic for the same user joins the in-flight fetch.
+func (h *Handler) Warmup(ctx context.Context) {
+	if len(h.warmupIDs) == 0 {
+		return
+	}
+
+	start := time.Now()
+	h.logger.Info("cache warmup starting", slog.Int("users", len(h.warmupIDs)), slog.Int("concurrency", h.config().WarmupConcurrency))
+
+	var done, failed atomic.Int64
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(h.config().WarmupConcurrency)
+
+	for _, userID := range h.warmupIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			lookupCtx, cancel := context.WithTimeout(ctx, h.lookupTimeout(usersService, thumbnailsService))
+			defer cancel()
+
+			_, err := h.readThroughCache(lookupCtx, opUser, h.userCacheKey(userID), func(ctx context.Context) ([]byte, error) {
+				return h.fetchUserPayload(ctx, userID, nil)
+			})
+			if err != nil {
+				failed.Add(1)
+				h.logger.Warn("cache warmup failed", slog.String("userId", userID), slog.String("error", err.Error()))
+			}
+			if n := done.Add(1); n%warmupProgressEvery == 0 {
+				h.logger.Info("cache warmup progress", slog.Int64("done", n), slog.Int("total", len(h.warmupIDs)))
+			}
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	h.logger.Info("cache warmup finished",
+		slog.Int64("done", done.Load()),
+		slog.Int64("failed", failed.Load()),
+		slog.Duration("duration", time.Since(start)),
+	)
+}
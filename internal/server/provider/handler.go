@@ -1,14 +1,17 @@
 package provider
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand"
 	"net/http"
 	"net/url"
-	"strings"
+	"slices"
+	"sync/atomic"
 
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
 )
@@ -24,27 +27,115 @@ type Handler struct {
 	cfg       config.Config
 	logger    *slog.Logger
 	forwarder *proxy.Forwarder
-	upstreams []*url.URL
+	// pool holds the current upstreams; Reload swaps it.
+	pool   atomic.Pointer[upstream.Pool]
+	health *upstream.HealthChecker
 }
 
+var errNoProviderUpstream = errors.New("no provider upstreams configured")
+
 // New constructs a provider handler.
-func New(cfg config.Config, logger *slog.Logger, client *http.Client) (*Handler, error) {
+func New(cfg config.Config, logger *slog.Logger, client *http.Client, tracker *proxy.Tracker) (*Handler, error) {
 	upstreams, err := upstream.ParseProviderTargets(cfg.ProviderClusters)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Handler{
+	var health *upstream.HealthChecker
+	if cfg.HealthChecksEnabled {
+		checks, err := healthChecks(cfg, upstreams)
+		if err != nil {
+			return nil, err
+		}
+		health = upstream.NewHealthChecker(client, logger, checks)
+	}
+
+	limiter := proxy.NewUpstreamLimiter(cfg.MaxConcurrentUpstream, cfg.UpstreamQueueTimeout)
+	metrics.Gauge("upstream_requests_in_flight", limiter.InFlight)
+
+	h := &Handler{
 		cfg:    cfg,
 		logger: logger.With(slog.String("component", "provider-handler")),
 		forwarder: &proxy.Forwarder{
-			Client:            client,
-			Logger:            logger,
-			RequestTimeout:    cfg.RequestTimeout,
-			DiscordWebhookURL: cfg.DiscordWebhookURL,
+			Client:               client,
+			Logger:               logger,
+			RequestTimeout:       cfg.RequestTimeout,
+			DiscordWebhookURL:    cfg.DiscordWebhookURL,
+			MaxRequestBodyBytes:  cfg.MaxRequestBodyBytes,
+			Tracker:              tracker,
+			StripRequestHeaders:  cfg.StripRequestHeaders,
+			AddRequestHeaders:    cfg.AddRequestHeaders,
+			StripResponseHeaders: cfg.StripResponseHeaders,
+			Limiter:              limiter,
+			Buffers:              proxy.NewBufferPool(cfg.CopyBufferBytes),
+			FlushBytes:           cfg.StreamFlushBytes,
+			ServiceTimeouts:      cfg.ServiceTimeouts,
+			StreamingPaths:       cfg.StreamingPaths,
+			ForwardedHeaders:     cfg.ForwardedHeaders,
 		},
-		upstreams: upstreams,
-	}, nil
+		health: health,
+	}
+	h.pool.Store(upstream.NewPool(upstreams))
+	return h, nil
+}
+
+// healthChecks builds a probe for every provider upstream.
+func healthChecks(cfg config.Config, upstreams []*url.URL) ([]upstream.HealthCheck, error) {
+	ref, err := url.Parse(cfg.HealthProvider.Path)
+	if err != nil {
+		return nil, fmt.Errorf("parse provider health path: %w", err)
+	}
+	checks := make([]upstream.HealthCheck, len(upstreams))
+	for i, u := range upstreams {
+		checks[i] = upstream.HealthCheck{
+			Name: u.String(),
+			Kind: "provider",
+			URL:  u.ResolveReference(ref),
+			Probe: upstream.HealthProbe{
+				Method:         cfg.HealthProvider.Method,
+				ExpectedStatus: cfg.HealthProvider.ExpectedStatus,
+				Interval:       cfg.HealthProvider.Interval,
+				Timeout:        cfg.RequestTimeout,
+			},
+		}
+	}
+	return checks, nil
+}
+
+// Reload applies a configuration produced by config.Reload. A changed
+// upstream list replaces the pool and health checks together; nothing is
+// changed when the new upstreams are invalid. Reload must not be called
+// concurrently with itself.
+func (h *Handler) Reload(cfg config.Config) error {
+	if !slices.Equal(h.cfg.ProviderClusters, cfg.ProviderClusters) {
+		upstreams, err := upstream.ParseProviderTargets(cfg.ProviderClusters)
+		if err != nil {
+			return err
+		}
+		var checks []upstream.HealthCheck
+		if h.health != nil {
+			if checks, err = healthChecks(cfg, upstreams); err != nil {
+				return err
+			}
+		}
+
+		h.pool.Store(upstream.NewPool(upstreams))
+		if h.health != nil {
+			h.health.Replace(checks)
+		}
+		h.logger.Info("provider upstreams reloaded", slog.Int("upstreams", len(upstreams)))
+	}
+
+	h.cfg = cfg
+	h.forwarder.SetRequestHeaderRules(cfg.StripRequestHeaders, cfg.AddRequestHeaders)
+	h.forwarder.SetResponseHeaderRules(cfg.StripResponseHeaders)
+	return nil
+}
+
+// Health returns the background checker for the provider upstreams, or nil when
+// health checks are disabled.
+func (h *Handler) Health() *upstream.HealthChecker {
+	return h.health
 }
 
 // ServeHTTP implements http.Handler.
@@ -55,39 +146,31 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.forwarder.Do(w, r, target); err != nil {
+	if err := h.forwarder.Do(w, r, target, nil); err != nil {
+		if errors.Is(err, proxy.ErrRequestBodyTooLarge) {
+			h.respondError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		if errors.Is(err, proxy.ErrDraining) || errors.Is(err, proxy.ErrUpstreamSaturated) {
+			h.respondError(w, http.StatusServiceUnavailable, err)
+			return
+		}
 		h.logger.Error("provider forward failed", slog.String("target", target.Host), slog.String("path", r.URL.Path), slog.String("error", err.Error()))
 		h.respondError(w, http.StatusBadGateway, err)
 	}
 }
 
 func (h *Handler) pickTarget(r *http.Request) (*url.URL, error) {
-	if len(h.upstreams) == 0 {
-		return nil, fmt.Errorf("no provider upstreams configured")
+	base, ok := h.pool.Load().Next()
+	if !ok {
+		return nil, errNoProviderUpstream
 	}
 
-	key := r.URL.Path
-	if r.URL.RawQuery != "" {
-		key += "?" + r.URL.RawQuery
-	}
-
-	idx := rand.Intn(len(h.upstreams))
-	base := h.upstreams[idx]
+	// The raw query is kept as sent; re-encoding it would reorder parameters.
 	rel := &url.URL{Path: r.URL.Path, RawQuery: r.URL.RawQuery}
 	return base.ResolveReference(rel), nil
 }
 
 func (h *Handler) respondError(w http.ResponseWriter, status int, err error) {
-	msg := fmt.Sprintf(`{"error":"%s"}`, sanitize(err))
-	w.Header().Set(headerContentType, contentTypeJSON)
-	w.Header().Set(headerAccessControlAllowOrigin, "*")
-	w.WriteHeader(status)
-	_, _ = w.Write([]byte(msg))
-}
-
-func sanitize(err error) string {
-	if err == nil {
-		return ""
-	}
-	return strings.ReplaceAll(err.Error(), "\"", "'")
+	apierror.WriteError(w, status, err)
 }
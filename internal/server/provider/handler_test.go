@@ -0,0 +1,84 @@
+package provider
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/transport"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
+)
+
+// newNamedUpstream starts a member stand-in that answers every request with
+// name followed by the request URI it received.
+func newNamedUpstream(t *testing.T, name string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, name+" "+r.URL.RequestURI())
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// newTestHandler returns a provider handler in front of clusters.
+func newTestHandler(t *testing.T, clusters ...string) *Handler {
+	t.Helper()
+	t.Setenv("PROXY_ROLE", "provider")
+	t.Setenv("PROXY_PROVIDER_CLUSTERS", strings.Join(clusters, ","))
+	t.Setenv("PROXY_IN_MEMORY_CACHE_SIZE", "1000")
+	t.Setenv("PROXY_HEALTH_CHECKS_ENABLED", "false")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	h, err := New(cfg, slog.New(slog.DiscardHandler), transport.NewHTTPClient(cfg), &proxy.Tracker{})
+	if err != nil {
+		t.Fatalf("new handler: %v", err)
+	}
+	return h
+}
+
+func TestProviderRotatesAcrossUpstreams(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	clusters := make([]string, len(names))
+	for i, name := range names {
+		clusters[i] = newNamedUpstream(t, name)
+	}
+	h := newTestHandler(t, clusters...)
+
+	for i := 0; i < 2*len(names); i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/v1/users/1?b=2&a=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i, rec.Code)
+		}
+		want := names[i%len(names)] + " /users/v1/users/1?b=2&a=1"
+		if got := rec.Body.String(); got != want {
+			t.Fatalf("request %d reached %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestProviderAnswers502WithoutUpstreams(t *testing.T) {
+	h := newTestHandler(t, newNamedUpstream(t, "a"))
+	h.pool.Store(upstream.NewPool(nil))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/v1/users/1", nil))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil || payload.Error != errNoProviderUpstream.Error() {
+		t.Fatalf("body = %s, want the no-upstream error", rec.Body)
+	}
+}
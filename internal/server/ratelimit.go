@@ -0,0 +1,88 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/ratelimit"
+	memberhandler "github.com/NoahCxrest/roblox-proxy-clustering/internal/server/member"
+)
+
+// clientLimits holds the per-client limiters built from one config.
+type clientLimits struct {
+	// fallback limits routes without a limit of their own. Nil lets them
+	// through.
+	fallback *ratelimit.Keyed
+	// routes holds a limiter per route listed in config.RouteRateLimits.
+	routes map[string]*ratelimit.Keyed
+}
+
+// newClientLimits builds the limiters for the rate limit settings of cfg, or
+// returns nil when no rate limit is configured.
+func newClientLimits(cfg config.Config) *clientLimits {
+	if cfg.RateLimitPerSecond <= 0 && len(cfg.RouteRateLimits) == 0 {
+		return nil
+	}
+
+	limits := &clientLimits{routes: make(map[string]*ratelimit.Keyed, len(cfg.RouteRateLimits))}
+	if cfg.RateLimitPerSecond > 0 {
+		limits.fallback = ratelimit.NewKeyed(cfg.RateLimitPerSecond, cfg.RateLimitBurst, cfg.RateLimitMaxClients)
+	}
+	for route, limit := range cfg.RouteRateLimits {
+		limits.routes[route] = ratelimit.NewKeyed(limit.PerSecond, limit.Burst, cfg.RateLimitMaxClients)
+	}
+	return limits
+}
+
+// forRoute returns the limiter for route, or nil when it is unlimited.
+func (l *clientLimits) forRoute(route string) *ratelimit.Keyed {
+	if limiter, ok := l.routes[route]; ok {
+		return limiter
+	}
+	return l.fallback
+}
+
+// RateLimit rejects requests from clients that have exhausted their per-IP
+// token bucket with 429 Too Many Requests.
+func RateLimit(next http.Handler, limiter *ratelimit.Keyed) http.Handler {
+	limits := &clientLimits{fallback: limiter}
+	return rateLimitWith(next, func() *clientLimits { return limits })
+}
+
+// rateLimitWith is RateLimit with the limiters looked up per request, so they
+// can be swapped at runtime. A route listed in the limits draws on its own
+// bucket per client, keyed by memberhandler.Route; every other route shares
+// the fallback bucket. Nil limits let every request through.
+func rateLimitWith(next http.Handler, current func() *clientLimits) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limits := current()
+		if limits == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		limiter := limits.forRoute(memberhandler.Route(r))
+		if limiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed, wait := limiter.Allow(proxy.ClientIP(r), time.Now())
+		if allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		apierror.Write(w, http.StatusTooManyRequests, apierror.CodeRateLimited, "rate limit exceeded")
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/ratelimit"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+// request serves target from remoteAddr through h and returns the response.
+func request(h http.Handler, target, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitAnswers429WithRetryAfter(t *testing.T) {
+	h := RateLimit(okHandler, ratelimit.NewKeyed(0.5, 2, 10))
+
+	for i := range 2 {
+		if rec := request(h, "/games/v1/games", "203.0.113.1:1000"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: status = %d, want 200", i, rec.Code)
+		}
+	}
+
+	rec := request(h, "/games/v1/games", "203.0.113.1:1001")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the burst: status = %d, want 429", rec.Code)
+	}
+	// One token every two seconds.
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want %q", got, "2")
+	}
+
+	if rec := request(h, "/games/v1/games", "203.0.113.2:1000"); rec.Code != http.StatusOK {
+		t.Fatalf("another client: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRouteRateLimitsUseTheirOwnBuckets(t *testing.T) {
+	limits := newClientLimits(config.Config{
+		RateLimitPerSecond:  0.001,
+		RateLimitBurst:      1,
+		RateLimitMaxClients: 10,
+		RouteRateLimits: map[string]config.RouteRateLimit{
+			"user-search": {PerSecond: 0.001, Burst: 2},
+		},
+	})
+	h := rateLimitWith(okHandler, func() *clientLimits { return limits })
+	const client = "203.0.113.1:1000"
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/?search=builderman", http.StatusOK},
+		{"/?search=roblox", http.StatusOK},
+		{"/?search=shedletsky", http.StatusTooManyRequests},
+		// Exhausting the search bucket leaves the shared bucket untouched.
+		{"/games/v1/games", http.StatusOK},
+		{"/?userId=1", http.StatusTooManyRequests},
+		// Path and letter case do not select a different bucket.
+		{"/GAMES/v2/games", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		if rec := request(h, tt.target, client); rec.Code != tt.want {
+			t.Fatalf("%s: status = %d, want %d", tt.target, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestNoRateLimitConfigured(t *testing.T) {
+	if limits := newClientLimits(config.Config{}); limits != nil {
+		t.Fatalf("limits = %+v, want nil without any rate limit", limits)
+	}
+
+	limits := newClientLimits(config.Config{
+		RateLimitMaxClients: 10,
+		RouteRateLimits:     map[string]config.RouteRateLimit{"batch": {PerSecond: 1, Burst: 1}},
+	})
+	h := rateLimitWith(okHandler, func() *clientLimits { return limits })
+	for range 5 {
+		if rec := request(h, "/games/v1/games", "203.0.113.1:1000"); rec.Code != http.StatusOK {
+			t.Fatalf("unlisted route without a default limit: status = %d, want 200", rec.Code)
+		}
+	}
+}
@@ -1,24 +1,212 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/apierror"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/buildinfo"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/cache"
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/metrics"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/proxy"
 	memberhandler "github.com/NoahCxrest/roblox-proxy-clustering/internal/server/member"
 	providerhandler "github.com/NoahCxrest/roblox-proxy-clustering/internal/server/provider"
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/upstream"
 )
 
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	metricsPath = "/metrics"
+	versionPath = "/version"
+)
+
+// Handler serves the operational endpoints and hands all other traffic to the
+// handler for the configured role.
+type Handler struct {
+	role   http.Handler
+	health *upstream.HealthChecker
+	// admin serves /admin/ endpoints. Nil when no admin token is configured.
+	admin *adminHandler
+	// debug serves /debug/ endpoints. Nil unless PROXY_DEBUG_ENDPOINTS is set.
+	debug *debugHandler
+	// warmup preloads the cache once at startup. May be nil.
+	warmup func(context.Context)
+	// reload applies reloaded settings to the role handler.
+	reload func(config.Config) error
+	// shutdown stops the role handler's background work. May be nil.
+	shutdown func(context.Context) error
+	// limits rate limit role traffic per client. Nil disables limiting.
+	limits atomic.Pointer[clientLimits]
+	// rateLimit holds the settings limiter was built from.
+	rateLimit rateLimitSettings
+	// version is the encoded /version response, rebuilt on reload.
+	version atomic.Pointer[[]byte]
+}
+
+type rateLimitSettings struct {
+	perSecond  float64
+	burst      int
+	maxClients int
+	routes     map[string]config.RouteRateLimit
+}
+
+func (s rateLimitSettings) equal(o rateLimitSettings) bool {
+	return s.perSecond == o.perSecond && s.burst == o.burst && s.maxClients == o.maxClients && maps.Equal(s.routes, o.routes)
+}
+
 // NewHandler constructs the appropriate HTTP handler based on the configured role.
-func NewHandler(cfg config.Config, logger *slog.Logger, cacheStore cache.Store, client *http.Client) (http.Handler, error) {
+func NewHandler(cfg config.Config, logger *slog.Logger, cacheStore cache.Store, client *http.Client, tracker *proxy.Tracker) (*Handler, error) {
+	h := &Handler{
+		admin: newAdminHandler(cfg, logger, cacheStore),
+		debug: newDebugHandler(cfg, logger, cacheStore),
+	}
+
 	switch cfg.Role {
 	case config.RoleMember:
-		return memberhandler.New(cfg, logger, cacheStore, client)
+		member, err := memberhandler.New(cfg, logger, cacheStore, client, tracker)
+		if err != nil {
+			return nil, err
+		}
+		h.role, h.health, h.warmup, h.reload = member, member.Health(), member.Warmup, member.Reload
+		h.shutdown = member.Shutdown
+		if h.admin != nil {
+			h.admin.keys = member.InvalidationKeys
+		}
 	case config.RoleProvider:
-		return providerhandler.New(cfg, logger, client)
+		provider, err := providerhandler.New(cfg, logger, client, tracker)
+		if err != nil {
+			return nil, err
+		}
+		h.role, h.health, h.reload = provider, provider.Health(), provider.Reload
 	default:
 		return nil, fmt.Errorf("unsupported role %q", cfg.Role)
 	}
+
+	if h.debug != nil {
+		h.debug.role, h.debug.health = string(cfg.Role), h.health
+	}
+
+	h.setVersion(cfg)
+	h.setRateLimit(cfg)
+	h.role = rateLimitWith(h.role, h.limits.Load)
+
+	return h, nil
+}
+
+// Reload applies the reloadable settings of cfg, which must come from
+// config.Reload, to the running handler.
+func (h *Handler) Reload(cfg config.Config) error {
+	if err := h.reload(cfg); err != nil {
+		return err
+	}
+	h.setVersion(cfg)
+	h.setRateLimit(cfg)
+	return nil
+}
+
+// setVersion encodes the /version response for cfg once, so serving it costs
+// nothing.
+func (h *Handler) setVersion(cfg config.Config) {
+	clusters := len(cfg.MemberClusters)
+	if cfg.Role == config.RoleProvider {
+		clusters = len(cfg.ProviderClusters)
+	}
+	body, err := json.Marshal(struct {
+		buildinfo.Info
+		Role     config.Role `json:"role"`
+		Clusters int         `json:"clusters"`
+	}{buildinfo.Get(), cfg.Role, clusters})
+	if err != nil {
+		body = []byte(`{}`)
+	}
+	h.version.Store(&body)
+}
+
+// setRateLimit installs limiters for the rate limit settings of cfg. Clients'
+// buckets are only reset when the settings change.
+func (h *Handler) setRateLimit(cfg config.Config) {
+	settings := rateLimitSettings{cfg.RateLimitPerSecond, cfg.RateLimitBurst, cfg.RateLimitMaxClients, cfg.RouteRateLimits}
+	if h.limits.Load() != nil && settings.equal(h.rateLimit) {
+		return
+	}
+	h.rateLimit = settings
+	h.limits.Store(newClientLimits(cfg))
+}
+
+// Run performs background work, such as cache warmup and target health
+// checks, until ctx is cancelled.
+func (h *Handler) Run(ctx context.Context) {
+	if h.warmup != nil {
+		go h.warmup(ctx)
+	}
+	if h.health != nil {
+		h.health.Run(ctx)
+	}
+}
+
+// Shutdown stops background work the role handler started outside Run, such
+// as cache refreshes, waiting until ctx is done for it to return.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	if h.shutdown == nil {
+		return nil
+	}
+	return h.shutdown(ctx)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case healthzPath:
+		writeJSON(w, http.StatusOK, []byte(`{"status":"ok"}`))
+	case readyzPath:
+		if h.health != nil && !h.health.AnyHealthy() {
+			writeJSON(w, http.StatusServiceUnavailable, []byte(`{"status":"unavailable"}`))
+			return
+		}
+		writeJSON(w, http.StatusOK, []byte(`{"status":"ready"}`))
+	case metricsPath:
+		metrics.Handler().ServeHTTP(w, r)
+	case versionPath:
+		writeJSON(w, http.StatusOK, *h.version.Load())
+	default:
+		if h.admin != nil && isAdminPath(r.URL.Path) {
+			h.admin.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, debugPathPrefix) {
+			if h.debug == nil {
+				apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "debug endpoints are disabled")
+				return
+			}
+			h.debug.ServeHTTP(w, r)
+			return
+		}
+		h.role.ServeHTTP(w, r)
+	}
+}
+
+// authenticates reports whether path is served by an endpoint that checks the
+// admin token itself.
+func (h *Handler) authenticates(path string) bool {
+	return h.admin != nil && isAdminPath(path) || h.debug != nil && strings.HasPrefix(path, debugPathPrefix)
+}
+
+// isAdminPath reports whether path is served by the admin handler.
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, adminPathPrefix) || path == cachePath || strings.HasPrefix(path, cachePathPrefix)
+}
+
+func writeJSON(w http.ResponseWriter, status int, payload []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	_, _ = w.Write(payload)
 }
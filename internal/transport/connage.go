@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// agedConn records when a connection was dialed.
+type agedConn struct {
+	net.Conn
+	dialed time.Time
+}
+
+// agedDial wraps dial so its connections record their age.
+func agedDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &agedConn{Conn: conn, dialed: time.Now()}, nil
+	}
+}
+
+// connAge returns how long ago conn, or the connection a TLS connection runs
+// over, was dialed through agedDial.
+func connAge(conn net.Conn) (time.Duration, bool) {
+	for conn != nil {
+		if aged, ok := conn.(*agedConn); ok {
+			return time.Since(aged.dialed), true
+		}
+		inner, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = inner.NetConn()
+	}
+	return 0, false
+}
+
+// maxAgeRoundTripper retires connections older than maxAge. A request that
+// is handed such a connection is sent with Connection: close, so the
+// connection is closed once its response has been read, never while a request
+// is using it, and the next request dials afresh. Long-lived connections thus
+// cannot pin traffic to one backend behind Roblox's load balancers.
+type maxAgeRoundTripper struct {
+	next   http.RoundTripper
+	maxAge time.Duration
+}
+
+func (m maxAgeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	var out *http.Request
+	trace := &httptrace.ClientTrace{
+		// GotConn runs before the request is written, on the goroutine
+		// sending it. A retry on another connection calls it again.
+		GotConn: func(info httptrace.GotConnInfo) {
+			age, ok := connAge(info.Conn)
+			out.Close = r.Close || ok && age > m.maxAge
+		},
+	}
+	out = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+	return m.next.RoundTrip(out)
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport.
+func (m maxAgeRoundTripper) CloseIdleConnections() {
+	closeIdle(m.next)
+}
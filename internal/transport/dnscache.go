@@ -0,0 +1,150 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// dnsLookupTimeout bounds a lookup when the dialer has no timeout of its own.
+const dnsLookupTimeout = 5 * time.Second
+
+// dialFunc matches http.Transport.DialContext.
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dnsCache resolves allow-listed hostnames once per TTL and dials the cached
+// addresses, saving a DNS round trip on new upstream connections. Any other
+// host, and any host whose lookup fails with nothing cached, is dialed as
+// usual through the system resolver.
+type dnsCache struct {
+	dialer   *net.Dialer
+	resolver *net.Resolver
+	ttl      time.Duration
+	// domains are the hostnames, and the parents of subdomains, to cache.
+	domains []string
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+	lookups singleflight.Group
+}
+
+type dnsEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+func newDNSCache(dialer *net.Dialer, ttl time.Duration, domains []string) *dnsCache {
+	c := &dnsCache{
+		dialer:   dialer,
+		resolver: net.DefaultResolver,
+		ttl:      ttl,
+		entries:  make(map[string]dnsEntry),
+	}
+	for _, d := range domains {
+		if d = strings.Trim(strings.ToLower(d), "."); d != "" {
+			c.domains = append(c.domains, d)
+		}
+	}
+	return c
+}
+
+// cached reports whether host is one of domains or a subdomain of one.
+func (c *dnsCache) cached(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, d := range c.domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialContext dials addr, using cached addresses for allow-listed hosts.
+func (c *dnsCache) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || !c.cached(host) {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	addrs, err := c.resolve(ctx, strings.ToLower(host))
+	if err != nil || len(addrs) == 0 {
+		return c.dialer.DialContext(ctx, network, addr)
+	}
+
+	var firstErr error
+	for _, ip := range addrs {
+		if !matchesNetwork(network, ip.IP) {
+			continue
+		}
+		conn, err := c.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	return nil, firstErr
+}
+
+// resolve returns the addresses of host, looking them up when the cached
+// entry has expired. A failed lookup keeps serving the expired entry, so a
+// DNS outage does not take down hosts that were reachable.
+func (c *dnsCache) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	v, err, _ := c.lookups.Do(host, func() (any, error) {
+		// The lookup is shared, so one caller going away must not cancel it
+		// for the others.
+		timeout := c.dialer.Timeout
+		if timeout <= 0 {
+			timeout = dnsLookupTimeout
+		}
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		return c.resolver.LookupIPAddr(lookupCtx, host)
+	})
+	if err != nil {
+		if ok {
+			return entry.addrs, nil
+		}
+		return nil, err
+	}
+	addrs := v.([]net.IPAddr)
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses for " + host)
+	}
+
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// matchesNetwork reports whether ip can be dialed on network, e.g. "tcp4".
+func matchesNetwork(network string, ip net.IP) bool {
+	switch network {
+	case "tcp4", "udp4":
+		return ip.To4() != nil
+	case "tcp6", "udp6":
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// maxPooledHosts bounds the hosts given a transport of their own. Direct
+// targets derive the host from the client's path, so the set is open-ended;
+// hosts past the limit share one transport.
+const maxPooledHosts = 64
+
+// hostPool sends each upstream host's requests through its own round tripper,
+// and so its own idle pool, so a burst to one Roblox service cannot claim
+// the idle connections another relies on.
+type hostPool struct {
+	newTransport func() http.RoundTripper
+
+	mu       sync.Mutex
+	hosts    map[string]http.RoundTripper
+	overflow http.RoundTripper
+}
+
+func newHostPool(newTransport func() http.RoundTripper) *hostPool {
+	return &hostPool{
+		newTransport: newTransport,
+		hosts:        make(map[string]http.RoundTripper),
+	}
+}
+
+func (p *hostPool) RoundTrip(r *http.Request) (*http.Response, error) {
+	return p.transportFor(strings.ToLower(r.URL.Host)).RoundTrip(r)
+}
+
+// transportFor returns the round tripper for host, creating it on first use.
+func (p *hostPool) transportFor(host string) http.RoundTripper {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rt, ok := p.hosts[host]; ok {
+		return rt
+	}
+	if len(p.hosts) >= maxPooledHosts {
+		if p.overflow == nil {
+			p.overflow = p.newTransport()
+		}
+		return p.overflow
+	}
+	rt := p.newTransport()
+	p.hosts[host] = rt
+	return rt
+}
+
+// CloseIdleConnections closes idle connections on every host's transport.
+func (p *hostPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, rt := range p.hosts {
+		closeIdle(rt)
+	}
+	if p.overflow != nil {
+		closeIdle(p.overflow)
+	}
+}
+
+func closeIdle(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -2,8 +2,10 @@ package transport
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
@@ -11,23 +13,112 @@ import (
 
 // NewHTTPClient constructs an http.Client tuned for low-latency proxying.
 func NewHTTPClient(cfg config.Config) *http.Client {
-	transport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 60 * time.Second}).DialContext,
+	return newClient(cfg, http.ProxyFromEnvironment)
+}
+
+// NewProxiedHTTPClient constructs a client like NewHTTPClient whose
+// connections are all dialed through proxyURL, such as a socks5:// proxy.
+func NewProxiedHTTPClient(cfg config.Config, proxyURL *url.URL) *http.Client {
+	return newClient(cfg, http.ProxyURL(proxyURL))
+}
+
+func newClient(cfg config.Config, proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: cfg.DialKeepAlive}
+	dial := dialer.DialContext
+	if cfg.DNSCacheEnabled {
+		dial = newDNSCache(dialer, cfg.DNSCacheTTL, cfg.DNSCacheDomains).DialContext
+	}
+	if cfg.MaxConnAge > 0 {
+		dial = agedDial(dial)
+	}
+
+	var rt http.RoundTripper
+	if cfg.UpstreamPoolPerHost {
+		rt = newHostPool(func() http.RoundTripper { return newRoundTripper(cfg, proxy, dial) })
+	} else {
+		rt = newRoundTripper(cfg, proxy, dial)
+	}
+	if cfg.MaxConnAge > 0 {
+		rt = maxAgeRoundTripper{next: rt, maxAge: cfg.MaxConnAge}
+	}
+
+	return &http.Client{
+		Transport:     rt,
+		Timeout:       cfg.TransportTimeout,
+		CheckRedirect: checkRedirect(cfg.MaxRedirects),
+	}
+}
+
+// checkRedirect stops a request after limit redirects. With limit zero the
+// redirect response itself is returned, so the forwarder relays it.
+func checkRedirect(limit int) func(*http.Request, []*http.Request) error {
+	return func(_ *http.Request, via []*http.Request) error {
+		if limit == 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > limit {
+			return fmt.Errorf("stopped after %d redirects", limit)
+		}
+		return nil
+	}
+}
+
+// newRoundTripper builds the transports for one connection pool: TLS, plus
+// cleartext HTTP/2 when PROXY_UPSTREAM_H2C is set.
+func newRoundTripper(cfg config.Config, proxy func(*http.Request) (*url.URL, error), dial dialFunc) http.RoundTripper {
+	tlsTransport := newTransport(cfg, proxy, dial)
+	if cfg.UpstreamHTTP2 {
+		tlsTransport.ForceAttemptHTTP2 = true
+	} else {
+		// A non-nil, empty TLSNextProto stops the transport from upgrading
+		// TLS connections to HTTP/2.
+		tlsTransport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	var rt http.RoundTripper = tlsTransport
+	if cfg.UpstreamH2C {
+		// With HTTP/1 absent from the protocol set, http:// requests use
+		// HTTP/2 with prior knowledge instead of HTTP/1.1.
+		cleartext := newTransport(cfg, proxy, dial)
+		cleartext.Protocols = new(http.Protocols)
+		cleartext.Protocols.SetUnencryptedHTTP2(true)
+		rt = schemeRoundTripper{https: tlsTransport, http: cleartext}
+	}
+	return rt
+}
+
+func newTransport(cfg config.Config, proxy func(*http.Request) (*url.URL, error), dial dialFunc) *http.Transport {
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
 		TLSHandshakeTimeout:   cfg.DialTimeout,
 		MaxIdleConns:          cfg.MaxIdleConns,
 		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
 		IdleConnTimeout:       cfg.IdleConnTimeout,
-		ForceAttemptHTTP2:     true,
 		ExpectContinueTimeout: 150 * time.Millisecond,
 		TLSClientConfig: &tls.Config{
 			MinVersion:         tls.VersionTLS12,
 			ClientSessionCache: tls.NewLRUClientSessionCache(512),
 		},
 	}
+}
 
-	return &http.Client{
-		Transport: transport,
-		Timeout:   cfg.TransportTimeout,
+// schemeRoundTripper sends http:// requests over the cleartext transport and
+// everything else over the TLS transport.
+type schemeRoundTripper struct {
+	https *http.Transport
+	http  *http.Transport
+}
+
+func (s schemeRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme == "http" {
+		return s.http.RoundTrip(r)
 	}
+	return s.https.RoundTrip(r)
+}
+
+// CloseIdleConnections closes idle connections on both transports.
+func (s schemeRoundTripper) CloseIdleConnections() {
+	s.https.CloseIdleConnections()
+	s.http.CloseIdleConnections()
 }
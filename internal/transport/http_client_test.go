@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NoahCxrest/roblox-proxy-clustering/internal/config"
+)
+
+// protoServer answers every request with the protocol it arrived over.
+var protoServer = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Request-Proto", r.Proto)
+})
+
+// newTLSServer starts a server offering HTTP/2 and HTTP/1.1 over TLS.
+func newTLSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(protoServer)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newCleartextServer starts a server accepting HTTP/1.1 and HTTP/2 with prior
+// knowledge over plain TCP.
+func newCleartextServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(protoServer)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// trust makes client's TLS transport accept srv's certificate.
+func trust(t *testing.T, client *http.Client, srv *httptest.Server) {
+	t.Helper()
+	var tlsTransport *http.Transport
+	switch rt := client.Transport.(type) {
+	case *http.Transport:
+		tlsTransport = rt
+	case schemeRoundTripper:
+		tlsTransport = rt.https
+	default:
+		t.Fatalf("unexpected transport %T", rt)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tlsTransport.TLSClientConfig.RootCAs = pool
+}
+
+func TestUpstreamProtocolNegotiation(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		cleartext bool
+		want      string
+	}{
+		{name: "h2 over TLS", cfg: config.Config{UpstreamHTTP2: true}, want: "HTTP/2.0"},
+		{name: "HTTP/1.1 over TLS when h2 is disabled", cfg: config.Config{}, want: "HTTP/1.1"},
+		{name: "h2c with prior knowledge", cfg: config.Config{UpstreamH2C: true}, cleartext: true, want: "HTTP/2.0"},
+		{name: "HTTP/1.1 in cleartext without h2c", cfg: config.Config{UpstreamHTTP2: true}, cleartext: true, want: "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.DialTimeout = 5 * time.Second
+			client := NewHTTPClient(tt.cfg)
+			var srv *httptest.Server
+			if tt.cleartext {
+				srv = newCleartextServer(t)
+			} else {
+				srv = newTLSServer(t)
+				trust(t, client, srv)
+			}
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.Proto != tt.want {
+				t.Fatalf("response protocol = %s, want %s", resp.Proto, tt.want)
+			}
+			if got := resp.Header.Get("X-Request-Proto"); got != tt.want {
+				t.Fatalf("server saw %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// newRedirectServer starts a server that answers /hops/N with a redirect to
+// /hops/N-1, and /hops/0 with 200.
+func newRedirectServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/hops/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if n > 0 {
+			http.Redirect(w, r, "/hops/"+strconv.Itoa(n-1), http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestUpstreamRedirects(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxRedirects int
+		hops         int
+		wantStatus   int
+		wantLocation string
+		wantErr      string
+	}{
+		{name: "followed within the limit", maxRedirects: 10, hops: 3, wantStatus: http.StatusOK},
+		{name: "followed up to the limit", maxRedirects: 3, hops: 3, wantStatus: http.StatusOK},
+		{name: "stopped past the limit", maxRedirects: 2, hops: 3, wantErr: "stopped after 2 redirects"},
+		{name: "relayed when not following", maxRedirects: 0, hops: 3, wantStatus: http.StatusFound, wantLocation: "/hops/2"},
+	}
+	srv := newRedirectServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewHTTPClient(config.Config{DialTimeout: 5 * time.Second, MaxRedirects: tt.maxRedirects})
+			resp, err := client.Get(srv.URL + "/hops/" + strconv.Itoa(tt.hops))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Location"); got != tt.wantLocation {
+				t.Fatalf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
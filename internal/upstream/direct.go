@@ -0,0 +1,86 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// DirectURLContext is the data available to the direct target URL template.
+type DirectURLContext struct {
+	// Service is the Roblox service named by the first path segment, such as
+	// "users".
+	Service string
+	// Path is the rest of the request path, escaped and starting with a slash.
+	Path string
+}
+
+// DirectURLTemplate maps requests for direct and SOCKS5 member targets to an
+// upstream URL other than https://<service>.<base domain><path>, for clusters
+// that reach Roblox through a rewriting gateway or mirror.
+type DirectURLTemplate struct {
+	tmpl *template.Template
+}
+
+// ParseDirectURLTemplate compiles text, which must render an absolute http or
+// https URL without a query or fragment.
+func ParseDirectURLTemplate(text string) (*DirectURLTemplate, error) {
+	tmpl, err := template.New("direct").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("direct target template: %w", err)
+	}
+	t := &DirectURLTemplate{tmpl: tmpl}
+	sample, err := t.render("users", "/v1/users/1")
+	if err != nil {
+		return nil, fmt.Errorf("direct target template: %w", err)
+	}
+	if sample.RawQuery != "" || sample.Fragment != "" {
+		return nil, errors.New("direct target template must not contain a query or fragment")
+	}
+	return t, nil
+}
+
+// Resolve renders the upstream URL for service and path, a decoded request
+// path, and sets rawQuery on it verbatim.
+func (t *DirectURLTemplate) Resolve(service, path, rawQuery string) (*url.URL, error) {
+	if !ValidServiceName(service) {
+		return nil, fmt.Errorf("invalid service name %q", service)
+	}
+	u, err := t.render(service, (&url.URL{Path: path}).EscapedPath())
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = rawQuery
+	return u, nil
+}
+
+func (t *DirectURLTemplate) render(service, escapedPath string) (*url.URL, error) {
+	var buf strings.Builder
+	if err := t.tmpl.Execute(&buf, DirectURLContext{Service: service, Path: escapedPath}); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(buf.String())
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("rendered URL %q is not an absolute http(s) URL", u.Redacted())
+	}
+	return u, nil
+}
+
+// ValidServiceName reports whether s can be substituted into a hostname: the
+// templates may place it there, so it must not smuggle in other URL parts.
+func ValidServiceName(s string) bool {
+	if s == "" || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+			return false
+		}
+	}
+	return true
+}
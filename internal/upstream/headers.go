@@ -0,0 +1,104 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// HeaderContext is the data available to per-target header templates.
+type HeaderContext struct {
+	// Path is the request path being routed.
+	Path string
+	// Query is the raw query string being routed.
+	Query string
+	// Hash is the consistent-hash value of the routing key.
+	Hash uint32
+	// Target is the configured identity of the selected target.
+	Target string
+}
+
+// sampleHeaderContext is the context header templates are rendered against
+// when they are compiled, so a template that cannot render fails at startup
+// rather than on every request routed to its target.
+var sampleHeaderContext = HeaderContext{
+	Path:   "/users/v1/users/1",
+	Query:  "fields=name",
+	Hash:   1,
+	Target: "https://users.roblox.com",
+}
+
+// ParseHeaderTemplate compiles the template for header name and checks that
+// it renders a valid header value.
+func ParseHeaderTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, sampleHeaderContext); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(buf.String(), "\r\n") {
+		return nil, errors.New("rendered value contains a line break")
+	}
+	return tmpl, nil
+}
+
+// AttachHeaderTemplates compiles per-target header templates and attaches them
+// to the matching static targets. Templates are keyed by the target as it
+// appears in the member cluster list.
+func AttachHeaderTemplates(targets []MemberTarget, raw map[string]map[string]string) error {
+	for rawTarget, headers := range raw {
+		parsed, err := ParseMemberTargets([]string{rawTarget})
+		if err != nil {
+			return fmt.Errorf("header templates: %w", err)
+		}
+		if parsed[0].Kind != MemberTargetStatic {
+			return fmt.Errorf("header templates: target %q is not a static member target", rawTarget)
+		}
+
+		idx := -1
+		for i, t := range targets {
+			if t.String() == parsed[0].String() {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return fmt.Errorf("header templates: target %q is not a configured member target", rawTarget)
+		}
+
+		compiled := make(map[string]*template.Template, len(headers))
+		for name, text := range headers {
+			tmpl, err := ParseHeaderTemplate(name, text)
+			if err != nil {
+				return fmt.Errorf("header template %q for %q: %w", name, rawTarget, err)
+			}
+			compiled[http.CanonicalHeaderKey(name)] = tmpl
+		}
+		targets[idx].Headers = compiled
+	}
+
+	return nil
+}
+
+// RenderHeaders evaluates the target's header templates against ctx.
+func (t MemberTarget) RenderHeaders(ctx HeaderContext) (http.Header, error) {
+	if len(t.Headers) == 0 {
+		return nil, nil
+	}
+
+	out := make(http.Header, len(t.Headers))
+	var buf strings.Builder
+	for name, tmpl := range t.Headers {
+		buf.Reset()
+		if err := tmpl.Execute(&buf, ctx); err != nil {
+			return nil, fmt.Errorf("render header %q: %w", name, err)
+		}
+		out.Set(name, buf.String())
+	}
+	return out, nil
+}
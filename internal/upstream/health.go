@@ -0,0 +1,240 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HealthProbe describes how a target is checked.
+type HealthProbe struct {
+	Method         string
+	ExpectedStatus int
+	Interval       time.Duration
+	Timeout        time.Duration
+}
+
+// HealthCheck is a single target registered with a HealthChecker.
+type HealthCheck struct {
+	// Name identifies the target, typically as it was configured.
+	Name string
+	// Kind classifies the target for reporting, e.g. "static" or "provider".
+	Kind  string
+	URL   *url.URL
+	Probe HealthProbe
+	// Client overrides the checker's client for targets reached through their
+	// own transport. May be nil.
+	Client *http.Client
+}
+
+// TargetHealth is the last observed health of a target.
+type TargetHealth struct {
+	Healthy   bool
+	LastCheck time.Time
+	// Failures counts consecutive failed probes.
+	Failures  int
+	LastError string
+}
+
+// HealthChecker probes targets in the background and records their health.
+// Targets are reported unhealthy until their first successful probe.
+type HealthChecker struct {
+	client *http.Client
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	checks []HealthCheck
+	status []TargetHealth
+	// gen increments on every Replace so probes of replaced checks are not
+	// recorded against the new ones.
+	gen      uint64
+	replaced chan struct{}
+}
+
+// TargetReport is the health of one target at a point in time.
+type TargetReport struct {
+	Check  HealthCheck
+	Health TargetHealth
+}
+
+// NewHealthChecker constructs a checker for the given targets.
+func NewHealthChecker(client *http.Client, logger *slog.Logger, checks []HealthCheck) *HealthChecker {
+	return &HealthChecker{
+		client:   client,
+		logger:   logger.With(slog.String("component", "health-checker")),
+		checks:   checks,
+		status:   make([]TargetHealth, len(checks)),
+		replaced: make(chan struct{}, 1),
+	}
+}
+
+// Run probes every target on its interval until ctx is cancelled, restarting
+// the probes whenever the checks are replaced.
+func (c *HealthChecker) Run(ctx context.Context) {
+	for {
+		c.mu.RLock()
+		checks, gen := c.checks, c.gen
+		c.mu.RUnlock()
+
+		runCtx, cancel := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.loop(runCtx, gen, i, check)
+			}()
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-c.replaced:
+		}
+		cancel()
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// Replace swaps in a new set of checks. Every target starts out unhealthy
+// again until its first successful probe.
+func (c *HealthChecker) Replace(checks []HealthCheck) {
+	c.mu.Lock()
+	c.checks = checks
+	c.status = make([]TargetHealth, len(checks))
+	c.gen++
+	c.mu.Unlock()
+
+	select {
+	case c.replaced <- struct{}{}:
+	default:
+	}
+}
+
+// Len reports the number of targets being checked.
+func (c *HealthChecker) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.checks)
+}
+
+// Check returns the registered check at index i.
+func (c *HealthChecker) Check(i int) HealthCheck {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.checks[i]
+}
+
+// Status returns the last observed health of the target at index i.
+func (c *HealthChecker) Status(i int) TargetHealth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status[i]
+}
+
+// Snapshot returns every target with its last observed health, in the order
+// the checks were registered.
+func (c *HealthChecker) Snapshot() []TargetReport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	reports := make([]TargetReport, len(c.checks))
+	for i, check := range c.checks {
+		reports[i] = TargetReport{Check: check, Health: c.status[i]}
+	}
+	return reports
+}
+
+// AnyHealthy reports whether at least one target is healthy.
+func (c *HealthChecker) AnyHealthy() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, st := range c.status {
+		if st.Healthy {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportFailure marks the target at index i unhealthy after a failed request,
+// without waiting for its next probe.
+func (c *HealthChecker) ReportFailure(i int, err error) {
+	c.mu.RLock()
+	gen := c.gen
+	c.mu.RUnlock()
+	c.record(gen, i, err)
+}
+
+func (c *HealthChecker) loop(ctx context.Context, gen uint64, i int, check HealthCheck) {
+	ticker := time.NewTicker(check.Probe.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.record(gen, i, c.probe(ctx, check))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *HealthChecker) probe(ctx context.Context, check HealthCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, check.Probe.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, check.Probe.Method, check.URL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	client := c.client
+	if check.Client != nil {
+		client = check.Client
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode != check.Probe.ExpectedStatus {
+		return fmt.Errorf("unexpected status %d, want %d", resp.StatusCode, check.Probe.ExpectedStatus)
+	}
+	return nil
+}
+
+func (c *HealthChecker) record(gen uint64, i int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || i < 0 || i >= len(c.status) {
+		return
+	}
+	st := &c.status[i]
+	wasHealthy := st.Healthy
+	st.LastCheck = time.Now()
+	if err != nil {
+		st.Healthy = false
+		st.Failures++
+		st.LastError = err.Error()
+	} else {
+		st.Healthy = true
+		st.Failures = 0
+		st.LastError = ""
+	}
+
+	if wasHealthy != st.Healthy {
+		c.logger.Info("target health changed", slog.String("target", c.checks[i].Name), slog.Bool("healthy", st.Healthy), slog.String("error", st.LastError))
+	}
+}
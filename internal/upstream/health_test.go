@@ -0,0 +1,84 @@
+package upstream
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// probeOnce runs a checker over a single check until its first probe is
+// recorded and returns the resulting health.
+func probeOnce(t *testing.T, client *http.Client, check HealthCheck) TargetHealth {
+	t.Helper()
+	c := NewHealthChecker(client, slog.New(slog.DiscardHandler), []HealthCheck{check})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Status(0).LastCheck.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the first probe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return c.Status(0)
+}
+
+func TestHealthProbeUsesConfiguredPathMethodAndStatus(t *testing.T) {
+	// The target is only ready when asked with HEAD on /ready, and answers
+	// 204 rather than 200.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead || r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	base, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		probe   HealthProbe
+		healthy bool
+	}{
+		{name: "configured probe", path: "/ready", probe: HealthProbe{Method: http.MethodHead, ExpectedStatus: http.StatusNoContent}, healthy: true},
+		{name: "wrong path", path: "/healthz", probe: HealthProbe{Method: http.MethodHead, ExpectedStatus: http.StatusNoContent}},
+		{name: "wrong method", path: "/ready", probe: HealthProbe{Method: http.MethodGet, ExpectedStatus: http.StatusNoContent}},
+		{name: "wrong status", path: "/ready", probe: HealthProbe{Method: http.MethodHead, ExpectedStatus: http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.probe.Interval = time.Hour
+			tt.probe.Timeout = 5 * time.Second
+			health := probeOnce(t, srv.Client(), HealthCheck{Name: "member", URL: base.JoinPath(tt.path), Probe: tt.probe})
+
+			if health.Healthy != tt.healthy {
+				t.Fatalf("healthy = %v, want %v (last error %q)", health.Healthy, tt.healthy, health.LastError)
+			}
+			if !tt.healthy && !strings.HasPrefix(health.LastError, "unexpected status") {
+				t.Fatalf("last error = %q, want an unexpected status", health.LastError)
+			}
+			if !tt.healthy && health.Failures != 1 {
+				t.Fatalf("failures = %d, want 1", health.Failures)
+			}
+		})
+	}
+}
@@ -3,7 +3,9 @@ package upstream
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"text/template"
 )
 
 // MemberTargetKind represents the strategy used by a member node to contact Roblox.
@@ -13,15 +15,59 @@ const (
 	MemberTargetUnknown MemberTargetKind = iota
 	MemberTargetDirect
 	MemberTargetStatic
+	// MemberTargetSocks5 reaches Roblox directly but dials through a SOCKS5
+	// proxy; Base holds the proxy URL.
+	MemberTargetSocks5
 )
 
+// String returns the scheme-like name of the kind, e.g. "static".
+func (k MemberTargetKind) String() string {
+	switch k {
+	case MemberTargetDirect:
+		return "direct"
+	case MemberTargetStatic:
+		return "static"
+	case MemberTargetSocks5:
+		return "socks5"
+	default:
+		return "unknown"
+	}
+}
+
+// maxMemberTargetWeight bounds the weight parameter, which multiplies the
+// target's replicas on the hash ring.
+const maxMemberTargetWeight = 100
+
 // MemberTarget represents an upstream endpoint a member node can use.
 type MemberTarget struct {
 	Kind MemberTargetKind
 	Base *url.URL
+	// Weight is the target's share of keys relative to other targets, set by
+	// a weight query parameter and 1 when absent. A target of weight 0 only
+	// serves as a fallback after every weighted target.
+	Weight int
+	// Headers holds templates for headers injected into requests routed to
+	// the target, keyed by canonical header name.
+	Headers map[string]*template.Template
+}
+
+// String returns the identity of the target as it was configured.
+func (t MemberTarget) String() string {
+	switch t.Kind {
+	case MemberTargetDirect:
+		return "direct://"
+	case MemberTargetStatic:
+		return t.Base.String()
+	case MemberTargetSocks5:
+		return t.Base.Redacted()
+	default:
+		return ""
+	}
 }
 
-// ParseMemberTargets converts raw strings into structured member targets.
+// ParseMemberTargets converts raw strings into structured member targets. Any
+// target may carry a weight query parameter, e.g. "https://proxy?weight=3" or
+// "direct://?weight=0".
 func ParseMemberTargets(raw []string) ([]MemberTarget, error) {
 	if len(raw) == 0 {
 		return nil, fmt.Errorf("no member targets provided")
@@ -29,25 +75,72 @@ func ParseMemberTargets(raw []string) ([]MemberTarget, error) {
 
 	targets := make([]MemberTarget, 0, len(raw))
 	for _, v := range raw {
-		if strings.EqualFold(v, "direct://") {
-			targets = append(targets, MemberTarget{Kind: MemberTargetDirect})
-			continue
-		}
-
 		u, err := url.Parse(v)
 		if err != nil {
 			return nil, fmt.Errorf("parse member target %q: %w", v, err)
 		}
+		name := u.Redacted()
+		weight, err := takeWeight(u)
+		if err != nil {
+			return nil, fmt.Errorf("member target %q: %w", name, err)
+		}
+
+		if strings.EqualFold(u.Scheme, "direct") && u.Host == "" && u.Path == "" && u.RawQuery == "" {
+			targets = append(targets, MemberTarget{Kind: MemberTargetDirect, Weight: weight})
+			continue
+		}
+
+		if u.Scheme == "socks5" {
+			target, err := parseSocks5Target(u)
+			if err != nil {
+				return nil, fmt.Errorf("member target %q: %w", u.Redacted(), err)
+			}
+			target.Weight = weight
+			targets = append(targets, target)
+			continue
+		}
 
 		if u.Scheme != "http" && u.Scheme != "https" {
-			return nil, fmt.Errorf("member target %q must use http or https scheme", v)
+			return nil, fmt.Errorf("member target %q must use http, https or socks5 scheme", u.Redacted())
 		}
 
 		// Normalize to ensure trailing slash removed for stable path joins.
 		u.Path = strings.TrimRight(u.Path, "/")
 
-		targets = append(targets, MemberTarget{Kind: MemberTargetStatic, Base: u})
+		targets = append(targets, MemberTarget{Kind: MemberTargetStatic, Base: u, Weight: weight})
 	}
 
 	return targets, nil
 }
+
+// takeWeight removes the weight parameter from u's query and returns it,
+// defaulting to 1. The rest of the query is left as written.
+func takeWeight(u *url.URL) (int, error) {
+	query := u.Query()
+	if !query.Has("weight") {
+		return 1, nil
+	}
+	raw := query.Get("weight")
+	query.Del("weight")
+	u.RawQuery = query.Encode()
+
+	weight, err := strconv.Atoi(raw)
+	if err != nil || weight < 0 || weight > maxMemberTargetWeight {
+		return 0, fmt.Errorf("weight must be an integer between 0 and %d", maxMemberTargetWeight)
+	}
+	return weight, nil
+}
+
+// parseSocks5Target validates a socks5://[user[:password]@]host:port target.
+func parseSocks5Target(u *url.URL) (MemberTarget, error) {
+	if u.Hostname() == "" || u.Port() == "" {
+		return MemberTarget{}, fmt.Errorf("socks5 proxy must specify host and port")
+	}
+	if u.Path != "" && u.Path != "/" || u.RawQuery != "" {
+		return MemberTarget{}, fmt.Errorf("socks5 proxy must not have a path or query")
+	}
+	if u.User != nil && u.User.Username() == "" {
+		return MemberTarget{}, fmt.Errorf("socks5 proxy credentials must include a username")
+	}
+	return MemberTarget{Kind: MemberTargetSocks5, Base: &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}}, nil
+}
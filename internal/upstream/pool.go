@@ -0,0 +1,33 @@
+package upstream
+
+import (
+	"net/url"
+	"sync/atomic"
+)
+
+// Pool hands out upstream base URLs in round-robin order. It is safe for
+// concurrent use.
+type Pool struct {
+	targets []*url.URL
+	next    atomic.Uint64
+}
+
+// NewPool constructs a round-robin pool over targets.
+func NewPool(targets []*url.URL) *Pool {
+	return &Pool{targets: append([]*url.URL(nil), targets...)}
+}
+
+// Next returns the next target in rotation, or false when the pool is empty.
+func (p *Pool) Next() (*url.URL, bool) {
+	if len(p.targets) == 0 {
+		return nil, false
+	}
+
+	n := p.next.Add(1) - 1
+	return p.targets[n%uint64(len(p.targets))], true
+}
+
+// Len reports the number of targets in the pool.
+func (p *Pool) Len() int {
+	return len(p.targets)
+}
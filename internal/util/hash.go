@@ -1,16 +1,13 @@
 package util
 
-import (
-	"hash/fnv"
-)
-
 // ConsistentIndex computes a stable shard index for the provided string.
+//
+// The index is derived with modulo hashing, so changing the bucket count remaps
+// almost every key. Prefer HashRing when the bucket set can change at runtime.
 func ConsistentIndex(key string, buckets int) int {
 	if buckets <= 0 {
 		return 0
 	}
 
-	h := fnv.New32a()
-	_, _ = h.Write([]byte(key))
-	return int(h.Sum32() % uint32(buckets))
+	return int(Hash(key) % uint32(buckets))
 }
@@ -0,0 +1,19 @@
+package util
+
+import "net/url"
+
+// CanonicalQuery returns raw with parameters sorted by key, matching the
+// encoding url.Values.Encode produces for internally built queries. Repeated
+// keys keep their relative order. Queries that fail to parse are returned
+// unchanged.
+func CanonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	return values.Encode()
+}
@@ -0,0 +1,24 @@
+package util
+
+import "testing"
+
+func TestCanonicalQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "empty", raw: "", want: ""},
+		{name: "sorted by key", raw: "limit=10&cursor=abc", want: "cursor=abc&limit=10"},
+		{name: "repeated keys keep their order", raw: "id=2&sort=asc&id=1", want: "id=2&id=1&sort=asc"},
+		{name: "encoding normalized", raw: "keyword=a%20b", want: "keyword=a+b"},
+		{name: "unparseable kept", raw: "q=%zz&a=1", want: "q=%zz&a=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalQuery(tt.raw); got != tt.want {
+				t.Fatalf("CanonicalQuery(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
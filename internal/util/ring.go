@@ -0,0 +1,138 @@
+package util
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// HashRing maps keys onto a set of nodes using consistent hashing so that adding
+// or removing a node only remaps the keys owned by that node.
+type HashRing struct {
+	nodes  []string
+	points []ringPoint
+	// overflow lists, in order, the nodes with no points of their own. They
+	// never own a key and only extend Sequence once every other node is used.
+	overflow []int
+}
+
+type ringPoint struct {
+	hash uint32
+	node int
+}
+
+// NewHashRing builds a ring placing each node at the given number of virtual
+// replicas. Replicas below one are treated as one.
+func NewHashRing(nodes []string, replicas int) *HashRing {
+	return NewWeightedHashRing(nodes, nil, replicas)
+}
+
+// NewWeightedHashRing builds a ring placing each node at replicas times its
+// weight virtual replicas, so it owns a share of keys proportional to its
+// weight. A node missing from weights has weight one; a node of weight zero
+// owns no keys and is only used as overflow by Sequence. Replicas below one
+// are treated as one.
+func NewWeightedHashRing(nodes []string, weights []int, replicas int) *HashRing {
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	r := &HashRing{
+		nodes:  append([]string(nil), nodes...),
+		points: make([]ringPoint, 0, len(nodes)*replicas),
+	}
+
+	for idx, node := range r.nodes {
+		weight := 1
+		if idx < len(weights) {
+			weight = max(weights[idx], 0)
+		}
+		if weight == 0 {
+			r.overflow = append(r.overflow, idx)
+			continue
+		}
+		// Replica numbering is the same at every weight, so reweighting a
+		// node only moves the keys its added or removed replicas own.
+		for i := 0; i < replicas*weight; i++ {
+			r.points = append(r.points, ringPoint{hash: Hash(node + "#" + strconv.Itoa(i)), node: idx})
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash == r.points[j].hash {
+			return r.points[i].node < r.points[j].node
+		}
+		return r.points[i].hash < r.points[j].hash
+	})
+
+	return r
+}
+
+// Len reports the number of nodes on the ring.
+func (r *HashRing) Len() int {
+	return len(r.nodes)
+}
+
+// Index returns the position in the original node list that owns key, or -1 when
+// the ring is empty.
+func (r *HashRing) Index(key string) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+
+	h := Hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// Sequence returns up to n distinct node positions in the order met walking the
+// ring clockwise from key. The first is the owner reported by Index, and the
+// rest are the nodes key falls to as earlier ones are removed. Overflow nodes
+// of weight zero follow all others, in their original order.
+func (r *HashRing) Sequence(key string, n int) []int {
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	n = min(n, len(r.nodes))
+
+	seen := make(map[int]struct{}, n)
+	out := make([]int, 0, n)
+	if len(r.points) > 0 {
+		h := Hash(key)
+		start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+		for i := 0; i < len(r.points) && len(out) < n; i++ {
+			node := r.points[(start+i)%len(r.points)].node
+			if _, ok := seen[node]; ok {
+				continue
+			}
+			seen[node] = struct{}{}
+			out = append(out, node)
+		}
+	}
+	for _, node := range r.overflow {
+		if len(out) == n {
+			break
+		}
+		out = append(out, node)
+	}
+	return out
+}
+
+// Get returns the node that owns key, or an empty string when the ring is empty.
+func (r *HashRing) Get(key string) string {
+	idx := r.Index(key)
+	if idx < 0 {
+		return ""
+	}
+	return r.nodes[idx]
+}
+
+// Hash returns the 32-bit FNV-1a hash used for ring placement.
+func Hash(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
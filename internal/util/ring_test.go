@@ -0,0 +1,100 @@
+package util
+
+import (
+	"strconv"
+	"testing"
+)
+
+const (
+	ringTestKeys     = 20000
+	ringTestReplicas = 128
+)
+
+func ringTestKey(i int) string {
+	return "roblox:user:" + strconv.Itoa(i)
+}
+
+func TestHashRingSpreadsKeysEvenly(t *testing.T) {
+	nodes := []string{"http://member-a:8080", "http://member-b:8080", "http://member-c:8080", "http://member-d:8080"}
+	r := NewHashRing(nodes, ringTestReplicas)
+
+	counts := make(map[string]int, len(nodes))
+	for i := 0; i < ringTestKeys; i++ {
+		counts[r.Get(ringTestKey(i))]++
+	}
+
+	// FNV-1a places the replicas of similar node names somewhat unevenly, so
+	// the bound is loose: it catches a node owning next to nothing or most
+	// of the keys.
+	fair := ringTestKeys / len(nodes)
+	for _, node := range nodes {
+		if n := counts[node]; n < fair/2 || n > fair*3/2 {
+			t.Errorf("%s owns %d keys, want within 50%% of %d", node, n, fair)
+		}
+	}
+}
+
+func TestHashRingRemapsOnlyAffectedKeys(t *testing.T) {
+	nodes := []string{"http://member-a:8080", "http://member-b:8080", "http://member-c:8080"}
+	before := NewHashRing(nodes, ringTestReplicas)
+
+	t.Run("add", func(t *testing.T) {
+		const added = "http://member-d:8080"
+		after := NewHashRing(append(append([]string(nil), nodes...), added), ringTestReplicas)
+
+		moved := 0
+		for i := 0; i < ringTestKeys; i++ {
+			was, now := before.Get(ringTestKey(i)), after.Get(ringTestKey(i))
+			if was == now {
+				continue
+			}
+			moved++
+			if now != added {
+				t.Fatalf("key %d moved from %s to %s, want only moves to the added node", i, was, now)
+			}
+		}
+		// The new node should take roughly its fair quarter of the keys.
+		if max := ringTestKeys / 2; moved == 0 || moved > max {
+			t.Fatalf("%d keys moved, want between 1 and %d", moved, max)
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		removed := nodes[1]
+		after := NewHashRing([]string{nodes[0], nodes[2]}, ringTestReplicas)
+
+		for i := 0; i < ringTestKeys; i++ {
+			was, now := before.Get(ringTestKey(i)), after.Get(ringTestKey(i))
+			if was != removed && was != now {
+				t.Fatalf("key %d moved from %s to %s, but only keys of the removed node should move", i, was, now)
+			}
+		}
+	})
+}
+
+func TestHashRingSequenceStartsAtOwner(t *testing.T) {
+	r := NewHashRing([]string{"a", "b", "c"}, ringTestReplicas)
+	for i := 0; i < 100; i++ {
+		key := ringTestKey(i)
+		seq := r.Sequence(key, 3)
+		if len(seq) != 3 || seq[0] != r.Index(key) {
+			t.Fatalf("Sequence(%q) = %v, want 3 nodes starting at owner %d", key, seq, r.Index(key))
+		}
+		if seq[0] == seq[1] || seq[1] == seq[2] || seq[0] == seq[2] {
+			t.Fatalf("Sequence(%q) = %v, want distinct nodes", key, seq)
+		}
+	}
+}
+
+func TestEmptyHashRing(t *testing.T) {
+	r := NewHashRing(nil, ringTestReplicas)
+	if idx := r.Index("key"); idx != -1 {
+		t.Fatalf("Index = %d, want -1", idx)
+	}
+	if node := r.Get("key"); node != "" {
+		t.Fatalf("Get = %q, want empty", node)
+	}
+	if seq := r.Sequence("key", 2); seq != nil {
+		t.Fatalf("Sequence = %v, want nil", seq)
+	}
+}
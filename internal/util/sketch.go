@@ -0,0 +1,89 @@
+package util
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+const sketchDepth = 4
+
+// FrequencySketch estimates how often keys have been seen using a count-min
+// sketch. Counts are halved periodically so the estimate favours recent
+// activity over historical totals.
+type FrequencySketch struct {
+	mu        sync.Mutex
+	rows      [sketchDepth][]uint16
+	width     uint64
+	additions int
+	resetAt   int
+}
+
+// NewFrequencySketch builds a sketch with width counters per row. Widths below
+// 64 are raised to 64.
+func NewFrequencySketch(width int) *FrequencySketch {
+	if width < 64 {
+		width = 64
+	}
+	s := &FrequencySketch{width: uint64(width), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint16, width)
+	}
+	return s
+}
+
+// Add records one occurrence of key and returns its updated estimate.
+func (s *FrequencySketch) Add(key string) int {
+	h1, h2 := sketchHashes(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := math.MaxUint16
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) % s.width
+		if s.rows[i][idx] < math.MaxUint16 {
+			s.rows[i][idx]++
+		}
+		estimate = min(estimate, int(s.rows[i][idx]))
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.age()
+	}
+	return estimate
+}
+
+// Estimate returns the approximate recent count for key.
+func (s *FrequencySketch) Estimate(key string) int {
+	h1, h2 := sketchHashes(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := math.MaxUint16
+	for i := range s.rows {
+		estimate = min(estimate, int(s.rows[i][(h1+uint64(i)*h2)%s.width]))
+	}
+	return estimate
+}
+
+// age halves every counter so stale popularity decays.
+func (s *FrequencySketch) age() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+func sketchHashes(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	sum := h.Sum64()
+	// Double hashing: row i probes h1 + i*h2. Forcing h2 odd keeps the probes
+	// distinct.
+	return sum & 0xffffffff, (sum >> 32) | 1
+}